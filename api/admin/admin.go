@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"pottogether/config"
+	"pottogether/internal/jobs"
+	"pottogether/internal/s3"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRunLimit = 20
+	maxRunLimit     = 100
+)
+
+func GetJobs(c *gin.Context) {
+	jobList, err := query.GetJobs(c.Request.Context())
+	if err != nil {
+		errhandler.Error(c, err, "Error getting jobs")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      jobList,
+		"message":   "Jobs retrieved successfully",
+	})
+}
+
+func GetJobRuns(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultRunLimit)))
+	if err != nil || limit <= 0 || limit > maxRunLimit {
+		errhandler.Info(c, fmt.Errorf("limit must be between 1 and %d", maxRunLimit), "Invalid limit")
+		return
+	}
+	if err := query.CheckJob(c.Request.Context(), c.Param("name")); err != nil {
+		if err.Error() == "job does not exist" {
+			errhandler.Info(c, err, "Error getting job runs")
+			return
+		}
+		errhandler.Error(c, err, "Error getting job runs")
+		return
+	}
+	runs, err := query.GetJobRuns(c.Request.Context(), c.Param("name"), limit)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting job runs")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      runs,
+		"message":   "Job runs retrieved successfully",
+	})
+}
+
+// RunJob starts the job right away, it runs in the background
+func RunJob(c *gin.Context) {
+	runID, err := jobs.Trigger(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		if err.Error() == "job does not exist" || err.Error() == "job is already running" {
+			errhandler.Info(c, err, "Error running job")
+			return
+		}
+		errhandler.Error(c, err, "Error running job")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"runID": runID,
+		},
+		"message": "Job started successfully",
+	})
+}
+
+// GetOrphanedMedia reports the images media_gc would delete, nothing is deleted
+func GetOrphanedMedia(c *gin.Context) {
+	grace := time.Duration(config.Viper.GetInt("MEDIA_GC_GRACE_HOURS")) * time.Hour
+	report, err := s3.CollectOrphans(c.Request.Context(), grace, true)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting orphaned media")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      report,
+		"message":   "Orphaned media retrieved successfully",
+	})
+}
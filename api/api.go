@@ -3,81 +3,191 @@ package api
 import (
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"pottogether/api/admin"
+	"pottogether/api/chat"
+	"pottogether/api/comment"
+	"pottogether/api/friend"
+	"pottogether/api/health"
 	"pottogether/api/ingredient"
+	"pottogether/api/leaderboard"
+	"pottogether/api/notification"
 	"pottogether/api/record"
 	"pottogether/api/room"
+	"pottogether/api/schedule"
+	"pottogether/api/session"
+	"pottogether/api/upload"
 	"pottogether/api/user"
 	"pottogether/config"
 	"pottogether/internal/auth"
+	"pottogether/internal/groupsession"
+	"pottogether/internal/hash"
+	"pottogether/internal/jobs"
+	"pottogether/internal/mail"
+	"pottogether/internal/metrics"
+	"pottogether/internal/notify"
+	"pottogether/internal/ratelimit"
+	"pottogether/internal/realtime"
+	"pottogether/internal/tracing"
 	"pottogether/pkg/logger"
 	"pottogether/pkg/mariadb"
+	"pottogether/pkg/mariadb/query"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 var err error
 
-func API_init(LOG_PATH string) {
+// shutdownTracing flushes the spans still buffered on shutdown
+var shutdownTracing = func(context.Context) error { return nil }
+
+// API_init sets up the dependencies of the server, failing if one it cannot
+// run without is unavailable
+func API_init(LOG_PATH string) error {
 	// Load configuration
 	if config.LoadConfig() == nil {
-		fmt.Println("Error loading config file")
-		return
+		return fmt.Errorf("error loading config file")
 	}
 	// Init Logger
 	logger.InitLogger(config.Viper.GetString(LOG_PATH))
 	logger.Log.Info("Logger enabled, log file: " + config.Viper.GetString(LOG_PATH))
+	// Init tracing before anything that may be traced
+	if shutdownTracing, err = tracing.Init(); err != nil {
+		logger.Error("Error setting up tracing: " + err.Error())
+		return err
+	}
 	// Init JWT
 	auth.SetJWTKey()
+	// Load the breached passwords rejected by the password policy
+	if err = hash.LoadBreachedList(); err != nil {
+		logger.Error("Error loading breached password list: " + err.Error())
+		return err
+	}
 	// Connect to MySQL
 	if err = mariadb.Connect_init(); err != nil {
 		logger.Error("Error connecting to mariadb: " + err.Error())
-		return
+		return err
 	}
 	logger.Info("MariaDB connected")
+	// Bring the schema up to date
+	if dir := config.Viper.GetString("MARIADB_MIGRATIONS_DIR"); dir != "" {
+		if err = mariadb.Migrate(context.Background(), dir); err != nil {
+			logger.Error("Error migrating mariadb: " + err.Error())
+			return err
+		}
+	}
+	metrics.RegisterDB(mariadb.DB)
+	metrics.RegisterActiveRecords(query.CountActiveRecords)
+	// Set up push notifications before starting anything in the background,
+	// bad push credentials stop the server like the other dependencies
+	if err = notify.Init(); err != nil {
+		logger.Error("Error setting up push notifications: " + err.Error())
+		return err
+	}
+	// Resume group sessions
+	if err = groupsession.Resume(context.Background()); err != nil {
+		logger.Error("Error resuming group sessions: " + err.Error())
+	}
+	// Start push notification delivery
+	notify.Start()
+	// Start delivering room events published by other instances
+	realtime.Start()
+	// Init mailer
+	mail.Init()
+	// Start background jobs
+	jobs.RegisterDefaults()
+	if err = jobs.Start(); err != nil {
+		logger.Error("Error starting background jobs: " + err.Error())
+	}
+	return nil
 }
 
 func Main() {
-	API_init("API_LOG_FILE")
+	if err := API_init("API_LOG_FILE"); err != nil {
+		fmt.Println("Error starting API server:", err)
+		os.Exit(1)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	Quit := make(chan os.Signal, 1)
 
 	// Gin Settings
 	gin.SetMode(gin.ReleaseMode)
-	f, _ := os.Create(config.Viper.GetString("API_GIN_LOG"))
-	gin.DefaultWriter = io.MultiWriter(f)
-	router := gin.Default()
+	// requests are logged by logger.GinLog, which redacts secrets in the URL;
+	// gin's own logger would write them in cleartext, so only panics go to
+	// the gin log
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(logger.RotatingWriter(config.Viper.GetString("API_GIN_LOG"))))
 
 	// CORS
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Content-Type", "Accept", "Content-Length", "Authorization", "Origin", "X-Requested-With"}
+	corsConfig.AllowHeaders = []string{"Content-Type", "Accept", "Content-Length", "Authorization", "Origin", "X-Requested-With", logger.RequestIDHeader, "traceparent", "tracestate"}
+	corsConfig.ExposeHeaders = []string{logger.RequestIDHeader}
 	router.RedirectFixedPath = true
+	// nginx passes the client IP in X-Real-IP, X-Forwarded-For is set by the client
+	router.RemoteIPHeaders = []string{"X-Real-IP"}
+	if err := router.SetTrustedProxies(strings.Split(config.Viper.GetString("TRUSTED_PROXIES"), ",")); err != nil {
+		logger.Error("Error setting trusted proxies: " + err.Error())
+		os.Exit(1)
+	}
 	router.Use(cors.New(corsConfig))
+	router.Use(otelgin.Middleware(tracing.ServiceName))
+	router.Use(logger.RequestID())
 	router.Use(logger.GinLog())
+	router.Use(metrics.GinMetrics())
 
 	// Healthcheck
 	router.GET("/healthcheck", func(c *gin.Context) {
 		c.JSON(http.StatusOK, "Healthcheck OK!")
 	})
 
-	// Signup and Login
-	router.POST("users/signup", user.Signup)
-	router.POST("users/login", user.Login)
+	// Prometheus metrics
+	router.GET("/metrics", metrics.Handler())
+
+	// Probes and build information
+	router.GET("/livez", health.Livez)
+	router.GET("/readyz", health.Readyz)
+	router.GET("/version", health.Version)
+
+	// Signup and Login, limited per IP as each attempt hashes a password
+	authLimit := ratelimit.New("auth_ip", config.Viper.GetInt("AUTH_IP_RATE_PER_MINUTE"), config.Viper.GetInt("AUTH_IP_BURST"))
+	router.POST("users/signup", ratelimit.ByIP(authLimit), user.Signup)
+	router.POST("users/login", ratelimit.ByIP(authLimit), user.Login)
+	router.POST("users/oidc/:provider/nonce", ratelimit.ByIP(authLimit), user.CreateOIDCNonce)
+	router.POST("users/oidc/:provider", ratelimit.ByIP(authLimit), user.OIDCLogin)
+
+	// Calendar feed, authenticated by the token in the URL
+	router.GET("/calendar/:token/feed.ics", schedule.GetUserCalendar)
+
+	// Room websocket, authenticated by the ticket in the URL
+	router.GET("/rooms/:roomID/ws", chat.Connect)
 
 	// Auth middleware for all routes below
 	router.Use(auth.ValidateToken)
+	router.Use(ratelimit.UserWrites(ratelimit.New("user_writes", config.Viper.GetInt("WRITE_RATE_PER_MINUTE"), config.Viper.GetInt("WRITE_BURST"))))
 
 	// User Routes
 	userGroup := router.Group("/users")
 	userGroup.GET("/overview", user.GetOverview)
 	userGroup.GET("/profile/:userID", user.GetProfile)
+	userGroup.GET("/me/stats", user.GetStats)
+	userGroup.PATCH("/me", user.UpdateSettings)
+	userGroup.PATCH("/me/password", user.ChangePassword)
+	userGroup.GET("/:userID/heatmap", user.GetHeatmap)
+	userGroup.GET("/me/goals", user.GetGoals)
+	userGroup.PUT("/me/goals", user.SetGoal)
+	userGroup.GET("/me/friends", friend.GetFriends)
+	userGroup.POST("/me/calendar-token", schedule.RotateCalendarToken)
+	userGroup.POST("/me/devices", notification.AddDevice)
+	userGroup.DELETE("/me/devices", notification.RemoveDevice)
 
 	// Room Routes
 	RoomGroup := router.Group("/rooms")
@@ -88,6 +198,27 @@ func Main() {
 	RoomGroup.POST("/:roomID", room.JoinRoom)
 	RoomGroup.DELETE("/:roomID", room.LeaveRoom)
 	RoomGroup.GET(":roomID/records", room.GetRoomRecords)
+	RoomGroup.GET(":roomID/leaderboard", leaderboard.GetRoomLeaderboard)
+	RoomGroup.GET(":roomID/stats", room.GetRoomStats)
+	RoomGroup.GET(":roomID/heatmap", room.GetRoomHeatmap)
+	RoomGroup.GET(":roomID/goals", room.GetRoomGoals)
+	RoomGroup.PUT(":roomID/goals", room.SetRoomGoal)
+	RoomGroup.PUT(":roomID/members/:userID/role", room.SetMemberRole)
+	RoomGroup.POST(":roomID/invites", room.InviteUser)
+	RoomGroup.POST(":roomID/ws/ticket", chat.CreateTicket)
+	RoomGroup.GET(":roomID/messages", chat.GetMessages)
+	RoomGroup.DELETE(":roomID/messages/:messageID", chat.DeleteMessage)
+	RoomGroup.POST(":roomID/sessions", session.CreateSession)
+	RoomGroup.GET(":roomID/sessions", session.GetSessions)
+	RoomGroup.GET(":roomID/sessions/:sessionID", session.GetSession)
+	RoomGroup.DELETE(":roomID/sessions/:sessionID", session.CancelSession)
+	RoomGroup.POST(":roomID/sessions/:sessionID/participants", session.JoinSession)
+	RoomGroup.DELETE(":roomID/sessions/:sessionID/participants", session.LeaveSession)
+	RoomGroup.GET(":roomID/schedule", schedule.GetRoomSchedule)
+	RoomGroup.GET(":roomID/schedule.ics", schedule.GetRoomCalendar)
+	RoomGroup.POST(":roomID/schedule", schedule.CreateEvent)
+	RoomGroup.DELETE(":roomID/schedule/:eventID", schedule.DeleteEvent)
+	RoomGroup.PUT(":roomID/schedule/:eventID/rsvp", schedule.SetRSVP)
 
 	// Ingredient Routes
 	ingredientGroup := router.Group("/ingredients")
@@ -100,6 +231,48 @@ func Main() {
 	recordGroup.GET("", record.GetUserRecords)
 	recordGroup.GET("/:recordID", record.GetRecordDetail)
 	recordGroup.PATCH("/:recordID", record.UpdateRecord)
+	recordGroup.POST("/:recordID/interrupts", record.AddInterrupt)
+	recordGroup.POST("/:recordID/heartbeat", record.Heartbeat)
+	recordGroup.POST("/:recordID/reactions", comment.AddReaction)
+	recordGroup.DELETE("/:recordID/reactions/:emoji", comment.RemoveReaction)
+	recordGroup.GET("/:recordID/comments", comment.GetComments)
+	recordGroup.POST("/:recordID/comments", comment.AddComment)
+	recordGroup.PATCH("/:recordID/comments/:commentID", comment.EditComment)
+	recordGroup.DELETE("/:recordID/comments/:commentID", comment.DeleteComment)
+
+	// Upload Routes
+	uploadGroup := router.Group("/uploads")
+	uploadGroup.POST("", upload.CreateUpload)
+	uploadGroup.POST("/:uploadID/finalize", upload.FinalizeUpload)
+
+	// Friend Routes
+	friendGroup := router.Group("/friends")
+	friendGroup.GET("/activity", friend.GetFriendActivity)
+	friendGroup.DELETE("/:userID", friend.RemoveFriend)
+	friendGroup.GET("/requests", friend.GetFriendRequests)
+	friendGroup.POST("/requests", friend.SendFriendRequest)
+	friendGroup.POST("/requests/:userID/accept", friend.AcceptFriendRequest)
+	friendGroup.POST("/requests/:userID/decline", friend.DeclineFriendRequest)
+	friendGroup.DELETE("/requests/:userID", friend.CancelFriendRequest)
+
+	// Notification Routes
+	notificationGroup := router.Group("/notifications")
+	notificationGroup.GET("", notification.GetNotifications)
+	notificationGroup.POST("/read", notification.MarkRead)
+	notificationGroup.GET("/preferences", notification.GetPreferences)
+	notificationGroup.PUT("/preferences", notification.SetPreferences)
+
+	// Leaderboard Routes
+	leaderboardGroup := router.Group("/leaderboards")
+	leaderboardGroup.GET("/global", leaderboard.GetGlobalLeaderboard)
+	leaderboardGroup.GET("/friends", leaderboard.GetFriendsLeaderboard)
+
+	// Admin Routes
+	adminGroup := router.Group("/admin", auth.RequireAdmin)
+	adminGroup.GET("/jobs", admin.GetJobs)
+	adminGroup.GET("/jobs/:name/runs", admin.GetJobRuns)
+	adminGroup.POST("/jobs/:name/run", admin.RunJob)
+	adminGroup.GET("/media/orphans", admin.GetOrphanedMedia)
 
 	// Start API service
 	srv := &http.Server{
@@ -117,11 +290,17 @@ func Main() {
 	signal.Notify(Quit, syscall.SIGINT, syscall.SIGTERM)
 	<-Quit
 	logger.Info("Shutting down API server...")
+	// fail readiness first so that no new traffic is routed here
+	health.SetShuttingDown()
+	time.Sleep(time.Duration(config.Viper.GetInt("SHUTDOWN_DRAIN_SECONDS")) * time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("Error shutting down API server: " + err.Error())
 		os.Exit(1)
 	}
 	mariadb.DB.Close()
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error("Error flushing traces: " + err.Error())
+	}
 	logger.Info("API server exited")
 }
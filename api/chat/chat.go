@@ -0,0 +1,230 @@
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pottogether/config"
+	"pottogether/internal/realtime"
+	"pottogether/internal/tracing"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	maxMessageLength = 1000
+	defaultLimit     = 50
+	maxLimit         = 100
+)
+
+type sendMessageEvent struct {
+	Content string `json:"content"`
+}
+
+// CreateTicket issues the single-use ticket a room member connects with.
+// Browsers cannot set headers on websockets, and a JWT in the URL would end
+// up in access logs.
+func CreateTicket(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	userID := c.GetInt("id")
+	member, err := query.CheckMember(c.Request.Context(), roomID, userID)
+	if err != nil {
+		errhandler.Error(c, err, "Error checking room membership")
+		return
+	} else if !member {
+		errhandler.Forbidden(c, fmt.Errorf("user not in room"), "Error creating ticket")
+		return
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		errhandler.Error(c, err, "Error creating ticket")
+		return
+	}
+	ticket := hex.EncodeToString(buf)
+	ttl := config.Viper.GetInt("WS_TICKET_TTL_SECONDS")
+	if err := query.CreateWSTicket(c.Request.Context(), hashTicket(ticket), userID, roomID, ttl); err != nil {
+		errhandler.Error(c, err, "Error creating ticket")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"ticket":    ticket,
+			"expiresAt": time.Now().Add(time.Duration(ttl) * time.Second).Unix(),
+		},
+		"message": "Ticket created successfully",
+	})
+}
+
+// Connect opens the realtime connection of a room member, used for presence
+// and chat. It is authenticated by a ticket from CreateTicket instead of the
+// auth middleware.
+func Connect(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	userID, ticketRoomID, err := query.RedeemWSTicket(c.Request.Context(), hashTicket(c.Query("ticket")))
+	if err != nil {
+		if err.Error() == "invalid ticket" {
+			errhandler.Unauthorized(c, err, "Error validating ticket")
+			return
+		}
+		errhandler.Error(c, err, "Error validating ticket")
+		return
+	} else if ticketRoomID != roomID {
+		errhandler.Unauthorized(c, fmt.Errorf("ticket is for another room"), "Error validating ticket")
+		return
+	}
+	logger.SetUserID(c.Request.Context(), userID)
+	member, err := query.CheckMember(c.Request.Context(), roomID, userID)
+	if err != nil {
+		errhandler.Error(c, err, "Error checking room membership")
+		return
+	} else if !member {
+		errhandler.Forbidden(c, fmt.Errorf("user not in room"), "Error connecting to room")
+		return
+	}
+	if err := realtime.Serve(c.Writer, c.Request, roomID, userID, handleEvent); err != nil {
+		logger.WarnCtx(c.Request.Context(), "[CHAT] Error upgrading connection: "+err.Error())
+	}
+}
+
+func hashTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleEvent handles the events sent by a client over its connection
+func handleEvent(client *realtime.Client, event realtime.Event) {
+	// websocket events are traces of their own
+	ctx, span := tracing.Start(context.Background(), "chat.event", attribute.String("chat.event", event.Type), attribute.Int("room.id", client.RoomID))
+	defer span.End()
+	switch event.Type {
+	case "message":
+		var data sendMessageEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			client.Send("error", gin.H{"message": "Invalid message format"})
+			return
+		}
+		content := strings.TrimSpace(data.Content)
+		if content == "" || utf8.RuneCountInString(content) > maxMessageLength {
+			client.Send("error", gin.H{"message": "Message must be between 1 and 1000 characters"})
+			return
+		}
+		userID := client.UserID
+		// the user may have left the room through another instance
+		member, err := query.CheckMember(ctx, client.RoomID, userID)
+		if err != nil {
+			logger.ErrorCtx(ctx, "[CHAT] Error checking room membership: "+err.Error())
+			client.Send("error", gin.H{"message": "Error sending message"})
+			return
+		} else if !member {
+			realtime.Disconnect(ctx, client.RoomID, userID)
+			return
+		}
+		message, err := query.AddMessage(ctx, client.RoomID, &userID, "text", content)
+		if err != nil {
+			logger.ErrorCtx(ctx, "[CHAT] Error adding message: "+err.Error())
+			client.Send("error", gin.H{"message": "Error sending message"})
+			return
+		}
+		realtime.Broadcast(ctx, client.RoomID, "message", message)
+	default:
+		client.Send("error", gin.H{"message": "Unknown event type: " + event.Type})
+	}
+}
+
+// SystemMessage posts a message from the server to the room chat
+func SystemMessage(ctx context.Context, roomID int, content string) {
+	message, err := query.AddMessage(ctx, roomID, nil, "system", content)
+	if err != nil {
+		logger.ErrorCtx(ctx, "[CHAT] Error adding system message: "+err.Error())
+		return
+	}
+	realtime.Broadcast(ctx, roomID, "message", message)
+}
+
+func GetMessages(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	before, err := strconv.Atoi(c.DefaultQuery("before", "0"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid before")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit <= 0 || limit > maxLimit {
+		errhandler.Info(c, fmt.Errorf("limit must be between 1 and %d", maxLimit), "Invalid limit")
+		return
+	}
+	member, err := query.CheckMember(c.Request.Context(), roomID, c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error checking room membership")
+		return
+	} else if !member {
+		errhandler.Forbidden(c, fmt.Errorf("user not in room"), "Error getting messages")
+		return
+	}
+	messages, err := query.GetMessages(c.Request.Context(), roomID, before, limit)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting messages")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      messages,
+		"message":   "Messages retrieved successfully",
+	})
+}
+
+func DeleteMessage(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid messageID")
+		return
+	}
+	if err := query.DeleteMessage(c.Request.Context(), roomID, messageID, c.GetInt("id")); err != nil {
+		if err.Error() == "message does not belong to user" {
+			errhandler.Forbidden(c, err, "Error deleting message")
+			return
+		}
+		if err.Error() == "message does not exist" {
+			errhandler.Info(c, err, "Error deleting message")
+			return
+		}
+		errhandler.Error(c, err, "Error deleting message")
+		return
+	}
+	realtime.Broadcast(c.Request.Context(), roomID, "message_deleted", gin.H{"messageID": messageID})
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Message deleted successfully",
+	})
+}
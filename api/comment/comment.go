@@ -0,0 +1,186 @@
+package comment
+
+import (
+	"fmt"
+	"net/http"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxCommentLength = 1000
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+type AddCommentRequest struct {
+	Content  string `json:"content" binding:"required"`
+	ParentID *int   `json:"parentID"`
+}
+
+type EditCommentRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// handleError responds 403 for permission errors, 400 for other expected errors
+// and 500 otherwise
+func handleError(c *gin.Context, err error, msg string) {
+	switch err.Error() {
+	case "user not in room", "comment does not belong to user":
+		errhandler.Forbidden(c, err, msg)
+	case "record does not exist", "record is not finished", "comment does not exist", "parent comment does not exist", "reaction does not exist":
+		errhandler.Info(c, err, msg)
+	default:
+		errhandler.Error(c, err, msg)
+	}
+}
+
+func AddReaction(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	var req ReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	if utf8.RuneCountInString(req.Emoji) > 8 || len(req.Emoji) > 32 {
+		errhandler.Info(c, fmt.Errorf("invalid emoji"), "Invalid request format")
+		return
+	}
+	if err := query.AddReaction(c.Request.Context(), recordID, c.GetInt("id"), req.Emoji); err != nil {
+		handleError(c, err, "Error adding reaction")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Reaction added successfully",
+	})
+}
+
+func RemoveReaction(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	if err := query.RemoveReaction(c.Request.Context(), recordID, c.GetInt("id"), c.Param("emoji")); err != nil {
+		handleError(c, err, "Error removing reaction")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Reaction removed successfully",
+	})
+}
+
+func GetComments(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	comments, err := query.GetComments(c.Request.Context(), recordID, c.GetInt("id"))
+	if err != nil {
+		handleError(c, err, "Error getting comments")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      comments,
+		"message":   "Comments retrieved successfully",
+	})
+}
+
+func AddComment(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	var req AddCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if utf8.RuneCountInString(req.Content) > maxCommentLength {
+		errhandler.Info(c, fmt.Errorf("comment is too long"), "Invalid request format")
+		return
+	}
+	commentID, err := query.AddComment(c.Request.Context(), recordID, c.GetInt("id"), req.ParentID, req.Content)
+	if err != nil {
+		handleError(c, err, "Error adding comment")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"commentID": commentID,
+		},
+		"message": "Comment added successfully",
+	})
+}
+
+func EditComment(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	commentID, err := strconv.Atoi(c.Param("commentID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid commentID")
+		return
+	}
+	var req EditCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if utf8.RuneCountInString(req.Content) > maxCommentLength {
+		errhandler.Info(c, fmt.Errorf("comment is too long"), "Invalid request format")
+		return
+	}
+	if err := query.EditComment(c.Request.Context(), recordID, commentID, c.GetInt("id"), req.Content); err != nil {
+		handleError(c, err, "Error editing comment")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Comment edited successfully",
+	})
+}
+
+func DeleteComment(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	commentID, err := strconv.Atoi(c.Param("commentID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid commentID")
+		return
+	}
+	if err := query.DeleteComment(c.Request.Context(), recordID, commentID, c.GetInt("id")); err != nil {
+		handleError(c, err, "Error deleting comment")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Comment deleted successfully",
+	})
+}
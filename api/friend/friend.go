@@ -0,0 +1,170 @@
+package friend
+
+import (
+	"net/http"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FriendRequest struct {
+	UserID int `json:"userID" binding:"required"`
+}
+
+// isClientError reports whether err is caused by the request rather than the server
+func isClientError(err error) bool {
+	switch err.Error() {
+	case "cannot add yourself", "user does not exist", "already friends",
+		"friend request already sent", "friend request does not exist", "not friends":
+		return true
+	}
+	return false
+}
+
+func GetFriends(c *gin.Context) {
+	friends, err := query.GetFriends(c.Request.Context(), c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting friends")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      friends,
+		"message":   "Friends retrieved successfully",
+	})
+}
+
+func GetFriendActivity(c *gin.Context) {
+	activity, err := query.GetFriendActivity(c.Request.Context(), c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting friend activity")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      activity,
+		"message":   "Friend activity retrieved successfully",
+	})
+}
+
+func GetFriendRequests(c *gin.Context) {
+	requests, err := query.GetFriendRequests(c.Request.Context(), c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting friend requests")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      requests,
+		"message":   "Friend requests retrieved successfully",
+	})
+}
+
+func SendFriendRequest(c *gin.Context) {
+	var req FriendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := query.SendFriendRequest(c.Request.Context(), c.GetInt("id"), req.UserID); err != nil {
+		if isClientError(err) {
+			errhandler.Info(c, err, "Error sending friend request")
+			return
+		}
+		errhandler.Error(c, err, "Error sending friend request")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Friend request sent successfully",
+	})
+}
+
+func AcceptFriendRequest(c *gin.Context) {
+	requesterID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid userID")
+		return
+	}
+	if err := query.RespondFriendRequest(c.Request.Context(), c.GetInt("id"), requesterID, true); err != nil {
+		if isClientError(err) {
+			errhandler.Info(c, err, "Error accepting friend request")
+			return
+		}
+		errhandler.Error(c, err, "Error accepting friend request")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Friend request accepted successfully",
+	})
+}
+
+func DeclineFriendRequest(c *gin.Context) {
+	requesterID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid userID")
+		return
+	}
+	if err := query.RespondFriendRequest(c.Request.Context(), c.GetInt("id"), requesterID, false); err != nil {
+		if isClientError(err) {
+			errhandler.Info(c, err, "Error declining friend request")
+			return
+		}
+		errhandler.Error(c, err, "Error declining friend request")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Friend request declined successfully",
+	})
+}
+
+func CancelFriendRequest(c *gin.Context) {
+	targetID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid userID")
+		return
+	}
+	if err := query.CancelFriendRequest(c.Request.Context(), c.GetInt("id"), targetID); err != nil {
+		if isClientError(err) {
+			errhandler.Info(c, err, "Error cancelling friend request")
+			return
+		}
+		errhandler.Error(c, err, "Error cancelling friend request")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Friend request cancelled successfully",
+	})
+}
+
+func RemoveFriend(c *gin.Context) {
+	friendID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid userID")
+		return
+	}
+	if err := query.RemoveFriend(c.Request.Context(), c.GetInt("id"), friendID); err != nil {
+		if isClientError(err) {
+			errhandler.Info(c, err, "Error removing friend")
+			return
+		}
+		errhandler.Error(c, err, "Error removing friend")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Friend removed successfully",
+	})
+}
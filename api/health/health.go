@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"pottogether/config"
+	"pottogether/internal/s3"
+	"pottogether/internal/version"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checks are the dependencies an instance needs to serve traffic
+var checks = map[string]func(ctx context.Context, timeout time.Duration) error{
+	"mariadb": mariadb.Ping,
+	"storage": s3.Ping,
+}
+
+var shuttingDown atomic.Bool
+
+// SetShuttingDown makes the instance report not ready, so that traffic is
+// drained before the server stops
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Livez reports that the process is up, it does not check dependencies so
+// that an outage of the database does not restart every instance
+func Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Alive",
+	})
+}
+
+// Readyz checks the dependencies concurrently, each within the readiness timeout
+func Readyz(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"isSuccess": false,
+			"data":      nil,
+			"message":   "Shutting down",
+		})
+		return
+	}
+	timeout := time.Duration(config.Viper.GetInt("READINESS_TIMEOUT_SECONDS")) * time.Second
+	results := map[string]string{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context, time.Duration) error) {
+			defer wg.Done()
+			status := "ok"
+			if err := check(c.Request.Context(), timeout); err != nil {
+				status = err.Error()
+				logger.WarnCtx(c.Request.Context(), "[HEALTH] "+name+" is not ready: "+status)
+			}
+			mu.Lock()
+			results[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	for _, status := range results {
+		if status != "ok" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"isSuccess": false,
+				"data":      results,
+				"message":   "Not ready",
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      results,
+		"message":   "Ready",
+	})
+}
+
+func Version(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      version.Get(),
+		"message":   "Version retrieved successfully",
+	})
+}
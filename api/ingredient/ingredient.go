@@ -11,13 +11,13 @@ import (
 
 type AddIngredientRequest struct {
 	Name        string                `form:"name" binding:"required"`
-	Image       *multipart.FileHeader `form:"image" binding:"required"`
+	Image       *multipart.FileHeader `form:"image"` // optional when uploaded through POST /uploads
 	Interval    int                   `form:"interval" binding:"required"`
 	Requirement string                `form:"requirement"`
 }
 
 func GetIngredients(c *gin.Context) {
-	ingredients, err := query.GetIngredients()
+	ingredients, err := query.GetIngredients(c.Request.Context())
 	if err != nil {
 		errhandler.Error(c, err, "Error getting ingredients")
 		return
@@ -30,20 +30,24 @@ func GetIngredients(c *gin.Context) {
 }
 
 func AddIngredient(c *gin.Context) {
-	s3.UploadMiddleware(c, "ingredient", "")
 	var req AddIngredientRequest
 	if err := c.ShouldBind(&req); err != nil {
 		errhandler.Info(c, err, "Invalid request format")
 		return
 	}
+	if req.Image != nil && !s3.UploadMiddleware(c, "ingredient") {
+		return
+	}
 	ingredient := query.Ingredient{
-		ID:          -1,
-		Name:        req.Name,
-		Image:       c.GetString("image"),
-		Interval:    req.Interval,
-		Requirement: req.Requirement,
+		ID:             -1,
+		Name:           req.Name,
+		Image:          c.GetString("image"),
+		ImageMedium:    c.GetString("imageMedium"),
+		ImageThumbnail: c.GetString("imageThumbnail"),
+		Interval:       req.Interval,
+		Requirement:    req.Requirement,
 	}
-	ingredientID, err := query.AddIngredient(ingredient)
+	ingredientID, err := query.AddIngredient(c.Request.Context(), ingredient)
 	if err != nil {
 		errhandler.Error(c, err, "Error adding ingredient")
 		return
@@ -0,0 +1,102 @@
+package leaderboard
+
+import (
+	"fmt"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultLimit = 10
+	maxLimit     = 100
+)
+
+// parseOptions reads the period and limit query parameters
+func parseOptions(c *gin.Context) (string, int, error) {
+	period := c.DefaultQuery("period", "weekly")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil {
+		return "", 0, err
+	}
+	if limit <= 0 || limit > maxLimit {
+		return "", 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return period, limit, nil
+}
+
+func GetRoomLeaderboard(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	period, limit, err := parseOptions(c)
+	if err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	leaderboard, err := query.GetRoomLeaderboard(c.Request.Context(), roomID, c.GetInt("id"), period, limit)
+	if err != nil {
+		if err.Error() == "room does not exist" || err.Error() == "invalid period" {
+			errhandler.Info(c, err, "Error getting room leaderboard")
+			return
+		} else if err.Error() == "user not in room" {
+			errhandler.Forbidden(c, err, "Error getting room leaderboard")
+			return
+		}
+		errhandler.Error(c, err, "Error getting room leaderboard")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      leaderboard,
+		"message":   "Room leaderboard retrieved successfully",
+	})
+}
+
+func GetGlobalLeaderboard(c *gin.Context) {
+	period, limit, err := parseOptions(c)
+	if err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	leaderboard, err := query.GetGlobalLeaderboard(c.Request.Context(), c.GetInt("id"), period, limit)
+	if err != nil {
+		if err.Error() == "invalid period" {
+			errhandler.Info(c, err, "Error getting global leaderboard")
+			return
+		}
+		errhandler.Error(c, err, "Error getting global leaderboard")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      leaderboard,
+		"message":   "Global leaderboard retrieved successfully",
+	})
+}
+
+func GetFriendsLeaderboard(c *gin.Context) {
+	period, limit, err := parseOptions(c)
+	if err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	leaderboard, err := query.GetFriendsLeaderboard(c.Request.Context(), c.GetInt("id"), period, limit)
+	if err != nil {
+		if err.Error() == "invalid period" {
+			errhandler.Info(c, err, "Error getting friends leaderboard")
+			return
+		}
+		errhandler.Error(c, err, "Error getting friends leaderboard")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      leaderboard,
+		"message":   "Friends leaderboard retrieved successfully",
+	})
+}
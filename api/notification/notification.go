@@ -0,0 +1,157 @@
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type MarkReadRequest struct {
+	// empty marks every notification as read
+	NotificationIDs []int `json:"notificationIDs"`
+}
+
+type SetPreferencesRequest struct {
+	Preferences []query.NotificationPreference `json:"preferences" binding:"required"`
+}
+
+type DeviceRequest struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token" binding:"required,max=255"`
+}
+
+func GetNotifications(c *gin.Context) {
+	before, err := strconv.Atoi(c.DefaultQuery("before", "0"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid before")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit <= 0 || limit > maxLimit {
+		errhandler.Info(c, fmt.Errorf("limit must be between 1 and %d", maxLimit), "Invalid limit")
+		return
+	}
+	unreadOnly := c.Query("unread") == "true"
+	notifications, err := query.GetNotifications(c.Request.Context(), c.GetInt("id"), before, limit, unreadOnly)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting notifications")
+		return
+	}
+	unread, err := query.GetUnreadCount(c.Request.Context(), c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting notifications")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"notifications": notifications,
+			"unread":        unread,
+		},
+		"message": "Notifications retrieved successfully",
+	})
+}
+
+func MarkRead(c *gin.Context) {
+	var req MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := query.MarkRead(c.Request.Context(), c.GetInt("id"), req.NotificationIDs); err != nil {
+		errhandler.Error(c, err, "Error marking notifications as read")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Notifications marked as read",
+	})
+}
+
+func GetPreferences(c *gin.Context) {
+	prefs, err := query.GetPreferences(c.Request.Context(), c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting notification preferences")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      prefs,
+		"message":   "Notification preferences retrieved successfully",
+	})
+}
+
+func SetPreferences(c *gin.Context) {
+	var req SetPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	for _, pref := range req.Preferences {
+		if err := query.SetPreference(c.Request.Context(), c.GetInt("id"), pref); err != nil {
+			if err.Error() == "invalid notification type" {
+				errhandler.Info(c, err, "Error setting notification preferences")
+				return
+			}
+			errhandler.Error(c, err, "Error setting notification preferences")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Notification preferences set successfully",
+	})
+}
+
+func AddDevice(c *gin.Context) {
+	var req DeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := query.AddDevice(c.Request.Context(), c.GetInt("id"), req.Platform, req.Token); err != nil {
+		if err.Error() == "invalid platform" {
+			errhandler.Info(c, err, "Error registering device")
+			return
+		}
+		errhandler.Error(c, err, "Error registering device")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Device registered successfully",
+	})
+}
+
+func RemoveDevice(c *gin.Context) {
+	var req DeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	if err := query.RemoveDevice(c.Request.Context(), c.GetInt("id"), req.Token); err != nil {
+		errhandler.Error(c, err, "Error removing device")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Device removed successfully",
+	})
+}
@@ -3,7 +3,9 @@ package record
 import (
 	"fmt"
 	"mime/multipart"
+	"pottogether/api/chat"
 	"pottogether/internal/s3"
+	"pottogether/internal/tracing"
 	"pottogether/pkg/errhandler"
 	"pottogether/pkg/logger"
 	"pottogether/pkg/mariadb/query"
@@ -20,20 +22,25 @@ type CreateRecordRequest struct {
 }
 
 type UpdateRecordRequest struct {
-	Image     *multipart.FileHeader `form:"image" binding:"required"`
+	Image     *multipart.FileHeader `form:"image"` // optional when uploaded through POST /uploads
 	Caption   string                `form:"caption" binding:"required"`
 	Interval  int                   `form:"interval" binding:"required"`
 	Interrupt int                   `form:"interrupt"`
 	Status    int                   `form:"status" binding:"required"`
 }
 
+type AddInterruptRequest struct {
+	Reason     *string `json:"reason"`
+	OccurredAt int     `json:"occurredAt"`
+}
+
 func CreateRecord(c *gin.Context) {
 	var req CreateRecordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		errhandler.Info(c, err, "Invalid request format")
 		return
 	}
-	logger.Info("Request content: " + fmt.Sprintf("%+v", req))
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
 	record := query.Record{
 		ID:           -1,
 		UserID:       c.GetInt("id"),
@@ -47,11 +54,17 @@ func CreateRecord(c *gin.Context) {
 		Interrupt:    0,
 		Status:       0,
 	}
-	recordID, err := query.CreateRecord(record)
+	recordID, err := query.CreateRecord(c.Request.Context(), record)
 	if err != nil {
 		errhandler.Error(c, err, "Error creating record")
 		return
 	}
+	ctx := tracing.Detach(c.Request.Context())
+	go func() {
+		if err := query.NotifyFriendsCooking(ctx, record.UserID, recordID); err != nil {
+			logger.WarnCtx(ctx, "Error notifying friends: "+err.Error())
+		}
+	}()
 	c.JSON(200, gin.H{
 		"isSuccess": true,
 		"data": gin.H{
@@ -63,35 +76,49 @@ func CreateRecord(c *gin.Context) {
 
 func UpdateRecord(c *gin.Context) {
 	recordID, err := strconv.Atoi(c.Param("recordID"))
-	s3.UploadMiddleware(c, "record", c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
 	var req UpdateRecordRequest
 	if err := c.ShouldBind(&req); err != nil {
 		errhandler.Info(c, err, "Invalid request format")
 		return
 	}
-	logger.Info("Request content: " + fmt.Sprintf("%+v", req))
-	if err != nil {
-		errhandler.Info(c, err, "Invalid recordID")
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if req.Image != nil && !s3.UploadMiddleware(c, "record") {
 		return
 	}
 	record := query.Record{
-		ID:           recordID,
-		UserID:       -1,
-		RoomID:       -1,
-		PotID:        "",
-		IngredientID: -1,
-		Image:        c.GetString("image"),
-		Caption:      req.Caption,
-		Interval:     req.Interval,
-		FinishTime:   int(time.Now().Unix()),
-		Interrupt:    req.Interrupt,
-		Status:       req.Status,
+		ID:             recordID,
+		UserID:         c.GetInt("id"),
+		RoomID:         -1,
+		PotID:          "",
+		IngredientID:   -1,
+		Image:          c.GetString("image"),
+		ImageMedium:    c.GetString("imageMedium"),
+		ImageThumbnail: c.GetString("imageThumbnail"),
+		Caption:        req.Caption,
+		Interval:       req.Interval,
+		FinishTime:     int(time.Now().Unix()),
+		Interrupt:      req.Interrupt,
+		Status:         req.Status,
 	}
-	err = query.UpdateRecord(record)
+	finished, err := query.UpdateRecord(c.Request.Context(), record)
 	if err != nil {
+		if err.Error() == "record does not exist" || err.Error() == "record does not belong to user" || err.Error() == "record is not cooking" {
+			errhandler.Info(c, err, "Error updating record")
+			return
+		}
 		errhandler.Error(c, err, "Error updating record")
 		return
 	}
+	// announce the finished dish in the room chat, once
+	if finished {
+		if detail, err := query.GetRecordDetail(c.Request.Context(), recordID, c.GetInt("id")); err == nil {
+			chat.SystemMessage(c.Request.Context(), detail.RoomID, detail.Username+" finished cooking "+detail.IngredientName)
+		}
+	}
 	c.JSON(200, gin.H{
 		"isSuccess": true,
 		"data":      nil,
@@ -100,11 +127,14 @@ func UpdateRecord(c *gin.Context) {
 }
 
 func GetUserRecords(c *gin.Context) {
-	records, err := query.GetUserRecords(c.GetInt("id"))
+	records, err := query.GetUserRecords(c.Request.Context(), c.GetInt("id"))
 	if err != nil {
 		errhandler.Error(c, err, "Error getting records")
 		return
 	}
+	for i := range records {
+		s3.SignImages(&records[i].Image, &records[i].ImageMedium, &records[i].ImageThumbnail)
+	}
 	c.JSON(200, gin.H{
 		"isSuccess": true,
 		"data":      records,
@@ -118,14 +148,75 @@ func GetRecordDetail(c *gin.Context) {
 		errhandler.Info(c, err, "Invalid recordID")
 		return
 	}
-	record, err := query.GetRecordDetail(recordID)
+	record, err := query.GetRecordDetail(c.Request.Context(), recordID, c.GetInt("id"))
 	if err != nil {
+		if err.Error() == "user cannot view record" {
+			errhandler.Forbidden(c, err, "Error getting record detail")
+			return
+		}
 		errhandler.Error(c, err, "Error getting record detail")
 		return
 	}
+	s3.SignImages(&record.Image, &record.ImageMedium, &record.ImageThumbnail)
 	c.JSON(200, gin.H{
 		"isSuccess": true,
 		"data":      record,
 		"message":   "Record detail retrieved successfully",
 	})
 }
+
+func AddInterrupt(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	var req AddInterruptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if req.Reason != nil && len(*req.Reason) > 64 {
+		errhandler.Info(c, fmt.Errorf("reason is too long"), "Invalid request format")
+		return
+	}
+	interruptID, err := query.AddInterrupt(c.Request.Context(), recordID, c.GetInt("id"), req.Reason, req.OccurredAt)
+	if err != nil {
+		if err.Error() == "record does not exist" || err.Error() == "record does not belong to user" || err.Error() == "record is not cooking" || err.Error() == "occurredAt is outside the record" {
+			errhandler.Info(c, err, "Error adding interrupt")
+			return
+		}
+		errhandler.Error(c, err, "Error adding interrupt")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"interruptID": interruptID,
+		},
+		"message": "Interrupt added successfully",
+	})
+}
+
+// Heartbeat tells the server the client is still cooking the record
+func Heartbeat(c *gin.Context) {
+	recordID, err := strconv.Atoi(c.Param("recordID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid recordID")
+		return
+	}
+	if err := query.Heartbeat(c.Request.Context(), recordID, c.GetInt("id")); err != nil {
+		if err.Error() == "record does not exist" || err.Error() == "record does not belong to user" || err.Error() == "record is not cooking" {
+			errhandler.Info(c, err, "Error sending heartbeat")
+			return
+		}
+		errhandler.Error(c, err, "Error sending heartbeat")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Heartbeat received",
+	})
+}
@@ -1,11 +1,14 @@
 package room
 
 import (
-	"fmt"
+	"pottogether/api/chat"
+	"pottogether/internal/realtime"
+	"pottogether/internal/s3"
 	"pottogether/pkg/errhandler"
 	"pottogether/pkg/logger"
 	"pottogether/pkg/mariadb/query"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,13 +20,26 @@ type CreateRoomRequest struct {
 	Category    string `json:"category"`
 }
 
+type SetRoomGoalRequest struct {
+	Period string `json:"period" binding:"required"`
+	Target int    `json:"target" binding:"min=0"`
+}
+
+type SetMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type InviteUserRequest struct {
+	UserID int `json:"userID" binding:"required"`
+}
+
 func CreateRoom(c *gin.Context) {
 	var req CreateRoomRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		errhandler.Info(c, err, "Invalid request format")
 		return
 	}
-	logger.Info("Request content: " + fmt.Sprintf("%+v", req))
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
 	room := query.Room{
 		ID:          -1,
 		Name:        req.Name,
@@ -31,7 +47,7 @@ func CreateRoom(c *gin.Context) {
 		Privacy:     req.Privacy,
 		Category:    req.Category,
 	}
-	roomID, potID, err := query.CreateRoom(room, c.GetInt("id"))
+	roomID, potID, err := query.CreateRoom(c.Request.Context(), room, c.GetInt("id"))
 	if err != nil {
 		errhandler.Error(c, err, "Error creating room")
 		return
@@ -47,7 +63,7 @@ func CreateRoom(c *gin.Context) {
 }
 
 func GetRooms(c *gin.Context) {
-	rooms, err := query.GetRooms(c.GetInt("id"))
+	rooms, err := query.GetRooms(c.Request.Context(), c.GetInt("id"))
 	if err != nil {
 		errhandler.Error(c, err, "Error getting rooms")
 		return
@@ -60,7 +76,7 @@ func GetRooms(c *gin.Context) {
 }
 
 func GetPublicRooms(c *gin.Context) {
-	rooms, err := query.GetPublicRooms()
+	rooms, err := query.GetPublicRooms(c.Request.Context())
 	if err != nil {
 		errhandler.Error(c, err, "Error getting public rooms")
 		return
@@ -78,7 +94,7 @@ func GetRoomOverview(c *gin.Context) {
 		errhandler.Info(c, err, "Invalid roomID")
 		return
 	}
-	room, err := query.GetRoomOverview(roomID, c.GetInt("id"))
+	room, err := query.GetRoomOverview(c.Request.Context(), roomID, c.GetInt("id"))
 	if err != nil {
 		if err.Error() == "room does not exist" {
 			errhandler.Info(c, err, "Room does not exist")
@@ -100,7 +116,7 @@ func JoinRoom(c *gin.Context) {
 		errhandler.Info(c, err, "Invalid roomID")
 		return
 	}
-	if err := query.JoinRoom(c.GetInt("id"), roomID); err != nil {
+	if err := query.JoinRoom(c.Request.Context(), roomID, c.GetInt("id")); err != nil {
 		if err.Error() == "room does not exist" {
 			errhandler.Info(c, err, "Error joining room")
 			return
@@ -116,6 +132,9 @@ func JoinRoom(c *gin.Context) {
 		errhandler.Error(c, err, "Error joining room")
 		return
 	}
+	if username, err := query.GetUsername(c.Request.Context(), c.GetInt("id")); err == nil {
+		chat.SystemMessage(c.Request.Context(), roomID, username+" joined the room")
+	}
 }
 
 func LeaveRoom(c *gin.Context) {
@@ -124,7 +143,7 @@ func LeaveRoom(c *gin.Context) {
 		errhandler.Info(c, err, "Invalid roomID")
 		return
 	}
-	if err := query.LeaveRoom(c.GetInt("id"), roomID); err != nil {
+	if err := query.LeaveRoom(c.Request.Context(), roomID, c.GetInt("id")); err != nil {
 		if err.Error() == "room does not exist" {
 			errhandler.Info(c, err, "Error leaving room")
 			return
@@ -136,6 +155,10 @@ func LeaveRoom(c *gin.Context) {
 		errhandler.Error(c, err, "Error leaving room")
 		return
 	}
+	realtime.Disconnect(c.Request.Context(), roomID, c.GetInt("id"))
+	if username, err := query.GetUsername(c.Request.Context(), c.GetInt("id")); err == nil {
+		chat.SystemMessage(c.Request.Context(), roomID, username+" left the room")
+	}
 }
 
 func GetRoomRecords(c *gin.Context) {
@@ -144,14 +167,206 @@ func GetRoomRecords(c *gin.Context) {
 		errhandler.Info(c, err, "Invalid roomID")
 		return
 	}
-	records, err := query.GetRoomRecords(roomID)
+	records, err := query.GetRoomRecords(c.Request.Context(), roomID, c.GetInt("id"))
 	if err != nil {
+		if err.Error() == "user not in room" {
+			errhandler.Forbidden(c, err, "Error getting room records")
+			return
+		}
 		errhandler.Error(c, err, "Error getting room records")
 		return
 	}
+	for i := range records {
+		s3.SignImages(&records[i].Image, &records[i].ImageMedium, &records[i].ImageThumbnail)
+	}
 	c.JSON(200, gin.H{
 		"isSuccess": true,
 		"data":      records,
 		"message":   "Room records retrieved successfully",
 	})
 }
+
+func GetRoomStats(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	statsRange, err := query.NewStatsRange(c.Query("from"), c.Query("to"), c.Query("granularity"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	stats, err := query.GetRoomStats(c.Request.Context(), roomID, c.GetInt("id"), statsRange)
+	if err != nil {
+		if err.Error() == "room does not exist" {
+			errhandler.Info(c, err, "Error getting room stats")
+			return
+		} else if err.Error() == "user not in room" {
+			errhandler.Forbidden(c, err, "Error getting room stats")
+			return
+		} else if err.Error() == "invalid date range" {
+			errhandler.Info(c, err, "Invalid request format")
+			return
+		}
+		errhandler.Error(c, err, "Error getting room stats")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      stats,
+		"message":   "Room stats retrieved successfully",
+	})
+}
+
+func GetRoomHeatmap(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	year, err := strconv.Atoi(c.DefaultQuery("year", strconv.Itoa(time.Now().Year())))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid year")
+		return
+	}
+	heatmap, err := query.GetRoomHeatmap(c.Request.Context(), roomID, c.GetInt("id"), year)
+	if err != nil {
+		if err.Error() == "room does not exist" {
+			errhandler.Info(c, err, "Error getting room heatmap")
+			return
+		} else if err.Error() == "user not in room" {
+			errhandler.Forbidden(c, err, "Error getting room heatmap")
+			return
+		}
+		errhandler.Error(c, err, "Error getting room heatmap")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      heatmap,
+		"message":   "Room heatmap retrieved successfully",
+	})
+}
+
+func GetRoomGoals(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	goals, err := query.GetRoomGoals(c.Request.Context(), roomID, c.GetInt("id"))
+	if err != nil {
+		if err.Error() == "room does not exist" {
+			errhandler.Info(c, err, "Error getting room goals")
+			return
+		} else if err.Error() == "user not in room" {
+			errhandler.Forbidden(c, err, "Error getting room goals")
+			return
+		}
+		errhandler.Error(c, err, "Error getting room goals")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      goals,
+		"message":   "Room goals retrieved successfully",
+	})
+}
+
+func SetRoomGoal(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	var req SetRoomGoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := query.SetRoomGoal(c.Request.Context(), roomID, c.GetInt("id"), req.Period, req.Target); err != nil {
+		if err.Error() == "invalid period" {
+			errhandler.Info(c, err, "Error setting room goal")
+			return
+		} else if err.Error() == "user is not a room admin" {
+			errhandler.Forbidden(c, err, "Error setting room goal")
+			return
+		}
+		errhandler.Error(c, err, "Error setting room goal")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Room goal set successfully",
+	})
+}
+
+func SetMemberRole(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid userID")
+		return
+	}
+	var req SetMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := query.SetMemberRole(c.Request.Context(), roomID, c.GetInt("id"), userID, req.Role); err != nil {
+		if err.Error() == "user is not the room owner" {
+			errhandler.Forbidden(c, err, "Error setting member role")
+			return
+		}
+		if err.Error() == "invalid role" || err.Error() == "user not in room" {
+			errhandler.Info(c, err, "Error setting member role")
+			return
+		}
+		errhandler.Error(c, err, "Error setting member role")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Member role set successfully",
+	})
+}
+
+func InviteUser(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	var req InviteUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := query.InviteToRoom(c.Request.Context(), roomID, c.GetInt("id"), req.UserID); err != nil {
+		if err.Error() == "user not in room" {
+			errhandler.Forbidden(c, err, "Error inviting user")
+			return
+		}
+		if err.Error() == "user does not exist" || err.Error() == "user already in the room" {
+			errhandler.Info(c, err, "Error inviting user")
+			return
+		}
+		errhandler.Error(c, err, "Error inviting user")
+		return
+	}
+	c.JSON(200, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "User invited successfully",
+	})
+}
@@ -0,0 +1,248 @@
+package schedule
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"pottogether/internal/ical"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateEventRequest struct {
+	Title       string `json:"title" binding:"required,max=255"`
+	Description string `json:"description"`
+	StartsAt    int    `json:"startsAt" binding:"required"`
+	Duration    int    `json:"duration" binding:"required,min=60,max=86400"`
+	RRule       string `json:"rrule" binding:"max=255"`
+	// IANA timezone the session repeats in, the caller's own by default
+	Timezone string `json:"timezone" binding:"max=64"`
+}
+
+type RSVPRequest struct {
+	Response string `json:"response" binding:"required"`
+}
+
+// handleError responds 403 for permission errors, 400 for other expected errors
+// and 500 otherwise
+func handleError(c *gin.Context, err error, msg string) {
+	switch err.Error() {
+	case "user is not a room admin", "user not in room":
+		errhandler.Forbidden(c, err, msg)
+	case "event does not exist", "invalid response":
+		errhandler.Info(c, err, msg)
+	default:
+		errhandler.Error(c, err, msg)
+	}
+}
+
+// checkMember parses the room id and checks the caller is a member of it
+func checkMember(c *gin.Context) (int, bool) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return -1, false
+	}
+	member, err := query.CheckMember(c.Request.Context(), roomID, c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error checking room membership")
+		return -1, false
+	} else if !member {
+		errhandler.Forbidden(c, fmt.Errorf("user not in room"), "Error getting room schedule")
+		return -1, false
+	}
+	return roomID, true
+}
+
+// writeCalendar responds with the events as an iCalendar document
+func writeCalendar(c *gin.Context, name string, events []query.ScheduledEvent) {
+	calendar := make([]ical.Event, 0, len(events))
+	for _, e := range events {
+		calendar = append(calendar, ical.Event{
+			UID:         fmt.Sprintf("room-event-%d@pottogether", e.ID),
+			Summary:     e.Title + " (" + e.RoomName + ")",
+			Description: e.Description,
+			Start:       time.Unix(int64(e.StartsAt), 0),
+			Duration:    time.Duration(e.Duration) * time.Second,
+			RRule:       e.RRule,
+			Status:      "CONFIRMED",
+			Timezone:    e.Timezone,
+		})
+	}
+	var buf bytes.Buffer
+	if err := ical.Write(&buf, name, calendar); err != nil {
+		errhandler.Error(c, err, "Error writing calendar")
+		return
+	}
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", buf.Bytes())
+}
+
+func CreateEvent(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	var req CreateEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := ical.ValidateRRule(req.RRule); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil || req.Timezone == "Local" {
+		errhandler.Info(c, fmt.Errorf("invalid timezone"), "Invalid request format")
+		return
+	}
+	event := query.ScheduledEvent{
+		ID:          -1,
+		RoomID:      roomID,
+		CreatedBy:   c.GetInt("id"),
+		Title:       req.Title,
+		Description: req.Description,
+		StartsAt:    req.StartsAt,
+		Duration:    req.Duration,
+		RRule:       req.RRule,
+		Timezone:    req.Timezone,
+	}
+	eventID, err := query.CreateScheduledEvent(c.Request.Context(), event)
+	if err != nil {
+		handleError(c, err, "Error creating scheduled session")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"eventID": eventID,
+		},
+		"message": "Scheduled session created successfully",
+	})
+}
+
+func DeleteEvent(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid eventID")
+		return
+	}
+	if err := query.DeleteScheduledEvent(c.Request.Context(), roomID, eventID, c.GetInt("id")); err != nil {
+		handleError(c, err, "Error deleting scheduled session")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Scheduled session deleted successfully",
+	})
+}
+
+func SetRSVP(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid eventID")
+		return
+	}
+	var req RSVPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	if err := query.SetRSVP(c.Request.Context(), roomID, eventID, c.GetInt("id"), req.Response); err != nil {
+		handleError(c, err, "Error responding to scheduled session")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Response saved successfully",
+	})
+}
+
+func GetRoomSchedule(c *gin.Context) {
+	roomID, ok := checkMember(c)
+	if !ok {
+		return
+	}
+	events, err := query.GetRoomSchedule(c.Request.Context(), roomID, c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting room schedule")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      events,
+		"message":   "Room schedule retrieved successfully",
+	})
+}
+
+func GetRoomCalendar(c *gin.Context) {
+	roomID, ok := checkMember(c)
+	if !ok {
+		return
+	}
+	events, err := query.GetRoomSchedule(c.Request.Context(), roomID, c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting room schedule")
+		return
+	}
+	name := "Pot Together"
+	if len(events) > 0 {
+		name = events[0].RoomName
+	}
+	writeCalendar(c, name, events)
+}
+
+// GetUserCalendar serves the aggregated feed of a user, authenticated by the
+// secret token in the URL since calendar apps cannot send our auth header
+func GetUserCalendar(c *gin.Context) {
+	userID, err := query.GetCalendarUser(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		if err.Error() == "invalid calendar token" {
+			errhandler.Unauthorized(c, err, "Error getting calendar")
+			return
+		}
+		errhandler.Error(c, err, "Error getting calendar")
+		return
+	}
+	events, err := query.GetUserSchedule(c.Request.Context(), userID)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting user schedule")
+		return
+	}
+	writeCalendar(c, "Pot Together", events)
+}
+
+// RotateCalendarToken issues a new feed URL for the caller
+func RotateCalendarToken(c *gin.Context) {
+	token, err := query.RotateCalendarToken(c.Request.Context(), c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error creating calendar token")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"token": token,
+			"path":  "/calendar/" + token + "/feed.ics",
+		},
+		"message": "Calendar feed created successfully",
+	})
+}
@@ -0,0 +1,198 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"pottogether/internal/groupsession"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateSessionRequest struct {
+	FocusLength int `json:"focusLength" binding:"required,min=60,max=14400"`
+	BreakLength int `json:"breakLength" binding:"min=0,max=3600"`
+	Cycles      int `json:"cycles" binding:"required,min=1,max=12"`
+	StartsAt    int `json:"startsAt"`
+}
+
+type JoinSessionRequest struct {
+	IngredientID int `json:"ingredientID" binding:"required"`
+}
+
+// handleError responds 403 for permission errors, 400 for other expected errors
+// and 500 otherwise
+func handleError(c *gin.Context, err error, msg string) {
+	switch err.Error() {
+	case "user is not a room admin", "user not in room":
+		errhandler.Forbidden(c, err, msg)
+	case "session does not exist", "session has ended", "user not in session":
+		errhandler.Info(c, err, msg)
+	default:
+		errhandler.Error(c, err, msg)
+	}
+}
+
+// getSession parses the session id and checks it belongs to the room in the path
+func getSession(c *gin.Context) (query.GroupSession, bool) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return query.GroupSession{}, false
+	}
+	sessionID, err := strconv.Atoi(c.Param("sessionID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid sessionID")
+		return query.GroupSession{}, false
+	}
+	session, err := query.GetSession(c.Request.Context(), sessionID)
+	if err == nil && session.RoomID != roomID {
+		err = fmt.Errorf("session does not exist")
+	}
+	if err != nil {
+		handleError(c, err, "Error getting session")
+		return session, false
+	}
+	return session, true
+}
+
+func CreateSession(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	var req CreateSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if req.StartsAt == 0 {
+		req.StartsAt = int(time.Now().Unix())
+	} else if req.StartsAt < int(time.Now().Add(-time.Minute).Unix()) {
+		errhandler.Info(c, fmt.Errorf("startsAt is in the past"), "Invalid request format")
+		return
+	}
+	session := query.GroupSession{
+		ID:          -1,
+		RoomID:      roomID,
+		CreatedBy:   c.GetInt("id"),
+		FocusLength: req.FocusLength,
+		BreakLength: req.BreakLength,
+		Cycles:      req.Cycles,
+		StartsAt:    req.StartsAt,
+	}
+	sessionID, err := query.CreateSession(c.Request.Context(), session)
+	if err != nil {
+		handleError(c, err, "Error creating session")
+		return
+	}
+	groupsession.Start(sessionID)
+	if session, err := query.GetSession(c.Request.Context(), sessionID); err == nil {
+		groupsession.Broadcast(c.Request.Context(), session)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"sessionID": sessionID,
+		},
+		"message": "Session created successfully",
+	})
+}
+
+func GetSessions(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("roomID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid roomID")
+		return
+	}
+	sessions, err := query.GetRoomSessions(c.Request.Context(), roomID)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      sessions,
+		"message":   "Sessions retrieved successfully",
+	})
+}
+
+func GetSession(c *gin.Context) {
+	session, ok := getSession(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      session,
+		"message":   "Session retrieved successfully",
+	})
+}
+
+func JoinSession(c *gin.Context) {
+	session, ok := getSession(c)
+	if !ok {
+		return
+	}
+	var req JoinSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	if err := query.JoinSession(c.Request.Context(), session.ID, c.GetInt("id"), req.IngredientID); err != nil {
+		handleError(c, err, "Error joining session")
+		return
+	}
+	if session, err := query.GetSession(c.Request.Context(), session.ID); err == nil {
+		groupsession.Broadcast(c.Request.Context(), session)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Session joined successfully",
+	})
+}
+
+func LeaveSession(c *gin.Context) {
+	session, ok := getSession(c)
+	if !ok {
+		return
+	}
+	if err := query.LeaveSession(c.Request.Context(), session.ID, c.GetInt("id")); err != nil {
+		handleError(c, err, "Error leaving session")
+		return
+	}
+	if session, err := query.GetSession(c.Request.Context(), session.ID); err == nil {
+		groupsession.Broadcast(c.Request.Context(), session)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Session left successfully",
+	})
+}
+
+func CancelSession(c *gin.Context) {
+	session, ok := getSession(c)
+	if !ok {
+		return
+	}
+	session, err := query.CancelSession(c.Request.Context(), session.ID, c.GetInt("id"))
+	if err != nil {
+		handleError(c, err, "Error cancelling session")
+		return
+	}
+	groupsession.Stop(session.ID)
+	groupsession.Broadcast(c.Request.Context(), session)
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Session cancelled successfully",
+	})
+}
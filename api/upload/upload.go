@@ -0,0 +1,211 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"pottogether/config"
+	"pottogether/internal/media"
+	"pottogether/internal/s3"
+	"pottogether/pkg/errhandler"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateUploadRequest struct {
+	Kind        string `json:"kind" binding:"required,oneof=record ingredient"`
+	ContentType string `json:"contentType" binding:"required,oneof=image/jpeg image/png image/webp"`
+	Size        int    `json:"size" binding:"required,min=1"`
+}
+
+type FinalizeUploadRequest struct {
+	TargetID int `json:"targetID" binding:"required"`
+}
+
+// handleError responds 403 for permission errors, 400 for other expected errors
+// and 500 otherwise
+func handleError(c *gin.Context, err error, msg string) {
+	switch err.Error() {
+	case "record does not belong to user":
+		errhandler.Forbidden(c, err, msg)
+	case "upload does not exist", "upload is no longer pending", "record does not exist",
+		"ingredient does not exist", "ingredient already has an image", "invalid upload kind":
+		errhandler.Info(c, err, msg)
+	default:
+		errhandler.Error(c, err, msg)
+	}
+}
+
+// CreateUpload issues a presigned URL the client uploads the image to directly
+func CreateUpload(c *gin.Context) {
+	var req CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	maxBytes := config.Viper.GetInt("UPLOAD_MAX_BYTES")
+	if req.Size > maxBytes {
+		errhandler.Info(c, fmt.Errorf("image is larger than %d bytes", maxBytes), "Invalid request format")
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		errhandler.Error(c, err, "Error creating upload")
+		return
+	}
+	key := fmt.Sprintf("uploads/%s/%d/%s", req.Kind, c.GetInt("id"), hex.EncodeToString(buf))
+	ttl := config.Viper.GetInt("UPLOAD_URL_TTL_SECONDS")
+	url, err := s3.PresignUpload(key, req.ContentType, int64(req.Size), time.Duration(ttl)*time.Second)
+	if err != nil {
+		errhandler.Error(c, err, "Error creating upload")
+		return
+	}
+	upload := query.Upload{
+		ID:          -1,
+		UserID:      c.GetInt("id"),
+		Kind:        req.Kind,
+		ObjectKey:   key,
+		ContentType: req.ContentType,
+		MaxBytes:    req.Size,
+	}
+	uploadID, err := query.CreateUpload(c.Request.Context(), upload, ttl)
+	if err != nil {
+		errhandler.Error(c, err, "Error creating upload")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"uploadID": uploadID,
+			"url":      url,
+			"method":   http.MethodPut,
+			"headers": gin.H{
+				"Content-Type":   req.ContentType,
+				"Content-Length": strconv.Itoa(req.Size),
+			},
+			"expiresAt": time.Now().Add(time.Duration(ttl) * time.Second).Unix(),
+		},
+		"message": "Upload created successfully",
+	})
+}
+
+// FinalizeUpload checks the uploaded object, renders its variants and attaches
+// them to the record or ingredient
+func FinalizeUpload(c *gin.Context) {
+	uploadID, err := strconv.Atoi(c.Param("uploadID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid uploadID")
+		return
+	}
+	var req FinalizeUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	upload, err := query.GetPendingUpload(c.Request.Context(), uploadID, c.GetInt("id"))
+	if err != nil {
+		handleError(c, err, "Error finalizing upload")
+		return
+	}
+	if err := query.CheckUploadTarget(c.Request.Context(), upload.Kind, req.TargetID, c.GetInt("id")); err != nil {
+		handleError(c, err, "Error finalizing upload")
+		return
+	}
+	// claim the upload so that it is not expired while it is processed
+	if err := query.StartFinalizingUpload(c.Request.Context(), upload.ID); err != nil {
+		handleError(c, err, "Error finalizing upload")
+		return
+	}
+	// the presigned URL signs the size, check what was uploaded anyway
+	size, contentType, err := s3.HeadObject(c.Request.Context(), upload.ObjectKey)
+	if err == s3.ErrObjectNotFound {
+		release(c.Request.Context(), upload)
+		errhandler.Info(c, fmt.Errorf("image has not been uploaded"), "Error finalizing upload")
+		return
+	} else if err != nil {
+		release(c.Request.Context(), upload)
+		errhandler.Error(c, err, "Error finalizing upload")
+		return
+	}
+	if size > int64(upload.MaxBytes) || contentType != upload.ContentType {
+		discard(c.Request.Context(), upload)
+		errhandler.Info(c, fmt.Errorf("uploaded image does not match the upload"), "Error finalizing upload")
+		return
+	}
+	data, err := s3.GetObject(c.Request.Context(), upload.ObjectKey, int64(upload.MaxBytes))
+	if err != nil {
+		release(c.Request.Context(), upload)
+		errhandler.Error(c, err, "Error finalizing upload")
+		return
+	}
+	images, err := s3.StoreImage(c.Request.Context(), upload.Kind, data)
+	if err != nil {
+		if _, ok := err.(media.InvalidImageError); ok {
+			discard(c.Request.Context(), upload)
+			errhandler.Info(c, err, "Invalid image")
+			return
+		}
+		release(c.Request.Context(), upload)
+		errhandler.Error(c, err, "Error finalizing upload")
+		return
+	}
+	// attach before finalizing, so that a failed attach leaves the upload to
+	// be retried or expired along with its object
+	if upload.Kind == "record" {
+		err = query.SetRecordImage(c.Request.Context(), req.TargetID, images.Full, images.Medium, images.Thumbnail)
+	} else {
+		err = query.SetIngredientImage(c.Request.Context(), req.TargetID, images.Full, images.Medium, images.Thumbnail)
+	}
+	if err != nil {
+		release(c.Request.Context(), upload)
+		errhandler.Error(c, err, "Error finalizing upload")
+		return
+	}
+	if err := query.FinalizeUpload(c.Request.Context(), upload.ID); err != nil {
+		release(c.Request.Context(), upload)
+		handleError(c, err, "Error finalizing upload")
+		return
+	}
+	if upload.Kind == "record" {
+		s3.SignImages(&images.Full, &images.Medium, &images.Thumbnail)
+	}
+	// only the processed variants are kept
+	if err := s3.DeleteObject(c.Request.Context(), upload.ObjectKey); err != nil {
+		logger.WarnCtx(c.Request.Context(), "Error deleting finalized upload "+upload.ObjectKey+": "+err.Error())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"image":          images.Full,
+			"imageMedium":    images.Medium,
+			"imageThumbnail": images.Thumbnail,
+		},
+		"message": "Upload finalized successfully",
+	})
+}
+
+// release lets the upload be finalized again after an error that a retry may
+// not run into
+func release(ctx context.Context, upload query.Upload) {
+	if err := query.ReleaseUpload(ctx, upload.ID); err != nil {
+		logger.WarnCtx(ctx, "Error releasing upload "+strconv.Itoa(upload.ID)+": "+err.Error())
+	}
+}
+
+// discard deletes a rejected upload so it cannot be finalized again
+func discard(ctx context.Context, upload query.Upload) {
+	if err := s3.DeleteObject(ctx, upload.ObjectKey); err != nil {
+		logger.WarnCtx(ctx, "Error deleting rejected upload "+upload.ObjectKey+": "+err.Error())
+	}
+	if err := query.ExpireUpload(ctx, upload.ID); err != nil {
+		logger.WarnCtx(ctx, "Error expiring rejected upload "+strconv.Itoa(upload.ID)+": "+err.Error())
+	}
+}
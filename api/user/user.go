@@ -1,14 +1,23 @@
 package user
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"pottogether/config"
 	"pottogether/internal/auth"
+	"pottogether/internal/hash"
+	"pottogether/internal/metrics"
+	"pottogether/internal/oidc"
+	"pottogether/internal/ratelimit"
 	"pottogether/pkg/errhandler"
 	"pottogether/pkg/logger"
 	"pottogether/pkg/mariadb/query"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,11 +29,40 @@ type SignUpRequest struct {
 	Password string `json:"password"`
 }
 
+type UpdateSettingsRequest struct {
+	Timezone          *string `json:"timezone"`
+	ProfileVisibility *string `json:"profileVisibility"`
+}
+
+type SetGoalRequest struct {
+	Period string `json:"period" binding:"required"`
+	Target int    `json:"target" binding:"min=0"`
+}
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// OIDCLoginRequest carries either the ID token obtained by a mobile app or
+// the authorization code and PKCE verifier obtained by a web client, along
+// with the nonce from CreateOIDCNonce. Name and avatar are only used when the
+// login creates the user.
+type OIDCLoginRequest struct {
+	IDToken      string `json:"idToken"`
+	Code         string `json:"code"`
+	RedirectURI  string `json:"redirectURI"`
+	CodeVerifier string `json:"codeVerifier"`
+	Nonce        string `json:"nonce"`
+	Name         string `json:"name"`
+	Avatar       int    `json:"avatar"`
+}
+
 func Signup(c *gin.Context) {
 	// Parse request body to JSON format
 	var req SignUpRequest
@@ -32,14 +70,19 @@ func Signup(c *gin.Context) {
 		errhandler.Info(c, err, "Invalid request format")
 		return
 	}
-	logger.Info("Request content: " + fmt.Sprintf("%+v", req))
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
 	// Check email format
 	if !strings.Contains(req.Email, "@") {
 		errhandler.Info(c, fmt.Errorf("invalid email format"), "Error checking email format")
 		return
 	}
+	// Check password policy
+	if err := hash.CheckPolicy(req.Password); err != nil {
+		errhandler.Info(c, err, "Error checking password policy")
+		return
+	}
 	// Check if email already exists
-	exists, err := query.CheckEmail(req.Email)
+	exists, err := query.CheckEmail(c.Request.Context(), req.Email)
 	if err != nil {
 		errhandler.Error(c, err, "Error checking email existence")
 		return
@@ -56,11 +99,12 @@ func Signup(c *gin.Context) {
 		Password: req.Password,
 	}
 	// Register the user
-	id, err := query.SignUp(user)
+	id, err := query.SignUp(c.Request.Context(), user)
 	if err != nil {
 		errhandler.Error(c, err, "Error registering user")
 		return
 	}
+	metrics.SignedUp()
 	// Response
 	c.JSON(http.StatusOK, gin.H{
 		"isSuccess": true,
@@ -76,16 +120,31 @@ func Login(c *gin.Context) {
 		errhandler.Info(c, err, "Invalid request format")
 		return
 	}
-	logger.Info("Request content: " + fmt.Sprintf("%+v", req))
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	// Reject attempts on locked or hammered accounts before hashing
+	wait, err := ratelimit.CheckLogin(c.Request.Context(), req.Email)
+	if err != nil {
+		errhandler.Error(c, err, "Error checking login attempts")
+		return
+	} else if wait > 0 {
+		ratelimit.Reject(c, wait)
+		return
+	}
 	// Login the user
-	id, err := query.Login(req.Email, req.Password)
+	id, err := query.Login(c.Request.Context(), req.Email, req.Password)
 	if err != nil {
 		errhandler.Unauthorized(c, err, "Error logging in user")
 		return
 	} else if id == -1 {
+		if _, err := ratelimit.LoginFailed(c.Request.Context(), req.Email); err != nil {
+			logger.ErrorCtx(c.Request.Context(), "Error recording failed login: "+err.Error())
+		}
 		errhandler.Unauthorized(c, fmt.Errorf("invalid email or password"), "Error logging in user")
 		return
 	}
+	if err := ratelimit.LoginSucceeded(c.Request.Context(), req.Email); err != nil {
+		logger.ErrorCtx(c.Request.Context(), "Error clearing failed logins: "+err.Error())
+	}
 	// Generate token
 	token, err := auth.GenerateToken(id, req.Email)
 	if err != nil {
@@ -100,6 +159,172 @@ func Login(c *gin.Context) {
 	})
 }
 
+// CreateOIDCNonce issues the single-use nonce the app passes to the provider,
+// the ID token used to log in must carry it
+func CreateOIDCNonce(c *gin.Context) {
+	provider, err := oidc.Get(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "unknown provider") {
+			errhandler.Info(c, err, "Error getting provider")
+			return
+		}
+		errhandler.Error(c, err, "Error getting provider")
+		return
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		errhandler.Error(c, err, "Error creating nonce")
+		return
+	}
+	nonce := hex.EncodeToString(buf)
+	ttl := config.Viper.GetInt("OIDC_NONCE_TTL_SECONDS")
+	if err := query.CreateOIDCNonce(c.Request.Context(), hashNonce(nonce), provider.Name, ttl); err != nil {
+		errhandler.Error(c, err, "Error creating nonce")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data": gin.H{
+			"nonce":     nonce,
+			"expiresAt": time.Now().Add(time.Duration(ttl) * time.Second).Unix(),
+		},
+		"message": "Nonce created successfully",
+	})
+}
+
+// OIDCLogin logs in with an account at an OpenID Connect provider. Accounts
+// seen for the first time are linked to the user with the same verified
+// email, or to a new user when there is none.
+func OIDCLogin(c *gin.Context) {
+	// Parse request body to JSON format
+	var req OIDCLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if (req.IDToken == "") == (req.Code == "") {
+		errhandler.Info(c, fmt.Errorf("either idToken or code is required"), "Invalid request format")
+		return
+	}
+	if req.Nonce == "" {
+		errhandler.Info(c, fmt.Errorf("nonce is required"), "Invalid request format")
+		return
+	}
+	if req.Code != "" && req.CodeVerifier == "" {
+		errhandler.Info(c, fmt.Errorf("codeVerifier is required"), "Invalid request format")
+		return
+	}
+	provider, err := oidc.Get(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "unknown provider") {
+			errhandler.Info(c, err, "Error getting provider")
+			return
+		}
+		errhandler.Error(c, err, "Error getting provider")
+		return
+	}
+	// Verify the identity
+	var identity oidc.Identity
+	if req.IDToken != "" {
+		identity, err = provider.VerifyIDToken(c.Request.Context(), req.IDToken, req.Nonce)
+	} else {
+		identity, err = provider.Exchange(c.Request.Context(), req.Code, req.RedirectURI, req.CodeVerifier, req.Nonce)
+	}
+	if err != nil {
+		errhandler.Unauthorized(c, err, "Error verifying identity")
+		return
+	}
+	// Use up the nonce so that the token cannot be replayed
+	if err := query.RedeemOIDCNonce(c.Request.Context(), hashNonce(req.Nonce), provider.Name); err != nil {
+		if err.Error() == "invalid nonce" {
+			errhandler.Unauthorized(c, err, "Error verifying identity")
+			return
+		}
+		errhandler.Error(c, err, "Error verifying identity")
+		return
+	}
+	// Find the user of the identity
+	id, email, err := query.GetIdentityUser(c.Request.Context(), identity.Provider, identity.Subject)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting identity")
+		return
+	}
+	if id == -1 {
+		// Only emails the provider verified may be linked or registered
+		if !identity.EmailVerified || !strings.Contains(identity.Email, "@") {
+			errhandler.Unauthorized(c, fmt.Errorf("email is not verified by %s", identity.Provider), "Error linking identity")
+			return
+		}
+		email = identity.Email
+		if id, err = query.GetUserIDByEmail(c.Request.Context(), email); err != nil {
+			errhandler.Error(c, err, "Error checking email existence")
+			return
+		}
+		if id != -1 {
+			err = query.LinkIdentity(c.Request.Context(), id, identity.Provider, identity.Subject, email)
+		} else {
+			name := req.Name
+			if name == "" {
+				name = identity.Name
+			}
+			if name == "" {
+				name = strings.Split(email, "@")[0]
+			}
+			id, err = query.SignUpWithIdentity(c.Request.Context(), query.User{Avatar: req.Avatar, Name: name, Email: email}, identity.Provider, identity.Subject)
+			if err == nil {
+				metrics.SignedUp()
+			}
+		}
+		if err != nil {
+			errhandler.Error(c, err, "Error linking identity")
+			return
+		}
+	}
+	// Generate token
+	token, err := auth.GenerateToken(id, email)
+	if err != nil {
+		errhandler.Error(c, err, "Error generating token")
+		return
+	}
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      token,
+		"message":   "Successfully logged in user with email: " + email,
+	})
+}
+
+func ChangePassword(c *gin.Context) {
+	// Parse request body to JSON format
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	// Check password policy
+	if err := hash.CheckPolicy(req.NewPassword); err != nil {
+		errhandler.Info(c, err, "Error checking password policy")
+		return
+	}
+	// Change the password
+	if err := query.ChangePassword(c.Request.Context(), c.GetInt("id"), req.CurrentPassword, req.NewPassword); err != nil {
+		if err.Error() == "current password is incorrect" {
+			errhandler.Forbidden(c, err, "Error changing password")
+			return
+		}
+		errhandler.Error(c, err, "Error changing password")
+		return
+	}
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Successfully changed password",
+	})
+}
+
 func GetProfile(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("userID"))
 	if err != nil {
@@ -107,7 +332,7 @@ func GetProfile(c *gin.Context) {
 		return
 	}
 	// Check if user exists
-	exists, err := query.CheckUser(id)
+	exists, err := query.CheckUser(c.Request.Context(), id)
 	if err != nil {
 		errhandler.Error(c, err, "Error checking user existence")
 		return
@@ -115,8 +340,17 @@ func GetProfile(c *gin.Context) {
 		errhandler.Info(c, fmt.Errorf("user with id %d does not exist", id), "Error checking user existence")
 		return
 	}
+	// Check profile visibility
+	visible, err := query.CanViewProfile(c.Request.Context(), c.GetInt("id"), id)
+	if err != nil {
+		errhandler.Error(c, err, "Error checking profile visibility")
+		return
+	} else if !visible {
+		errhandler.Forbidden(c, fmt.Errorf("profile of user %d is only visible to friends", id), "Error getting user profile")
+		return
+	}
 	// Get user info
-	userProfile, err := query.GetProfile(id)
+	userProfile, err := query.GetProfile(c.Request.Context(), id)
 	if err != nil {
 		errhandler.Error(c, err, "Error getting user profile")
 		return
@@ -136,7 +370,7 @@ func GetOverview(c *gin.Context) {
 		return
 	}
 	// Get user overview
-	userOverview, err := query.GetOverview(id)
+	userOverview, err := query.GetOverview(c.Request.Context(), id)
 	if err != nil {
 		errhandler.Error(c, err, "Error getting user overview")
 		return
@@ -148,3 +382,146 @@ func GetOverview(c *gin.Context) {
 		"message":   "Successfully retrieved user overview",
 	})
 }
+
+func GetStats(c *gin.Context) {
+	statsRange, err := query.NewStatsRange(c.Query("from"), c.Query("to"), c.Query("granularity"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	stats, err := query.GetUserStats(c.Request.Context(), c.GetInt("id"), statsRange)
+	if err != nil {
+		if err.Error() == "invalid date range" {
+			errhandler.Info(c, err, "Invalid request format")
+			return
+		}
+		errhandler.Error(c, err, "Error getting user stats")
+		return
+	}
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      stats,
+		"message":   "Successfully retrieved user stats",
+	})
+}
+
+func UpdateSettings(c *gin.Context) {
+	var req UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	// Check timezone
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			errhandler.Info(c, fmt.Errorf("invalid timezone"), "Error updating user settings")
+			return
+		}
+	}
+	// Check profile visibility
+	if req.ProfileVisibility != nil && *req.ProfileVisibility != "public" && *req.ProfileVisibility != "friends" {
+		errhandler.Info(c, fmt.Errorf("invalid profile visibility"), "Error updating user settings")
+		return
+	}
+	settings := query.UserSettings{
+		Timezone:          req.Timezone,
+		ProfileVisibility: req.ProfileVisibility,
+	}
+	if err := query.UpdateSettings(c.Request.Context(), c.GetInt("id"), settings); err != nil {
+		errhandler.Error(c, err, "Error updating user settings")
+		return
+	}
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Successfully updated user settings",
+	})
+}
+
+func GetHeatmap(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid userID")
+		return
+	}
+	year, err := strconv.Atoi(c.DefaultQuery("year", strconv.Itoa(time.Now().Year())))
+	if err != nil {
+		errhandler.Info(c, err, "Invalid year")
+		return
+	}
+	// Check if user exists
+	exists, err := query.CheckUser(c.Request.Context(), id)
+	if err != nil {
+		errhandler.Error(c, err, "Error checking user existence")
+		return
+	} else if !exists {
+		errhandler.Info(c, fmt.Errorf("user with id %d does not exist", id), "Error checking user existence")
+		return
+	}
+	// Check profile visibility
+	visible, err := query.CanViewProfile(c.Request.Context(), c.GetInt("id"), id)
+	if err != nil {
+		errhandler.Error(c, err, "Error checking profile visibility")
+		return
+	} else if !visible {
+		errhandler.Forbidden(c, fmt.Errorf("profile of user %d is only visible to friends", id), "Error getting user heatmap")
+		return
+	}
+	heatmap, err := query.GetUserHeatmap(c.Request.Context(), id, year)
+	if err != nil {
+		errhandler.Error(c, err, "Error getting user heatmap")
+		return
+	}
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      heatmap,
+		"message":   "Successfully retrieved user heatmap",
+	})
+}
+
+func GetGoals(c *gin.Context) {
+	goals, err := query.GetUserGoals(c.Request.Context(), c.GetInt("id"))
+	if err != nil {
+		errhandler.Error(c, err, "Error getting user goals")
+		return
+	}
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      goals,
+		"message":   "Successfully retrieved user goals",
+	})
+}
+
+func SetGoal(c *gin.Context) {
+	var req SetGoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errhandler.Info(c, err, "Invalid request format")
+		return
+	}
+	logger.InfoCtx(c.Request.Context(), "Request content", logger.Redact("request", req))
+	if err := query.SetUserGoal(c.Request.Context(), c.GetInt("id"), req.Period, req.Target); err != nil {
+		if err.Error() == "invalid period" {
+			errhandler.Info(c, err, "Error setting user goal")
+			return
+		}
+		errhandler.Error(c, err, "Error setting user goal")
+		return
+	}
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"isSuccess": true,
+		"data":      nil,
+		"message":   "Successfully set user goal",
+	})
+}
+
+// hashNonce returns the form nonces are stored in
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
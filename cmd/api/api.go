@@ -2,6 +2,7 @@ package main
 
 import (
 	"pottogether/api"
+	_ "time/tzdata"
 )
 
 func main() {
@@ -0,0 +1,35 @@
+// Command mockoidc is an OpenID Connect provider for development and testing.
+// It signs in whoever asks, so that the login flows can be run without a
+// Google or Apple account. Configure the API with
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9000
+//	OIDC_MOCK_CLIENT_IDS=pottogether
+//
+// Both flows start with a nonce from POST /users/oidc/mock/nonce. Mobile flow:
+// GET /issue?sub=1&email=a@b.c&nonce=... returns an ID token to post as
+// idToken to /users/oidc/mock along with the nonce. Web flow: /authorize,
+// given a PKCE code_challenge and the nonce, redirects straight back with a
+// code for the user given by the sub and login_hint parameters.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"pottogether/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer, the URL the API reaches this server at")
+	clientID := flag.String("client-id", "pottogether", "audience of the issued tokens")
+	flag.Parse()
+
+	p, err := oidctest.New(*issuer, *clientID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("mock OIDC provider %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p.Handler()))
+}
@@ -15,6 +15,7 @@ func LoadConfig() *viper.Viper {
 	vp.SetConfigType("env")
 	vp.AddConfigPath("config")
 	vp.AutomaticEnv()
+	setDefaults(vp)
 	if err := vp.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", vp.ConfigFileUsed())
 		Viper = vp
@@ -23,4 +24,104 @@ func LoadConfig() *viper.Viper {
 		fmt.Println("Error loading config file:", err)
 		return nil
 	}
-}
\ No newline at end of file
+}
+
+// setDefaults sets the values of optional settings missing from the config file
+func setDefaults(vp *viper.Viper) {
+	// Extra room total time for records finished in a group session, in percent
+	vp.SetDefault("GROUP_SESSION_BONUS_PERCENT", 20)
+	// Cooking records without heartbeats are abandoned after this many seconds
+	vp.SetDefault("RECORD_MAX_SESSION_SECONDS", 4*60*60)
+	// Cooking records sending heartbeats are abandoned once they stop for this long
+	vp.SetDefault("RECORD_HEARTBEAT_TIMEOUT_SECONDS", 10*60)
+	// Local hour at which users are reminded of a streak at risk
+	vp.SetDefault("STREAK_REMINDER_HOUR", 20)
+	// Lifetime of the tickets websocket clients connect to a room with
+	vp.SetDefault("WS_TICKET_TTL_SECONDS", 30)
+	// Comma separated origins of web clients allowed to open websockets,
+	// besides the API's own origin and native clients that send none
+	vp.SetDefault("WS_ALLOWED_ORIGINS", "")
+	// Interval at which each instance reads the room events published by the others
+	vp.SetDefault("REALTIME_POLL_MS", 500)
+	// Largest accepted image upload
+	vp.SetDefault("UPLOAD_MAX_BYTES", 10<<20)
+	// Lifetime of presigned upload URLs, pending uploads expire with them
+	vp.SetDefault("UPLOAD_URL_TTL_SECONDS", 15*60)
+	// Lifetime of signed URLs to private record photos
+	vp.SetDefault("MEDIA_URL_TTL_SECONDS", 15*60)
+	// Unreferenced images are kept this long before media_gc deletes them
+	vp.SetDefault("MEDIA_GC_GRACE_HOURS", 72)
+	// media_gc only reports orphans until this is turned off
+	vp.SetDefault("MEDIA_GC_DRY_RUN", true)
+	// Bearer token required to scrape /metrics, the route is refused while unset
+	vp.SetDefault("METRICS_TOKEN", "")
+	// Interval at which gauges read from the database are refreshed
+	vp.SetDefault("METRICS_REFRESH_SECONDS", 30)
+	// Span exporter: "otlp", "stdout" or "none"
+	vp.SetDefault("TRACING_EXPORTER", "none")
+	// Share of new traces that are sampled, requests from traced callers follow the caller
+	vp.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+	// Send spans over plain HTTP, e.g. to a collector sidecar
+	vp.SetDefault("TRACING_OTLP_INSECURE", false)
+	// Connection attempts after the first one at startup, with exponential backoff
+	vp.SetDefault("MARIADB_CONNECT_RETRIES", 5)
+	// Schema scripts applied at startup, empty to manage the schema by hand
+	vp.SetDefault("MARIADB_MIGRATIONS_DIR", "deployments/volumes/mariadb/initdb")
+	// Time each readiness check gets before the instance is reported not ready
+	vp.SetDefault("READINESS_TIMEOUT_SECONDS", 2)
+	// Time between failing readiness and stopping the server on shutdown
+	vp.SetDefault("SHUTDOWN_DRAIN_SECONDS", 5)
+	// Minimum level logged: debug, info, warn or error
+	vp.SetDefault("LOG_LEVEL", "info")
+	// Log files are rotated at this size, keeping the given number of old
+	// files for the given number of days
+	vp.SetDefault("LOG_MAX_SIZE_MB", 100)
+	vp.SetDefault("LOG_MAX_BACKUPS", 10)
+	vp.SetDefault("LOG_MAX_AGE_DAYS", 30)
+	vp.SetDefault("LOG_COMPRESS", true)
+	// Rate limiter buckets: "memory" per instance or "mariadb" shared
+	vp.SetDefault("RATE_LIMIT_BACKEND", "memory")
+	// Signups and logins per client IP, per minute on average and at once
+	vp.SetDefault("AUTH_IP_RATE_PER_MINUTE", 20)
+	vp.SetDefault("AUTH_IP_BURST", 10)
+	// Login attempts per account, per minute on average and at once
+	vp.SetDefault("LOGIN_ACCOUNT_RATE_PER_MINUTE", 5)
+	vp.SetDefault("LOGIN_ACCOUNT_BURST", 5)
+	// Failed logins in a row after which the account is locked, the lock
+	// starts at the base duration and doubles with each further failure
+	vp.SetDefault("LOGIN_LOCKOUT_THRESHOLD", 5)
+	vp.SetDefault("LOGIN_LOCKOUT_BASE_SECONDS", 30)
+	vp.SetDefault("LOGIN_LOCKOUT_MAX_SECONDS", 60*60)
+	// Requests changing data per user, per minute on average and at once
+	vp.SetDefault("WRITE_RATE_PER_MINUTE", 120)
+	vp.SetDefault("WRITE_BURST", 30)
+	// Comma separated proxies whose X-Real-IP header is trusted for the client IP
+	vp.SetDefault("TRUSTED_PROXIES", "127.0.0.0/8,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16")
+	// Algorithm of new password hashes, "argon2id" or "bcrypt". Hashes made
+	// with another algorithm or other parameters are replaced on login.
+	vp.SetDefault("PASSWORD_HASH_ALGORITHM", "argon2id")
+	vp.SetDefault("ARGON2_MEMORY_KB", 19*1024)
+	vp.SetDefault("ARGON2_ITERATIONS", 2)
+	vp.SetDefault("ARGON2_PARALLELISM", 1)
+	vp.SetDefault("BCRYPT_COST", 12)
+	// Length limits of new passwords, in characters
+	vp.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	vp.SetDefault("PASSWORD_MAX_LENGTH", 128)
+	// File of breached passwords that cannot be chosen, plain or as SHA-1
+	vp.SetDefault("PASSWORD_BREACHED_LIST", "")
+	// Comma separated OpenID Connect providers users can log in with, each
+	// configured by OIDC_<NAME>_ISSUER (known for google and apple),
+	// OIDC_<NAME>_CLIENT_IDS and OIDC_<NAME>_CLIENT_SECRET, or for apple
+	// OIDC_APPLE_KEY_FILE, OIDC_APPLE_KEY_ID and OIDC_APPLE_TEAM_ID
+	vp.SetDefault("OIDC_PROVIDERS", "")
+	// Lifetime of the nonces issued for OpenID Connect logins
+	vp.SetDefault("OIDC_NONCE_TTL_SECONDS", 10*60)
+	// Local hour on Monday at which the weekly digest is sent
+	vp.SetDefault("DIGEST_HOUR", 9)
+	// Emails are written to this directory when SMTP_HOST is not set
+	vp.SetDefault("MAIL_DIR", "mail")
+	vp.SetDefault("MAIL_FROM", "Pot Together <no-reply@pottogether.app>")
+	vp.SetDefault("SMTP_PORT", 587)
+	// Comma separated ids of the users allowed to use the admin routes
+	vp.SetDefault("ADMIN_USER_IDS", "")
+}
@@ -0,0 +1,15 @@
+-- Daily focus time rollup, one row per user per room per day.
+-- Maintained by query.UpdateRecord when a record is finished.
+CREATE TABLE IF NOT EXISTS focus_daily (
+    user_id    INT  NOT NULL,
+    room_id    INT  NOT NULL,
+    day        DATE NOT NULL,
+    total_time INT  NOT NULL DEFAULT 0,
+    record_cnt INT  NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, room_id, day),
+    KEY idx_focus_daily_room_day (room_id, day),
+    KEY idx_focus_daily_day (day)
+);
+
+-- Existing records are backfilled by 03_user_timezone.sql, once records can
+-- be bucketed by the day in their user's timezone.
@@ -0,0 +1,3 @@
+-- Range scans for the statistics endpoints
+CREATE INDEX IF NOT EXISTS idx_record_user_finish ON record (user_id, status, finish_time);
+CREATE INDEX IF NOT EXISTS idx_record_room_finish ON record (room_id, status, finish_time);
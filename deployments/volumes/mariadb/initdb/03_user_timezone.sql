@@ -0,0 +1,17 @@
+-- IANA timezone name used to bucket a user's records into days
+ALTER TABLE user ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- Backfill focus_daily from the finished records, on the day in each user's
+-- own timezone like query.UpdateRecord does. The rollup only holds finished
+-- records, so it is rebuilt from scratch. finish_time is in the server's
+-- timezone; without the named zones loaded CONVERT_TZ returns NULL and the
+-- server's date is used.
+DELETE FROM focus_daily;
+INSERT INTO focus_daily (user_id, room_id, day, total_time, record_cnt)
+SELECT r.user_id, r.room_id,
+    DATE(COALESCE(CONVERT_TZ(r.finish_time, @@session.time_zone, u.timezone), r.finish_time)) AS local_day,
+    SUM(r.time_interval), COUNT(*)
+FROM record r
+INNER JOIN user u ON r.user_id = u.id
+WHERE r.status = 1
+GROUP BY r.user_id, r.room_id, local_day;
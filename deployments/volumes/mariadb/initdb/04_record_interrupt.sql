@@ -0,0 +1,9 @@
+-- Individual interruptions of a cooking record
+CREATE TABLE IF NOT EXISTS record_interrupt (
+    id          INT         NOT NULL AUTO_INCREMENT,
+    record_id   INT         NOT NULL,
+    occurred_at DATETIME    NOT NULL,
+    reason      VARCHAR(64) NULL,
+    PRIMARY KEY (id),
+    KEY idx_record_interrupt_record (record_id, occurred_at)
+);
@@ -0,0 +1,28 @@
+-- Personal focus-time goals, at most one per period
+CREATE TABLE IF NOT EXISTS user_goal (
+    user_id INT         NOT NULL,
+    period  VARCHAR(16) NOT NULL,
+    target  INT         NOT NULL,
+    PRIMARY KEY (user_id, period)
+);
+
+-- Shared weekly room goals
+CREATE TABLE IF NOT EXISTS room_goal (
+    room_id INT         NOT NULL,
+    period  VARCHAR(16) NOT NULL,
+    target  INT         NOT NULL,
+    PRIMARY KEY (room_id, period)
+);
+
+-- Goals reached, one row per owner per period
+CREATE TABLE IF NOT EXISTS goal_completion (
+    id           INT         NOT NULL AUTO_INCREMENT,
+    owner_type   VARCHAR(16) NOT NULL,
+    owner_id     INT         NOT NULL,
+    period       VARCHAR(16) NOT NULL,
+    period_start DATE        NOT NULL,
+    target       INT         NOT NULL,
+    completed_at DATETIME    NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_goal_completion (owner_type, owner_id, period, period_start)
+);
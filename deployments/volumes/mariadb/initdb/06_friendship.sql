@@ -0,0 +1,13 @@
+-- Friend requests and friendships, one row per pair of users
+CREATE TABLE IF NOT EXISTS friendship (
+    requester_id INT         NOT NULL,
+    addressee_id INT         NOT NULL,
+    status       VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_at   DATETIME    NOT NULL,
+    responded_at DATETIME    NULL,
+    PRIMARY KEY (requester_id, addressee_id),
+    KEY idx_friendship_addressee (addressee_id, status)
+);
+
+-- Who can see a user's profile: 'public' or 'friends'
+ALTER TABLE user ADD COLUMN IF NOT EXISTS profile_visibility VARCHAR(16) NOT NULL DEFAULT 'public';
@@ -0,0 +1,44 @@
+-- Member roles: 'owner' (room creator), 'admin' or 'member'
+ALTER TABLE room_user ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'member';
+
+-- Rooms created before roles existed get an owner. Their creator was not
+-- recorded, so it is the member who cooked in the room first, or the longest
+-- registered member of rooms without records.
+UPDATE room_user ru
+INNER JOIN (
+    SELECT m.room_id,
+        COALESCE(
+            (SELECT r.user_id FROM record r
+             INNER JOIN room_user x ON x.room_id = r.room_id AND x.user_id = r.user_id
+             WHERE r.room_id = m.room_id
+             ORDER BY r.created_at, r.id LIMIT 1),
+            MIN(m.user_id)) AS owner_id
+    FROM room_user m
+    WHERE NOT EXISTS (SELECT 1 FROM room_user o WHERE o.room_id = m.room_id AND o.role = 'owner')
+    GROUP BY m.room_id
+) pick ON ru.room_id = pick.room_id AND ru.user_id = pick.owner_id
+SET ru.role = 'owner';
+
+-- Emoji reactions on records, one per user per emoji
+CREATE TABLE IF NOT EXISTS record_reaction (
+    record_id  INT         NOT NULL,
+    user_id    INT         NOT NULL,
+    emoji      VARCHAR(32) NOT NULL,
+    created_at DATETIME    NOT NULL,
+    PRIMARY KEY (record_id, user_id, emoji),
+    KEY idx_record_reaction_record (record_id, emoji)
+);
+
+-- Comments on records, replies point to their parent comment
+CREATE TABLE IF NOT EXISTS record_comment (
+    id         INT      NOT NULL AUTO_INCREMENT,
+    record_id  INT      NOT NULL,
+    user_id    INT      NOT NULL,
+    parent_id  INT      NULL,
+    content    TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NULL,
+    deleted_at DATETIME NULL,
+    PRIMARY KEY (id),
+    KEY idx_record_comment_record (record_id, created_at)
+);
@@ -0,0 +1,12 @@
+-- Room chat messages; system messages have no user
+CREATE TABLE IF NOT EXISTS room_message (
+    id         INT         NOT NULL AUTO_INCREMENT,
+    room_id    INT         NOT NULL,
+    user_id    INT         NULL,
+    kind       VARCHAR(16) NOT NULL DEFAULT 'text',
+    content    TEXT        NOT NULL,
+    created_at DATETIME    NOT NULL,
+    deleted_at DATETIME    NULL,
+    PRIMARY KEY (id),
+    KEY idx_room_message_room (room_id, id)
+);
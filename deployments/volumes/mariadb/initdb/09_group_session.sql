@@ -0,0 +1,31 @@
+-- Room-wide pomodoro sessions driven by the server
+CREATE TABLE IF NOT EXISTS group_session (
+    id            INT         NOT NULL AUTO_INCREMENT,
+    room_id       INT         NOT NULL,
+    created_by    INT         NOT NULL,
+    focus_length  INT         NOT NULL,
+    break_length  INT         NOT NULL,
+    cycles        INT         NOT NULL,
+    starts_at     DATETIME    NOT NULL,
+    status        VARCHAR(16) NOT NULL DEFAULT 'scheduled',
+    phase         VARCHAR(16) NULL,
+    cycle         INT         NOT NULL DEFAULT 0,
+    phase_ends_at DATETIME    NULL,
+    created_at    DATETIME    NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_group_session_room (room_id, status),
+    KEY idx_group_session_status (status)
+);
+
+-- Members who opted into a session and the ingredient they cook
+CREATE TABLE IF NOT EXISTS group_session_participant (
+    session_id    INT      NOT NULL,
+    user_id       INT      NOT NULL,
+    ingredient_id INT      NOT NULL,
+    joined_at     DATETIME NOT NULL,
+    PRIMARY KEY (session_id, user_id)
+);
+
+-- Records created for the participants of a session
+ALTER TABLE record ADD COLUMN IF NOT EXISTS session_id INT NULL;
+CREATE INDEX IF NOT EXISTS idx_record_session ON record (session_id, status);
@@ -0,0 +1,28 @@
+-- Scheduled focus sessions published by rooms, repeated by an RFC 5545 RRULE
+CREATE TABLE IF NOT EXISTS room_event (
+    id          INT          NOT NULL AUTO_INCREMENT,
+    room_id     INT          NOT NULL,
+    created_by  INT          NOT NULL,
+    title       VARCHAR(255) NOT NULL,
+    description TEXT         NOT NULL,
+    starts_at   DATETIME     NOT NULL,
+    duration    INT          NOT NULL,
+    rrule       VARCHAR(255) NOT NULL DEFAULT '',
+    created_at  DATETIME     NOT NULL,
+    deleted_at  DATETIME     NULL,
+    PRIMARY KEY (id),
+    KEY idx_room_event_room (room_id, deleted_at)
+);
+
+-- Member responses to scheduled sessions: 'yes', 'no' or 'maybe'
+CREATE TABLE IF NOT EXISTS room_event_rsvp (
+    event_id   INT         NOT NULL,
+    user_id    INT         NOT NULL,
+    response   VARCHAR(16) NOT NULL,
+    updated_at DATETIME    NOT NULL,
+    PRIMARY KEY (event_id, user_id)
+);
+
+-- Secret token of the user's calendar feed URL
+ALTER TABLE user ADD COLUMN IF NOT EXISTS calendar_token VARCHAR(64) NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_user_calendar_token ON user (calendar_token);
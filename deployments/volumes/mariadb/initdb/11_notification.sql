@@ -0,0 +1,40 @@
+-- Notifications of a user. Rows with in_app = 0 only exist to be pushed.
+CREATE TABLE IF NOT EXISTS notification (
+    id              INT          NOT NULL AUTO_INCREMENT,
+    user_id         INT          NOT NULL,
+    type            VARCHAR(32)  NOT NULL,
+    title           VARCHAR(255) NOT NULL,
+    body            TEXT         NOT NULL,
+    data            TEXT         NOT NULL,
+    in_app          BOOLEAN      NOT NULL DEFAULT TRUE,
+    read_at         DATETIME     NULL,
+    push_status     VARCHAR(16)  NOT NULL DEFAULT 'none',
+    push_attempts   INT          NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME     NULL,
+    created_at      DATETIME     NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_notification_user (user_id, in_app, id),
+    KEY idx_notification_push (push_status, next_attempt_at)
+);
+
+-- Per-user per-type channel preferences, missing rows use the defaults
+CREATE TABLE IF NOT EXISTS notification_preference (
+    user_id INT         NOT NULL,
+    type    VARCHAR(32) NOT NULL,
+    in_app  BOOLEAN     NOT NULL,
+    push    BOOLEAN     NOT NULL,
+    email   BOOLEAN     NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
+
+-- Push tokens of the user's devices, platform is 'fcm' or 'apns'
+CREATE TABLE IF NOT EXISTS user_device (
+    id         INT          NOT NULL AUTO_INCREMENT,
+    user_id    INT          NOT NULL,
+    platform   VARCHAR(16)  NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    created_at DATETIME     NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_user_device_token (platform, token),
+    KEY idx_user_device_user (user_id)
+);
@@ -0,0 +1,23 @@
+-- Background jobs. The row doubles as a lease so that only one replica runs
+-- a job at a time.
+CREATE TABLE IF NOT EXISTS job (
+    name         VARCHAR(64) NOT NULL,
+    next_run_at  DATETIME    NOT NULL,
+    locked_by    VARCHAR(64) NULL,
+    locked_until DATETIME    NULL,
+    PRIMARY KEY (name)
+);
+
+-- Run history of the background jobs
+CREATE TABLE IF NOT EXISTS job_run (
+    id          INT          NOT NULL AUTO_INCREMENT,
+    name        VARCHAR(64)  NOT NULL,
+    trigger_by  VARCHAR(16)  NOT NULL,
+    runner      VARCHAR(64)  NOT NULL,
+    status      VARCHAR(16)  NOT NULL,
+    result      TEXT         NULL,
+    started_at  DATETIME     NOT NULL,
+    finished_at DATETIME     NULL,
+    PRIMARY KEY (id),
+    KEY idx_job_run_name (name, id)
+);
@@ -0,0 +1,4 @@
+-- Last heartbeat of a cooking record, NULL for clients that do not send any
+ALTER TABLE record ADD COLUMN IF NOT EXISTS last_heartbeat_at DATETIME NULL;
+
+CREATE INDEX IF NOT EXISTS idx_record_status_created ON record (status, created_at);
@@ -0,0 +1,7 @@
+-- Weekly digests already sent, so each user gets one per week
+CREATE TABLE IF NOT EXISTS digest_sent (
+    user_id    INT      NOT NULL,
+    week_start DATE     NOT NULL,
+    sent_at    DATETIME NOT NULL,
+    PRIMARY KEY (user_id, week_start)
+);
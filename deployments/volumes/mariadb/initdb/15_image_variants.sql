@@ -0,0 +1,6 @@
+-- Resized variants of uploaded images, NULL for images uploaded before they
+-- existed, in which case the original is served instead
+ALTER TABLE record ADD COLUMN IF NOT EXISTS image_medium VARCHAR(255) NULL;
+ALTER TABLE record ADD COLUMN IF NOT EXISTS image_thumbnail VARCHAR(255) NULL;
+ALTER TABLE ingredient ADD COLUMN IF NOT EXISTS image_medium VARCHAR(255) NULL;
+ALTER TABLE ingredient ADD COLUMN IF NOT EXISTS image_thumbnail VARCHAR(255) NULL;
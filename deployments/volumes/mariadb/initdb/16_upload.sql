@@ -0,0 +1,18 @@
+-- Direct-to-storage uploads. A presigned URL is issued for object_key, the
+-- upload is finalized once the client attached it to its target, and pending
+-- uploads are expired and deleted by a background job.
+CREATE TABLE IF NOT EXISTS upload (
+    id           INT          NOT NULL AUTO_INCREMENT,
+    user_id      INT          NOT NULL,
+    kind         VARCHAR(16)  NOT NULL,
+    object_key   VARCHAR(255) NOT NULL,
+    content_type VARCHAR(32)  NOT NULL,
+    max_bytes    INT          NOT NULL,
+    status       VARCHAR(16)  NOT NULL DEFAULT 'pending',
+    created_at   DATETIME     NOT NULL,
+    expires_at   DATETIME     NOT NULL,
+    finalized_at DATETIME     NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_upload_key (object_key),
+    KEY idx_upload_status_expires (status, expires_at)
+);
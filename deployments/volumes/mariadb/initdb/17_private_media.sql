@@ -0,0 +1,16 @@
+-- Record photos are stored privately and served through signed URLs, keep the
+-- object key instead of the public URL. The objects themselves have to be made
+-- private as well, e.g.
+--   aws s3 ls s3://pottogether/record/ --recursive | awk '{print $4}' |
+--     xargs -I{} aws s3api put-object-acl --bucket pottogether --key {} --acl private
+UPDATE record
+SET image = SUBSTRING(image, LENGTH('https://pottogether.s3.ap-southeast-2.amazonaws.com/') + 1)
+WHERE image LIKE 'https://pottogether.s3.ap-southeast-2.amazonaws.com/%';
+
+UPDATE record
+SET image_medium = SUBSTRING(image_medium, LENGTH('https://pottogether.s3.ap-southeast-2.amazonaws.com/') + 1)
+WHERE image_medium LIKE 'https://pottogether.s3.ap-southeast-2.amazonaws.com/%';
+
+UPDATE record
+SET image_thumbnail = SUBSTRING(image_thumbnail, LENGTH('https://pottogether.s3.ap-southeast-2.amazonaws.com/') + 1)
+WHERE image_thumbnail LIKE 'https://pottogether.s3.ap-southeast-2.amazonaws.com/%';
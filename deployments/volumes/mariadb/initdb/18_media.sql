@@ -0,0 +1,33 @@
+-- Every stored image object and the entity it was last attached to. Objects
+-- no longer referenced by any record or ingredient are deleted by the
+-- media_gc job after a grace period.
+CREATE TABLE IF NOT EXISTS media (
+    object_key VARCHAR(255) NOT NULL,
+    kind       VARCHAR(16)  NOT NULL,
+    owner_type VARCHAR(16)  NULL,
+    owner_id   INT          NULL,
+    size       BIGINT       NULL,
+    created_at DATETIME     NOT NULL,
+    deleted_at DATETIME     NULL,
+    PRIMARY KEY (object_key),
+    KEY idx_media_owner (owner_type, owner_id)
+);
+
+-- Backfill the images already in use, ingredient images are stored as URLs
+INSERT IGNORE INTO media (object_key, kind, owner_type, owner_id, created_at)
+SELECT k, 'record', 'record', id, NOW()
+FROM (
+    SELECT id, image AS k FROM record
+    UNION SELECT id, image_medium FROM record
+    UNION SELECT id, image_thumbnail FROM record
+) r
+WHERE k IS NOT NULL AND k NOT IN ('', 'null');
+
+INSERT IGNORE INTO media (object_key, kind, owner_type, owner_id, created_at)
+SELECT SUBSTRING(k, LENGTH('https://pottogether.s3.ap-southeast-2.amazonaws.com/') + 1), 'ingredient', 'ingredient', id, NOW()
+FROM (
+    SELECT id, image AS k FROM ingredient
+    UNION SELECT id, image_medium FROM ingredient
+    UNION SELECT id, image_thumbnail FROM ingredient
+) i
+WHERE k LIKE 'https://pottogether.s3.ap-southeast-2.amazonaws.com/%';
@@ -0,0 +1,19 @@
+-- Token buckets shared by all instances when RATE_LIMIT_BACKEND is mariadb,
+-- idle buckets are full again and are deleted by a background job.
+CREATE TABLE IF NOT EXISTS rate_limit_bucket (
+    bucket_key VARCHAR(191) NOT NULL,
+    tokens     DOUBLE       NOT NULL,
+    updated_at DATETIME(3)  NOT NULL,
+    PRIMARY KEY (bucket_key),
+    KEY idx_rate_limit_bucket_updated (updated_at)
+);
+
+-- Consecutive failed logins per account. Once the threshold is reached the
+-- account is locked for a period that doubles with each further failure.
+CREATE TABLE IF NOT EXISTS login_failure (
+    email          VARCHAR(255) NOT NULL,
+    failures       INT          NOT NULL DEFAULT 0,
+    locked_until   DATETIME     NULL,
+    last_failed_at DATETIME     NOT NULL,
+    PRIMARY KEY (email)
+);
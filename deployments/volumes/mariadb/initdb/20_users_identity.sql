@@ -0,0 +1,15 @@
+-- Accounts of users at OpenID Connect providers, identified by the subject
+-- the provider issued. Users who signed up through a provider have an empty
+-- password and cannot log in with one.
+CREATE TABLE IF NOT EXISTS users_identity (
+    id            INT          NOT NULL AUTO_INCREMENT,
+    user_id       INT          NOT NULL,
+    provider      VARCHAR(32)  NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    email         VARCHAR(255) NULL,
+    created_at    DATETIME     NOT NULL,
+    last_login_at DATETIME     NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_users_identity_subject (provider, subject),
+    KEY idx_users_identity_user (user_id)
+);
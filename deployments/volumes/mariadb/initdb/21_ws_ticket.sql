@@ -0,0 +1,10 @@
+-- Single-use tickets authenticating a websocket connection to a room, so that
+-- the JWT never appears in a URL. Only the SHA-256 of the ticket is stored.
+CREATE TABLE IF NOT EXISTS ws_ticket (
+    ticket     CHAR(64) NOT NULL,
+    user_id    INT      NOT NULL,
+    room_id    INT      NOT NULL,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (ticket),
+    KEY idx_ws_ticket_expires (expires_at)
+);
@@ -0,0 +1,9 @@
+-- IANA timezone a scheduled session repeats in, so that it keeps its local
+-- time across daylight saving changes. Existing sessions take the timezone
+-- of the member who scheduled them.
+ALTER TABLE room_event ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '';
+
+UPDATE room_event e
+INNER JOIN user u ON e.created_by = u.id
+SET e.timezone = u.timezone
+WHERE e.timezone = '';
@@ -0,0 +1,3 @@
+-- IANA timezone the weeks of a room goal start in, the one of the admin who
+-- last set it. Existing goals start their weeks in UTC until they are set again.
+ALTER TABLE room_goal ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
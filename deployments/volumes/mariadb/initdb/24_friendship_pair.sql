@@ -0,0 +1,21 @@
+-- A pair of users has a single friendship row whatever its direction, so that
+-- requests crossing each other end up in the same row.
+
+-- Requests sent both ways before the key existed mean both users want to be
+-- friends: accept the older one and drop the other, or drop the pending
+-- side of a pair that is already accepted.
+UPDATE friendship f1
+INNER JOIN friendship f2 ON f1.requester_id = f2.addressee_id AND f1.addressee_id = f2.requester_id
+SET f1.status = 'accepted', f1.responded_at = COALESCE(f1.responded_at, f2.created_at)
+WHERE f1.status = 'pending' AND f2.status = 'pending'
+AND (f1.created_at < f2.created_at OR (f1.created_at = f2.created_at AND f1.requester_id < f2.requester_id));
+
+DELETE f1 FROM friendship f1
+INNER JOIN friendship f2 ON f1.requester_id = f2.addressee_id AND f1.addressee_id = f2.requester_id
+WHERE (f1.status = 'pending' AND f2.status = 'accepted')
+OR (f1.status = f2.status AND (f1.created_at > f2.created_at OR (f1.created_at = f2.created_at AND f1.requester_id > f2.requester_id)));
+
+ALTER TABLE friendship
+    ADD COLUMN IF NOT EXISTS user_low  INT AS (LEAST(requester_id, addressee_id)) PERSISTENT,
+    ADD COLUMN IF NOT EXISTS user_high INT AS (GREATEST(requester_id, addressee_id)) PERSISTENT;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_friendship_pair ON friendship (user_low, user_high);
@@ -0,0 +1,9 @@
+-- Devices a pending push already reached, so that a retry after a failure on
+-- one device does not push again to the others. Rows are removed once the push
+-- is sent or given up.
+CREATE TABLE IF NOT EXISTS notification_delivery (
+    notification_id INT      NOT NULL,
+    device_id       INT      NOT NULL,
+    delivered_at    DATETIME NOT NULL,
+    PRIMARY KEY (notification_id, device_id)
+);
@@ -0,0 +1,3 @@
+-- Uploads being finalized are in status 'finalizing' since finalizing_at, so
+-- that the expiry job leaves them alone until the request is done with them.
+ALTER TABLE upload ADD COLUMN IF NOT EXISTS finalizing_at DATETIME NULL;
@@ -0,0 +1,10 @@
+-- Single-use nonces issued for OpenID Connect logins, the ID token must carry
+-- one so that a token cannot be replayed. Only the SHA-256 of the nonce is
+-- stored.
+CREATE TABLE IF NOT EXISTS oidc_nonce (
+    nonce      CHAR(64)    NOT NULL,
+    provider   VARCHAR(32) NOT NULL,
+    expires_at DATETIME    NOT NULL,
+    PRIMARY KEY (nonce),
+    KEY idx_oidc_nonce_expires (expires_at)
+);
@@ -0,0 +1,22 @@
+-- Events broadcast to the connections of a room. Every instance reads them so
+-- that they reach the sockets connected to any instance.
+CREATE TABLE IF NOT EXISTS realtime_event (
+    id         BIGINT       NOT NULL AUTO_INCREMENT,
+    room_id    INT          NOT NULL,
+    origin     VARCHAR(64)  NOT NULL,
+    payload    MEDIUMTEXT   NOT NULL,
+    created_at DATETIME     NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_realtime_event_created (created_at)
+);
+
+-- Users connected to a room through each instance, refreshed while the
+-- instance runs so that the rows of a stopped instance go stale
+CREATE TABLE IF NOT EXISTS realtime_presence (
+    instance VARCHAR(64) NOT NULL,
+    room_id  INT         NOT NULL,
+    user_id  INT         NOT NULL,
+    seen_at  DATETIME    NOT NULL,
+    PRIMARY KEY (room_id, user_id, instance),
+    KEY idx_realtime_presence_instance (instance)
+);
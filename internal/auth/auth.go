@@ -5,6 +5,7 @@ import (
 	"pottogether/config"
 	"pottogether/pkg/errhandler"
 	"pottogether/pkg/logger"
+	"strconv"
 	"strings"
 	"time"
 
@@ -47,12 +48,12 @@ func GenerateToken(userID int, email string) (string, error) {
 func ValidateToken(c *gin.Context) {
 	// Get token from header
 	auth := c.GetHeader("Authorization")
-	if auth == "" {
+	if !strings.HasPrefix(auth, "Bearer ") {
 		errhandler.Unauthorized(c, fmt.Errorf("no token provided"), "Error validating token")
 		c.Abort()
 		return
 	}
-	token := strings.Split(auth, "Bearer ")[1]
+	token := strings.TrimPrefix(auth, "Bearer ")
 	// Parse token
 	tokenClaims, err := jwt.ParseWithClaims(token, &authClaims{}, func(token *jwt.Token) (i interface{}, err error) {
 		return jwtSecretKey, nil
@@ -66,9 +67,24 @@ func ValidateToken(c *gin.Context) {
 	// Check if token is valid -> continue
 	if claims, ok := tokenClaims.Claims.(*authClaims); ok && tokenClaims.Valid {
 		c.Set("id", claims.UserID)
+		logger.SetUserID(c.Request.Context(), claims.UserID)
 		c.Next()
 	} else {
 		c.Abort()
 		return
 	}
 }
+
+// RequireAdmin only lets the users listed in ADMIN_USER_IDS through, it must
+// run after ValidateToken
+func RequireAdmin(c *gin.Context) {
+	id := strconv.Itoa(c.GetInt("id"))
+	for _, admin := range strings.Split(config.Viper.GetString("ADMIN_USER_IDS"), ",") {
+		if strings.TrimSpace(admin) == id {
+			c.Next()
+			return
+		}
+	}
+	errhandler.Forbidden(c, fmt.Errorf("user is not an admin"), "Error validating admin")
+	c.Abort()
+}
@@ -0,0 +1,97 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"pottogether/internal/mail"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var funcs = map[string]interface{}{
+	"duration": duration,
+	"change":   change,
+}
+
+var (
+	textTemplate = texttemplate.Must(texttemplate.New("digest.txt").Funcs(funcs).ParseFS(templates, "templates/digest.txt"))
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(funcs).ParseFS(templates, "templates/digest.html"))
+)
+
+// SendWeekly sends the digest of last week to the users for whom it is Monday
+// at the given hour. Returns the number of digests sent.
+func SendWeekly(ctx context.Context, mailer mail.Mailer, hour int) (int, error) {
+	recipients, err := query.GetDigestRecipients(ctx, hour)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, r := range recipients {
+		claimed, err := query.MarkDigestSent(ctx, r.UserID, r.WeekStart)
+		if err != nil {
+			return sent, err
+		} else if !claimed {
+			continue
+		}
+		if err := send(ctx, mailer, r); err != nil {
+			logger.WarnCtx(ctx, "[DIGEST] Error sending digest to user "+strconv.Itoa(r.UserID)+": "+err.Error())
+			if err := query.UnmarkDigestSent(ctx, r.UserID, r.WeekStart); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func send(ctx context.Context, mailer mail.Mailer, r query.DigestRecipient) error {
+	digest, err := query.GetWeeklyDigest(ctx, r.UserID, r.WeekStart)
+	if err != nil {
+		return err
+	}
+	text, html, err := Render(digest)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(digest.Email, "Your week in Pot Together", text, html)
+}
+
+// Render returns the text and HTML bodies of the digest
+func Render(digest query.Digest) (string, string, error) {
+	var text, html bytes.Buffer
+	if err := textTemplate.Execute(&text, digest); err != nil {
+		return "", "", err
+	}
+	if err := htmlTemplate.Execute(&html, digest); err != nil {
+		return "", "", err
+	}
+	return text.String(), html.String(), nil
+}
+
+// duration formats seconds as e.g. 2h 05m
+func duration(seconds int) string {
+	if seconds < 3600 {
+		return strconv.Itoa(seconds/60) + "m"
+	}
+	return fmt.Sprintf("%dh %02dm", seconds/3600, seconds%3600/60)
+}
+
+// change formats the relative change from the previous value, e.g. +25%
+func change(current int, previous int) string {
+	if previous == 0 {
+		if current == 0 {
+			return "no change"
+		}
+		return "up from nothing"
+	}
+	return fmt.Sprintf("%+d%%", (current-previous)*100/previous)
+}
@@ -0,0 +1,123 @@
+package digest
+
+import (
+	"encoding/json"
+	"pottogether/pkg/mariadb/query"
+	"strings"
+	"testing"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		seconds int
+		want    string
+	}{
+		{0, "0m"},
+		{59, "0m"},
+		{25 * 60, "25m"},
+		{3600, "1h 00m"},
+		{2*3600 + 5*60 + 30, "2h 05m"},
+	}
+	for _, tt := range tests {
+		if got := duration(tt.seconds); got != tt.want {
+			t.Errorf("duration(%d) = %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestChange(t *testing.T) {
+	tests := []struct {
+		current, previous int
+		want              string
+	}{
+		{0, 0, "no change"},
+		{60, 0, "up from nothing"},
+		{150, 100, "+50%"},
+		{50, 100, "-50%"},
+		{100, 100, "+0%"},
+	}
+	for _, tt := range tests {
+		if got := change(tt.current, tt.previous); got != tt.want {
+			t.Errorf("change(%d, %d) = %q, want %q", tt.current, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		// the digest as JSON, its nested types are not exported
+		digest   string
+		text     []string
+		html     []string
+		excluded []string
+	}{
+		{
+			name: "full week",
+			digest: `{"username": "Ann", "weekStart": "2026-10-12", "weekEnd": "2026-10-18",
+				"totalTime": 9000, "previousTotalTime": 6000, "sessionCount": 6,
+				"bestDay": {"date": "2026-10-14", "length": 3600}, "streak": 4,
+				"ingredients": [{"name": "Tomato", "totalTime": 3000, "sessionCount": 2}],
+				"rooms": [{"name": "Study", "totalTime": 4500, "previousTotalTime": 0, "rank": 2, "members": 5},
+					{"name": "Work", "totalTime": 0, "previousTotalTime": 1500, "rank": 0, "members": 3}]}`,
+			text: []string{
+				"Hi Ann,",
+				"2026-10-12 to 2026-10-18",
+				"Focus time: 2h 30m (+50% vs the week before)",
+				"Sessions: 6",
+				"Best day: 2026-10-14 with 1h 00m",
+				"Streak: 4 days",
+				"  - Tomato: 2 times, 50m",
+				"  - Study: 1h 15m (up from nothing), you ranked #2 of 5",
+				"  - Work: 0m (-100%), you did not cook here",
+			},
+			html: []string{
+				"<h2>Hi Ann,</h2>",
+				"<li>Tomato: 2 times, 50m</li>",
+				"<strong>Study</strong>",
+			},
+		},
+		{
+			name:     "quiet week",
+			digest:   `{"username": "Ann", "weekStart": "2026-10-12", "weekEnd": "2026-10-18", "streak": 0}`,
+			text:     []string{"Focus time: 0m (no change vs the week before)", "Sessions: 0"},
+			excluded: []string{"Best day", "Ingredients cooked", "Your rooms"},
+		},
+		{
+			name:   "escaped html",
+			digest: `{"username": "<b>Ann</b> & co", "rooms": [{"name": "<script>", "rank": 1, "members": 1}]}`,
+			text:   []string{"Hi <b>Ann</b> & co,", "  - <script>:"},
+			html:   []string{"Hi &lt;b&gt;Ann&lt;/b&gt; &amp; co,", "<strong>&lt;script&gt;</strong>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var digest query.Digest
+			if err := json.Unmarshal([]byte(tt.digest), &digest); err != nil {
+				t.Fatal(err)
+			}
+			text, html, err := Render(digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.text {
+				if !strings.Contains(text, want) {
+					t.Errorf("text is missing %q:\n%s", want, text)
+				}
+			}
+			for _, want := range tt.html {
+				if !strings.Contains(html, want) {
+					t.Errorf("html is missing %q:\n%s", want, html)
+				}
+			}
+			for _, unwanted := range tt.excluded {
+				if strings.Contains(text, unwanted) || strings.Contains(html, unwanted) {
+					t.Errorf("digest contains %q", unwanted)
+				}
+			}
+			if strings.Contains(html, "<script>") {
+				t.Errorf("html is not escaped:\n%s", html)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package groupsession
+
+import (
+	"context"
+	"pottogether/config"
+	"pottogether/internal/realtime"
+	"pottogether/internal/tracing"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// retryDelay is how long the runner waits after a database error
+const retryDelay = 10 * time.Second
+
+var (
+	mu      sync.Mutex
+	running = map[int]chan struct{}{}
+)
+
+// Resume starts the runners of the sessions that were scheduled or running
+// when the server stopped
+func Resume(ctx context.Context) error {
+	ids, err := query.GetActiveSessionIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		Start(id)
+	}
+	logger.Info("[SESSION] Resumed " + strconv.Itoa(len(ids)) + " group sessions")
+	return nil
+}
+
+// Start drives the session in the background until it ends or is stopped
+func Start(sessionID int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := running[sessionID]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	running[sessionID] = stop
+	go run(sessionID, stop)
+}
+
+// Stop stops driving the session, used once it is cancelled
+func Stop(sessionID int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if stop, ok := running[sessionID]; ok {
+		close(stop)
+		delete(running, sessionID)
+	}
+}
+
+// Broadcast sends the current state of the session to the room
+func Broadcast(ctx context.Context, session query.GroupSession) {
+	realtime.Broadcast(ctx, session.RoomID, "session", map[string]interface{}{
+		"session":    session,
+		"serverTime": time.Now().Unix(),
+	})
+}
+
+// run is a state machine over the session row, so that a restarted server
+// picks up where it stopped
+func run(sessionID int, stop chan struct{}) {
+	defer Stop(sessionID)
+	ctx := context.Background()
+	for {
+		session, err := query.GetSession(ctx, sessionID)
+		if err != nil {
+			logger.Error("[SESSION] Error getting session " + strconv.Itoa(sessionID) + ": " + err.Error())
+			if !wait(retryDelay, stop) {
+				return
+			}
+			continue
+		}
+		switch session.Status {
+		case "scheduled":
+			if !wait(time.Until(time.Unix(int64(session.StartsAt), 0)), stop) {
+				return
+			}
+			err = step(ctx, session, func(ctx context.Context) error {
+				return query.StartFocusPhase(ctx, session, 0)
+			})
+		case "running":
+			if !wait(time.Until(time.Unix(int64(session.PhaseEndsAt), 0)), stop) {
+				return
+			}
+			err = step(ctx, session, func(ctx context.Context) error {
+				return advance(ctx, session)
+			})
+		default:
+			return
+		}
+		if err != nil {
+			logger.Error("[SESSION] Error advancing session " + strconv.Itoa(sessionID) + ": " + err.Error())
+			if !wait(retryDelay, stop) {
+				return
+			}
+			continue
+		}
+		if session, err = query.GetSession(ctx, sessionID); err == nil {
+			Broadcast(ctx, session)
+		}
+	}
+}
+
+// step runs a phase change of the session as a trace of its own
+func step(ctx context.Context, session query.GroupSession, fn func(ctx context.Context) error) error {
+	ctx, span := tracing.Start(ctx, "groupsession.step",
+		attribute.Int("session.id", session.ID), attribute.String("session.status", session.Status), attribute.String("session.phase", session.Phase))
+	err := fn(ctx)
+	tracing.End(span, err)
+	return err
+}
+
+// advance moves a running session past the phase that just ended
+func advance(ctx context.Context, session query.GroupSession) error {
+	bonus := config.Viper.GetInt("GROUP_SESSION_BONUS_PERCENT")
+	if session.Phase == "focus" {
+		if session.Cycle >= session.Cycles {
+			return query.EndSession(ctx, session, bonus)
+		}
+		if session.BreakLength > 0 {
+			return query.StartBreakPhase(ctx, session, bonus)
+		}
+	}
+	return query.StartFocusPhase(ctx, session, bonus)
+}
+
+// wait sleeps for d, returning false if the session was stopped meanwhile
+func wait(d time.Duration, stop chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
@@ -1,13 +1,89 @@
 package hash
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"fmt"
+	"pottogether/config"
+)
+
+// ErrMismatch is returned when a password does not match its hash
+var ErrMismatch = fmt.Errorf("password does not match")
+
+// Hasher is a password hashing algorithm. Encoded hashes start with the
+// algorithm prefix and carry their parameters, so hashes made with older
+// parameters or algorithms can still be verified.
+type Hasher interface {
+	// Hash hashes the password with the configured parameters
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash
+	Verify(password string, encoded string) (bool, error)
+	// Owns reports whether the encoded hash was made by this algorithm
+	Owns(encoded string) bool
+	// Outdated reports whether the encoded hash was made with other parameters
+	Outdated(encoded string) bool
+}
+
+// hashers returns the supported algorithms with their configured parameters
+func hashers() map[string]Hasher {
+	return map[string]Hasher{
+		"argon2id": Argon2id{
+			Memory:      config.Viper.GetUint32("ARGON2_MEMORY_KB"),
+			Iterations:  config.Viper.GetUint32("ARGON2_ITERATIONS"),
+			Parallelism: uint8(config.Viper.GetUint("ARGON2_PARALLELISM")),
+		},
+		"bcrypt": Bcrypt{Cost: config.Viper.GetInt("BCRYPT_COST")},
+	}
+}
+
+// current returns the algorithm new hashes are made with
+func current() (Hasher, error) {
+	algorithm := config.Viper.GetString("PASSWORD_HASH_ALGORITHM")
+	if h, ok := hashers()[algorithm]; ok {
+		return h, nil
+	}
+	return nil, fmt.Errorf("unknown password hash algorithm %s", algorithm)
+}
+
+// owner returns the algorithm the encoded hash was made by
+func owner(encoded string) (Hasher, error) {
+	for _, h := range hashers() {
+		if h.Owns(encoded) {
+			return h, nil
+		}
+	}
+	return nil, ErrMismatch
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	h, err := current()
+	if err != nil {
+		return "", err
+	}
+	return h.Hash(password)
 }
 
+// CheckPasswordHash returns ErrMismatch unless the password matches the hash,
+// whatever algorithm it was made by. Users without password have an empty
+// hash, which nothing matches.
 func CheckPasswordHash(password, hash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err
+	h, err := owner(hash)
+	if err != nil {
+		return err
+	}
+	ok, err := h.Verify(password, hash)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm or
+// other parameters than the configured ones
+func NeedsRehash(hash string) bool {
+	h, err := current()
+	if err != nil {
+		return false
+	}
+	return !h.Owns(hash) || h.Outdated(hash)
 }
@@ -0,0 +1,157 @@
+package hash
+
+import (
+	"os"
+	"path/filepath"
+	"pottogether/config"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// useConfig sets the configuration the package reads, with cheap hashing
+// parameters so that the tests run fast
+func useConfig(t *testing.T, values map[string]interface{}) {
+	t.Helper()
+	vp := viper.New()
+	vp.Set("PASSWORD_HASH_ALGORITHM", "argon2id")
+	vp.Set("ARGON2_MEMORY_KB", 64)
+	vp.Set("ARGON2_ITERATIONS", 1)
+	vp.Set("ARGON2_PARALLELISM", 1)
+	vp.Set("BCRYPT_COST", 4)
+	vp.Set("PASSWORD_MIN_LENGTH", 8)
+	vp.Set("PASSWORD_MAX_LENGTH", 128)
+	for key, value := range values {
+		vp.Set(key, value)
+	}
+	previous := config.Viper
+	config.Viper = vp
+	t.Cleanup(func() { config.Viper = previous })
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	useConfig(t, nil)
+	argon := Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+	argonHash, err := argon.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := Bcrypt{Cost: 4}.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		wantErr  error
+	}{
+		{"argon2id match", "correct horse", argonHash, nil},
+		{"argon2id mismatch", "wrong horse", argonHash, ErrMismatch},
+		{"bcrypt match", "correct horse", bcryptHash, nil},
+		{"bcrypt mismatch", "wrong horse", bcryptHash, ErrMismatch},
+		{"no password", "", "", ErrMismatch},
+		{"unknown algorithm", "correct horse", "$md5$abc", ErrMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckPasswordHash(tt.password, tt.hash); err != tt.wantErr {
+				t.Errorf("CheckPasswordHash() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestArgon2idDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{"valid", "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA", false},
+		{"other version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA", true},
+		{"bad parameters", "$argon2id$v=19$m=x,t=1,p=1$c2FsdA$aGFzaA", true},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!$aGFzaA", true},
+		{"empty key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$", true},
+		{"missing part", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := decodeArgon2id(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeArgon2id() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	useConfig(t, nil)
+	current, _ := Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}.Hash("password")
+	weaker, _ := Argon2id{Memory: 32, Iterations: 1, Parallelism: 1}.Hash("password")
+	bcryptHash, _ := Bcrypt{Cost: 4}.Hash("password")
+	tests := []struct {
+		name      string
+		algorithm string
+		hash      string
+		want      bool
+	}{
+		{"same parameters", "argon2id", current, false},
+		{"other parameters", "argon2id", weaker, true},
+		{"other algorithm", "argon2id", bcryptHash, true},
+		{"bcrypt same cost", "bcrypt", bcryptHash, false},
+		{"bcrypt from argon2id", "bcrypt", current, true},
+		{"unknown configured algorithm", "scrypt", bcryptHash, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Viper.Set("PASSWORD_HASH_ALGORITHM", tt.algorithm)
+			if got := NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		password  string
+		wantErr   string
+	}{
+		{"ok", "argon2id", "long enough", ""},
+		{"too short", "argon2id", "short", "password must be at least 8 characters"},
+		{"counted in characters", "argon2id", "ééééééé", "password must be at least 8 characters"},
+		{"too long", "argon2id", strings.Repeat("a", 129), "password must be at most 128 characters"},
+		{"over bcrypt limit", "bcrypt", strings.Repeat("é", 40), "password must be at most 72 bytes"},
+		{"over bcrypt limit with argon2id", "argon2id", strings.Repeat("é", 40), ""},
+		{"breached plain", "argon2id", "password123", "password appears in a data breach, choose another one"},
+		{"breached sha-1", "argon2id", "qwertyuiop", "password appears in a data breach, choose another one"},
+	}
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	// qwertyuiop by SHA-1 as in the Pwned Passwords downloads
+	content := "password123\r\n\n" + sha1Hex("qwertyuiop") + ":42\n"
+	if err := os.WriteFile(list, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, map[string]interface{}{"PASSWORD_BREACHED_LIST": list})
+	previous := breached
+	t.Cleanup(func() { breached = previous })
+	if err := LoadBreachedList(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Viper.Set("PASSWORD_HASH_ALGORITHM", tt.algorithm)
+			got := ""
+			if err := CheckPolicy(tt.password); err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("CheckPolicy() = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package hash
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"pottogether/config"
+	"strings"
+	"unicode/utf8"
+)
+
+// breached holds the upper case SHA-1 of the passwords known from breaches
+var breached = map[string]struct{}{}
+
+// LoadBreachedList reads PASSWORD_BREACHED_LIST, one password per line, either
+// plain or as SHA-1 in the format of the Pwned Passwords downloads
+// (<HASH>:<count>). Without a list passwords are only checked for length.
+func LoadBreachedList() error {
+	path := config.Viper.GetString("PASSWORD_BREACHED_LIST")
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	list := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if sum, _, _ := strings.Cut(line, ":"); len(sum) == 2*sha1.Size && isHex(sum) {
+			list[strings.ToUpper(sum)] = struct{}{}
+		} else {
+			list[sha1Hex(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	breached = list
+	return nil
+}
+
+// CheckPolicy returns why the password cannot be used, nil if it can
+func CheckPolicy(password string) error {
+	minLength := config.Viper.GetInt("PASSWORD_MIN_LENGTH")
+	maxLength := config.Viper.GetInt("PASSWORD_MAX_LENGTH")
+	if length := utf8.RuneCountInString(password); length < minLength {
+		return fmt.Errorf("password must be at least %d characters", minLength)
+	} else if length > maxLength {
+		return fmt.Errorf("password must be at most %d characters", maxLength)
+	}
+	// bcrypt would ignore the rest of the password
+	if h, err := current(); err == nil {
+		if _, ok := h.(Bcrypt); ok && len(password) > 72 {
+			return fmt.Errorf("password must be at most 72 bytes")
+		}
+	}
+	if _, ok := breached[sha1Hex(password)]; ok {
+		return fmt.Errorf("password appears in a data breach, choose another one")
+	}
+	return nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,270 @@
+package ical
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	timeLayout  = "20060102T150405Z"
+	localLayout = "20060102T150405"
+	lineLimit   = 75
+	// zoneYears is how far past now the VTIMEZONE transitions are listed
+	zoneYears = 10
+)
+
+// Event is a single VEVENT, repeated by RRule when it is set. Events with a
+// Timezone start at the same local time on every occurrence, across daylight
+// saving changes; others are pinned to UTC.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	Duration    time.Duration
+	RRule       string
+	Status      string
+	Timezone    string
+}
+
+// ValidateRRule checks the subset of RFC 5545 recurrence rules we support:
+// FREQ (DAILY, WEEKLY or MONTHLY) with optional INTERVAL, COUNT, UNTIL and BYDAY
+func ValidateRRule(rule string) error {
+	if rule == "" {
+		return nil
+	}
+	parts := map[string]string{}
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return fmt.Errorf("invalid rrule part %q", part)
+		}
+		if _, ok := parts[kv[0]]; ok {
+			return fmt.Errorf("duplicate rrule part %q", kv[0])
+		}
+		parts[kv[0]] = kv[1]
+	}
+	for key, value := range parts {
+		switch key {
+		case "FREQ":
+			if value != "DAILY" && value != "WEEKLY" && value != "MONTHLY" {
+				return fmt.Errorf("unsupported rrule frequency %q", value)
+			}
+		case "INTERVAL", "COUNT":
+			if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+				return fmt.Errorf("invalid rrule %s %q", key, value)
+			}
+		case "UNTIL":
+			if _, err := time.Parse(timeLayout, value); err != nil {
+				return fmt.Errorf("invalid rrule UNTIL %q", value)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				switch day {
+				case "MO", "TU", "WE", "TH", "FR", "SA", "SU":
+				default:
+					return fmt.Errorf("invalid rrule BYDAY %q", day)
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported rrule part %q", key)
+		}
+	}
+	if _, ok := parts["FREQ"]; !ok {
+		return fmt.Errorf("rrule is missing FREQ")
+	}
+	if _, ok := parts["COUNT"]; ok {
+		if _, ok := parts["UNTIL"]; ok {
+			return fmt.Errorf("rrule cannot have both COUNT and UNTIL")
+		}
+	}
+	return nil
+}
+
+// Write renders the events as an iCalendar document
+func Write(w io.Writer, name string, events []Event) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Pot Together//Schedule//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + escape(name),
+	}
+	// every timezone referenced by a TZID needs its VTIMEZONE, covering the
+	// first event in it
+	zones := map[string]*time.Location{}
+	firstStart := map[string]time.Time{}
+	for _, event := range events {
+		loc := location(event.Timezone)
+		if loc == nil {
+			continue
+		}
+		if first, ok := firstStart[event.Timezone]; !ok || event.Start.Before(first) {
+			firstStart[event.Timezone] = event.Start
+		}
+		zones[event.Timezone] = loc
+	}
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, vtimezone(name, zones[name], firstStart[name], time.Now().AddDate(zoneYears, 0, 0))...)
+	}
+	stamp := time.Now().UTC().Format(timeLayout)
+	for _, event := range events {
+		start := "DTSTART:" + event.Start.UTC().Format(timeLayout)
+		if loc := zones[event.Timezone]; loc != nil {
+			start = "DTSTART;TZID=" + event.Timezone + ":" + event.Start.In(loc).Format(localLayout)
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+event.UID,
+			"DTSTAMP:"+stamp,
+			start,
+			"DURATION:"+duration(event.Duration),
+			"SUMMARY:"+escape(event.Summary),
+		)
+		if event.Description != "" {
+			lines = append(lines, "DESCRIPTION:"+escape(event.Description))
+		}
+		if event.RRule != "" {
+			lines = append(lines, "RRULE:"+event.RRule)
+		}
+		if event.Status != "" {
+			lines = append(lines, "STATUS:"+event.Status)
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+	for _, line := range lines {
+		if _, err := io.WriteString(w, fold(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// location loads an IANA timezone, nil when it is empty, UTC or unknown, in
+// which case times are written in UTC
+func location(name string) *time.Location {
+	if name == "" || name == "UTC" || name == "Local" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// vtimezone describes the offsets of loc from the start of from's year until
+// to. Every transition is listed as its own observance, since Go does not
+// expose the rules behind them.
+func vtimezone(name string, loc *time.Location, from time.Time, to time.Time) []string {
+	start := time.Date(from.In(loc).Year(), time.January, 1, 0, 0, 0, 0, loc)
+	abbr, offset := start.Zone()
+	lines := []string{
+		"BEGIN:VTIMEZONE",
+		"TZID:" + name,
+	}
+	lines = append(lines, observance(start, start.IsDST(), abbr, offset, offset)...)
+	// offsets change at most a few times a year and never twice in a day,
+	// so the transitions are found day by day, then to the second
+	prev := start
+	for day := start.AddDate(0, 0, 1); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if _, o := day.Zone(); o == offset {
+			prev = day
+			continue
+		}
+		lo, hi := prev.Unix(), day.Unix()
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if _, o := time.Unix(mid, 0).In(loc).Zone(); o == offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		transition := time.Unix(hi, 0).In(loc)
+		newAbbr, newOffset := transition.Zone()
+		// DTSTART is the local time of the transition before it happens
+		lines = append(lines, observance(transition.In(time.FixedZone("", offset)), transition.IsDST(), newAbbr, offset, newOffset)...)
+		offset = newOffset
+		prev = day
+	}
+	return append(lines, "END:VTIMEZONE")
+}
+
+func observance(start time.Time, dst bool, abbr string, from int, to int) []string {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	return []string{
+		"BEGIN:" + kind,
+		"DTSTART:" + start.Format(localLayout),
+		"TZOFFSETFROM:" + utcOffset(from),
+		"TZOFFSETTO:" + utcOffset(to),
+		"TZNAME:" + abbr,
+		"END:" + kind,
+	}
+}
+
+// utcOffset formats an offset in seconds east of UTC, e.g. +0930
+func utcOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
+
+// escape escapes TEXT values
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// duration formats d as an RFC 5545 duration, e.g. PT1H30M
+func duration(d time.Duration) string {
+	s := "PT"
+	if h := int(d.Hours()); h > 0 {
+		s += strconv.Itoa(h) + "H"
+	}
+	if m := int(d.Minutes()) % 60; m > 0 || s == "PT" {
+		s += strconv.Itoa(m) + "M"
+	}
+	return s
+}
+
+// fold splits content lines longer than 75 octets and terminates them with CRLF,
+// taking care not to split UTF-8 sequences
+func fold(line string) string {
+	var b strings.Builder
+	limit := lineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// continuation lines start with a space
+		limit = lineLimit - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	return b.String()
+}
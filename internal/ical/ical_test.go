@@ -0,0 +1,202 @@
+package ical
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+func TestValidateRRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{"", false},
+		{"FREQ=DAILY", false},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE,FR", false},
+		{"FREQ=MONTHLY;COUNT=6", false},
+		{"FREQ=WEEKLY;UNTIL=20261231T000000Z", false},
+		{"FREQ=YEARLY", true},
+		{"INTERVAL=2", true},
+		{"FREQ=DAILY;INTERVAL=0", true},
+		{"FREQ=DAILY;COUNT=x", true},
+		{"FREQ=DAILY;UNTIL=2026-12-31", true},
+		{"FREQ=WEEKLY;BYDAY=MO,XX", true},
+		{"FREQ=DAILY;COUNT=3;UNTIL=20261231T000000Z", true},
+		{"FREQ=DAILY;FREQ=WEEKLY", true},
+		{"FREQ=DAILY;BYHOUR=9", true},
+		{"FREQ=DAILY;", true},
+		{"FREQ=", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateRRule(tt.rule); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"duration hours and minutes", duration(90 * time.Minute), "PT1H30M"},
+		{"duration hours", duration(2 * time.Hour), "PT2H"},
+		{"duration zero", duration(0), "PT0M"},
+		{"offset east", utcOffset(9*3600 + 30*60), "+0930"},
+		{"offset west", utcOffset(-5 * 3600), "-0500"},
+		{"offset seconds", utcOffset(-(4*3600 + 56*60 + 2)), "-045602"},
+		{"escape", escape("a,b;c\\d\ne\r\nf"), `a\,b\;c\\d\ne\nf`},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Focus"},
+		{"exactly the limit", "SUMMARY:" + strings.Repeat("a", lineLimit-8)},
+		{"long ascii", "DESCRIPTION:" + strings.Repeat("abcdefghij", 20)},
+		{"long multibyte", "SUMMARY:" + strings.Repeat("番茄", 40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folded := fold(tt.line)
+			if !strings.HasSuffix(folded, "\r\n") {
+				t.Fatalf("fold() = %q, not terminated by CRLF", folded)
+			}
+			lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n")
+			for i, line := range lines {
+				if len(line) > lineLimit {
+					t.Errorf("line %d is %d octets long", i, len(line))
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a UTF-8 sequence", i)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d does not start with a space", i)
+				}
+			}
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", ""); unfolded != tt.line {
+				t.Errorf("unfolded line = %q, want %q", unfolded, tt.line)
+			}
+		})
+	}
+}
+
+func TestVTimezone(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		from time.Time
+		to   time.Time
+		want []string
+	}{
+		{
+			name: "southern hemisphere",
+			zone: "Australia/Sydney",
+			from: time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC),
+			want: []string{
+				"BEGIN:VTIMEZONE", "TZID:Australia/Sydney",
+				"BEGIN:DAYLIGHT", "DTSTART:20260101T000000", "TZOFFSETFROM:+1100", "TZOFFSETTO:+1100", "TZNAME:AEDT", "END:DAYLIGHT",
+				"BEGIN:STANDARD", "DTSTART:20260405T030000", "TZOFFSETFROM:+1100", "TZOFFSETTO:+1000", "TZNAME:AEST", "END:STANDARD",
+				"BEGIN:DAYLIGHT", "DTSTART:20261004T020000", "TZOFFSETFROM:+1000", "TZOFFSETTO:+1100", "TZNAME:AEDT", "END:DAYLIGHT",
+				"END:VTIMEZONE",
+			},
+		},
+		{
+			name: "northern hemisphere",
+			zone: "Europe/Berlin",
+			from: time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2026, time.December, 31, 0, 0, 0, 0, time.UTC),
+			want: []string{
+				"BEGIN:VTIMEZONE", "TZID:Europe/Berlin",
+				"BEGIN:STANDARD", "DTSTART:20260101T000000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD",
+				"BEGIN:DAYLIGHT", "DTSTART:20260329T020000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0200", "TZNAME:CEST", "END:DAYLIGHT",
+				"BEGIN:STANDARD", "DTSTART:20261025T030000", "TZOFFSETFROM:+0200", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD",
+				"END:VTIMEZONE",
+			},
+		},
+		{
+			name: "no daylight saving",
+			zone: "Asia/Tokyo",
+			from: time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2030, time.June, 1, 0, 0, 0, 0, time.UTC),
+			want: []string{
+				"BEGIN:VTIMEZONE", "TZID:Asia/Tokyo",
+				"BEGIN:STANDARD", "DTSTART:20260101T000000", "TZOFFSETFROM:+0900", "TZOFFSETTO:+0900", "TZNAME:JST", "END:STANDARD",
+				"END:VTIMEZONE",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := vtimezone(tt.zone, loc, tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vtimezone() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	// 7pm in Sydney during daylight saving
+	start := time.Date(2026, time.January, 5, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		event    Event
+		want     []string
+		wantZone bool
+	}{
+		{
+			name:     "in a timezone",
+			event:    Event{UID: "1@pottogether", Summary: "Focus, together", Start: start, Duration: time.Hour, RRule: "FREQ=WEEKLY", Timezone: "Australia/Sydney"},
+			want:     []string{"DTSTART;TZID=Australia/Sydney:20260105T190000", "DURATION:PT1H", "SUMMARY:Focus\\, together", "RRULE:FREQ=WEEKLY"},
+			wantZone: true,
+		},
+		{
+			name:  "in UTC",
+			event: Event{UID: "2@pottogether", Summary: "Focus", Start: start, Duration: 25 * time.Minute, Status: "CANCELLED"},
+			want:  []string{"DTSTART:20260105T080000Z", "DURATION:PT25M", "STATUS:CANCELLED"},
+		},
+		{
+			name:  "unknown timezone",
+			event: Event{UID: "3@pottogether", Summary: "Focus", Start: start, Duration: time.Hour, Timezone: "Mars/Olympus"},
+			want:  []string{"DTSTART:20260105T080000Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := Write(&b, "Room", []Event{tt.event}); err != nil {
+				t.Fatal(err)
+			}
+			out := b.String()
+			if !strings.HasPrefix(out, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(out, "END:VCALENDAR\r\n") {
+				t.Errorf("Write() is not a calendar:\n%s", out)
+			}
+			for _, line := range tt.want {
+				if !strings.Contains(out, "\r\n"+line+"\r\n") {
+					t.Errorf("Write() is missing %q:\n%s", line, out)
+				}
+			}
+			if got := strings.Contains(out, "BEGIN:VTIMEZONE"); got != tt.wantZone {
+				t.Errorf("Write() has VTIMEZONE = %v, want %v", got, tt.wantZone)
+			}
+		})
+	}
+}
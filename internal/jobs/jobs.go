@@ -0,0 +1,195 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pottogether/internal/tracing"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// pollInterval is how often the scheduler checks for due jobs
+	pollInterval = 30 * time.Second
+	// leaseDuration is the lease taken on a job, renewed while it runs. Another
+	// replica may start the job once a runner stopped renewing it.
+	leaseDuration = 2 * time.Minute
+)
+
+// Job is a periodic task. Run returns a short summary stored in the run history.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Timeout is the longest a run may take, the context of Run is cancelled
+	// then
+	Timeout time.Duration
+	Run     func(ctx context.Context) (string, error)
+}
+
+var (
+	mu     sync.Mutex
+	jobs   = map[string]Job{}
+	runner = hostname() + ":" + strconv.Itoa(os.Getpid())
+)
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// Register adds a job to the scheduler, it must be called before Start
+func Register(job Job) {
+	mu.Lock()
+	defer mu.Unlock()
+	jobs[job.Name] = job
+}
+
+// Names returns the names of the registered jobs
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start registers the jobs in the database and runs them in the background
+// whenever they are due. Every replica runs a scheduler, the lease in the job
+// row makes sure only one of them runs each job.
+func Start() error {
+	ctx := context.Background()
+	for _, name := range Names() {
+		if err := query.RegisterJob(ctx, name); err != nil {
+			return err
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			for _, name := range Names() {
+				mu.Lock()
+				job := jobs[name]
+				mu.Unlock()
+				claimed, err := query.ClaimJob(ctx, job.Name, runner, int(leaseDuration.Seconds()), false)
+				if err != nil {
+					logger.Error("[JOB] Error claiming job " + job.Name + ": " + err.Error())
+					continue
+				}
+				if !claimed {
+					continue
+				}
+				runID, err := query.StartJobRun(ctx, job.Name, "schedule", runner)
+				if err != nil {
+					logger.Error("[JOB] Error starting job " + job.Name + ": " + err.Error())
+					release(ctx, job)
+					continue
+				}
+				go execute(job, runID)
+			}
+			<-ticker.C
+		}
+	}()
+	logger.Info("[JOB] Scheduler started as " + runner)
+	return nil
+}
+
+// Trigger runs the job right away, regardless of its schedule. Returns the id
+// of the run, which can be followed in the run history.
+func Trigger(ctx context.Context, name string) (int, error) {
+	mu.Lock()
+	job, ok := jobs[name]
+	mu.Unlock()
+	if !ok {
+		return -1, fmt.Errorf("job does not exist")
+	}
+	claimed, err := query.ClaimJob(ctx, job.Name, runner, int(leaseDuration.Seconds()), true)
+	if err != nil {
+		return -1, err
+	} else if !claimed {
+		return -1, fmt.Errorf("job is already running")
+	}
+	runID, err := query.StartJobRun(ctx, job.Name, "manual", runner)
+	if err != nil {
+		release(ctx, job)
+		return -1, err
+	}
+	go execute(job, runID)
+	return runID, nil
+}
+
+// execute runs the job, stores the outcome and gives the lease back. Each run
+// is a trace of its own.
+func execute(job Job, runID int) {
+	ctx, span := tracing.Start(context.Background(), "job "+job.Name, attribute.Int("job.run_id", runID))
+	defer release(ctx, job)
+	start := time.Now()
+	runCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	go renew(runCtx, job, cancel)
+	result, err := run(runCtx, job)
+	cancel()
+	tracing.End(span, err)
+	if err != nil {
+		logger.Error("[JOB] " + job.Name + " failed after " + time.Since(start).String() + ": " + err.Error())
+		result = err.Error()
+	} else {
+		logger.Info("[JOB] " + job.Name + " finished in " + time.Since(start).String() + ": " + result)
+	}
+	if err := query.FinishJobRun(ctx, runID, err == nil, result); err != nil {
+		logger.Error("[JOB] Error saving run of " + job.Name + ": " + err.Error())
+	}
+}
+
+// renew extends the lease of the job until its run is over. The run is
+// cancelled if the lease was lost, e.g. because the database could not be
+// reached for longer than the lease and another replica took the job.
+func renew(ctx context.Context, job Job, cancel context.CancelFunc) {
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		held, err := query.RenewJob(ctx, job.Name, runner, int(leaseDuration.Seconds()))
+		if err != nil {
+			logger.Warn("[JOB] Error renewing lease of " + job.Name + ": " + err.Error())
+			continue
+		}
+		if !held {
+			logger.Error("[JOB] Lost lease of " + job.Name + ", cancelling the run")
+			cancel()
+			return
+		}
+	}
+}
+
+// run calls the job, turning a panic into an error so the lease is released
+func run(ctx context.Context, job Job) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+func release(ctx context.Context, job Job) {
+	if err := query.ReleaseJob(ctx, job.Name, runner, int(job.Interval.Seconds())); err != nil {
+		logger.Error("[JOB] Error releasing job " + job.Name + ": " + err.Error())
+	}
+}
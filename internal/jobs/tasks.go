@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"pottogether/config"
+	"pottogether/internal/digest"
+	"pottogether/internal/mail"
+	"pottogether/internal/s3"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"time"
+)
+
+// RegisterDefaults registers the periodic tasks of the service
+func RegisterDefaults() {
+	// runs every quarter so that each timezone is caught at the reminder
+	// hour, reminders are sent at most once a day
+	Register(Job{
+		Name:     "streak_reminders",
+		Interval: 15 * time.Minute,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			sent, err := query.SendStreakReminders(ctx, config.Viper.GetInt("STREAK_REMINDER_HOUR"))
+			return strconv.Itoa(sent) + " reminders sent", err
+		},
+	})
+	Register(Job{
+		Name:     "focus_daily_rollup",
+		Interval: 6 * time.Hour,
+		Timeout:  30 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			users, err := query.RebuildFocusDaily(ctx, 2)
+			return strconv.Itoa(users) + " users rebuilt", err
+		},
+	})
+	Register(Job{
+		Name:     "abandon_stale_records",
+		Interval: 5 * time.Minute,
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			abandoned, err := query.AbandonStaleRecords(ctx)
+			return strconv.Itoa(abandoned) + " records abandoned", err
+		},
+	})
+	// runs every quarter so that each timezone is caught on Monday morning
+	Register(Job{
+		Name:     "weekly_digest",
+		Interval: 15 * time.Minute,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			sent, err := digest.SendWeekly(ctx, mail.Default, config.Viper.GetInt("DIGEST_HOUR"))
+			return strconv.Itoa(sent) + " digests sent", err
+		},
+	})
+	Register(Job{
+		Name:     "expire_uploads",
+		Interval: 15 * time.Minute,
+		Timeout:  10 * time.Minute,
+		Run:      expireUploads,
+	})
+	Register(Job{
+		Name:     "media_gc",
+		Interval: 24 * time.Hour,
+		Timeout:  time.Hour,
+		Run: func(ctx context.Context) (string, error) {
+			grace := time.Duration(config.Viper.GetInt("MEDIA_GC_GRACE_HOURS")) * time.Hour
+			report, err := s3.CollectOrphans(ctx, grace, config.Viper.GetBool("MEDIA_GC_DRY_RUN"))
+			result := fmt.Sprintf("%d objects scanned, %d orphans (%d bytes), %d deleted",
+				report.Scanned, len(report.Orphans), report.OrphanBytes, report.Deleted)
+			if report.DryRun {
+				result += ", dry run"
+			}
+			return result, err
+		},
+	})
+	Register(Job{
+		Name:     "expire_ws_tickets",
+		Interval: time.Hour,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			deleted, err := query.DeleteExpiredWSTickets(ctx)
+			return strconv.Itoa(deleted) + " tickets deleted", err
+		},
+	})
+	Register(Job{
+		Name:     "expire_realtime_events",
+		Interval: time.Hour,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			// every instance reads events within a second, an hour is plenty
+			events, err := query.DeleteOldRealtimeEvents(ctx, 3600)
+			if err != nil {
+				return "", err
+			}
+			presence, err := query.DeleteStalePresence(ctx, 3600)
+			return strconv.Itoa(events) + " events and " + strconv.Itoa(presence) + " presence rows deleted", err
+		},
+	})
+	Register(Job{
+		Name:     "expire_oidc_nonces",
+		Interval: time.Hour,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			deleted, err := query.DeleteExpiredOIDCNonces(ctx)
+			return strconv.Itoa(deleted) + " nonces deleted", err
+		},
+	})
+	Register(Job{
+		Name:     "expire_rate_limits",
+		Interval: time.Hour,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			buckets, err := query.DeleteIdleBuckets(ctx, 60*60)
+			if err != nil {
+				return "", err
+			}
+			failures, err := query.DeleteStaleLoginFailures(ctx, config.Viper.GetInt("LOGIN_LOCKOUT_MAX_SECONDS"))
+			return fmt.Sprintf("%d buckets, %d login failures deleted", buckets, failures), err
+		},
+	})
+}
+
+// expireUploads deletes the objects of uploads that were never finalized,
+// leaving alone the ones a request is finalizing
+func expireUploads(ctx context.Context) (string, error) {
+	expired := 0
+	for {
+		// an upload claimed for an hour was left behind by a request that died
+		uploads, err := query.GetExpiredUploads(ctx, 100, 3600)
+		if err != nil {
+			return strconv.Itoa(expired) + " uploads expired", err
+		}
+		if len(uploads) == 0 {
+			return strconv.Itoa(expired) + " uploads expired", nil
+		}
+		for _, upload := range uploads {
+			if err := s3.DeleteObject(ctx, upload.ObjectKey); err != nil {
+				return strconv.Itoa(expired) + " uploads expired", err
+			}
+			if err := query.ExpireUpload(ctx, upload.ID); err != nil {
+				return strconv.Itoa(expired) + " uploads expired", err
+			}
+			expired++
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"pottogether/config"
+	"pottogether/pkg/logger"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailer sends an email with a plain text and an HTML alternative
+type Mailer interface {
+	Send(to string, subject string, text string, html string) error
+}
+
+// Default is the mailer set up by Init
+var Default Mailer
+
+// Init uses SMTP when SMTP_HOST is set, otherwise emails are written to
+// MAIL_DIR for development
+func Init() {
+	from := config.Viper.GetString("MAIL_FROM")
+	if host := config.Viper.GetString("SMTP_HOST"); host != "" {
+		Default = SMTPMailer{
+			Host:     host,
+			Port:     config.Viper.GetInt("SMTP_PORT"),
+			Username: config.Viper.GetString("SMTP_USERNAME"),
+			Password: config.Viper.GetString("SMTP_PASSWORD"),
+			From:     from,
+		}
+		logger.Info("[MAIL] Sending emails through " + host)
+		return
+	}
+	Default = FileMailer{Dir: config.Viper.GetString("MAIL_DIR"), From: from}
+	logger.Info("[MAIL] Writing emails to " + config.Viper.GetString("MAIL_DIR"))
+}
+
+// SMTPMailer sends emails through an SMTP server, using STARTTLS when the
+// server offers it
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(to string, subject string, text string, html string) error {
+	msg, err := buildMessage(m.From, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, m.From, []string{to}, msg)
+}
+
+// FileMailer writes every email as an .eml file in Dir
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m FileMailer) Send(to string, subject string, text string, html string) error {
+	msg, err := buildMessage(m.From, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(to))
+	return os.WriteFile(filepath.Join(m.Dir, name), msg, 0644)
+}
+
+// buildMessage renders a multipart/alternative MIME message
+func buildMessage(from string, to string, subject string, text string, html string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, h := range headers {
+		msg.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
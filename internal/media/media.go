@@ -0,0 +1,134 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maxPixels guards against decompression bombs
+	maxPixels   = 50 * 1000 * 1000
+	jpegQuality = 85
+)
+
+// Variant sizes, the longest side is scaled down to fit
+var sizes = []struct {
+	name string
+	max  int
+}{
+	{"full", 2048},
+	{"medium", 1024},
+	{"thumbnail", 320},
+}
+
+// InvalidImageError is returned for uploads that are not acceptable images
+type InvalidImageError struct {
+	msg string
+}
+
+func (e InvalidImageError) Error() string {
+	return e.msg
+}
+
+// Variant is an encoded rendition of the uploaded image
+type Variant struct {
+	Name        string
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// Processed holds the variants of an image, keyed by the hash of the upload
+type Processed struct {
+	Hash     string
+	Variants []Variant
+}
+
+// Process validates an uploaded image and renders its variants. Re-encoding
+// drops all metadata, EXIF orientation is applied first so photos stay upright.
+func Process(data []byte, maxBytes int64) (Processed, error) {
+	var result Processed
+	if int64(len(data)) > maxBytes {
+		return result, InvalidImageError{fmt.Sprintf("image is larger than %d bytes", maxBytes)}
+	}
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return result, InvalidImageError{"image must be a JPEG, PNG or WebP"}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return result, InvalidImageError{"invalid image"}
+	}
+	if config.Width*config.Height > maxPixels {
+		return result, InvalidImageError{"image dimensions are too large"}
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return result, InvalidImageError{"invalid image"}
+	}
+	sum := sha256.Sum256(data)
+	result.Hash = hex.EncodeToString(sum[:])
+	orientation := jpegOrientation(data)
+	// scale before orienting, fitting the longest side is the same either way
+	current := src
+	for _, size := range sizes {
+		current = fit(current, size.max)
+		variant, err := encode(orient(current, orientation))
+		if err != nil {
+			return result, err
+		}
+		variant.Name = size.name
+		result.Variants = append(result.Variants, variant)
+	}
+	return result, nil
+}
+
+// fit scales the image down so that its longest side is at most max
+func fit(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return src
+	}
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+// encode uses PNG for images with transparency and JPEG otherwise
+func encode(img image.Image) (Variant, error) {
+	var buf bytes.Buffer
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		if err := png.Encode(&buf, img); err != nil {
+			return Variant{}, err
+		}
+		return Variant{ContentType: "image/png", Ext: "png", Data: buf.Bytes()}, nil
+	}
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return Variant{}, err
+	}
+	return Variant{ContentType: "image/jpeg", Ext: "jpg", Data: buf.Bytes()}, nil
+}
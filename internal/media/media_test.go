@@ -0,0 +1,182 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// exifSegment builds an APP1 segment holding only the orientation tag
+func exifSegment(order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 8+2+12)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+// encodeJPEG encodes a w×h image, inserting the EXIF orientation after the
+// start of image marker when it is not 0
+func encodeJPEG(t *testing.T, w, h int, orientation int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+	out := append([]byte{}, data[:2]...)
+	out = append(out, exifSegment(binary.BigEndian, orientation)...)
+	return append(out, data[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"big endian", append(append([]byte{}, soi...), exifSegment(binary.BigEndian, 6)...), 6},
+		{"little endian", append(append([]byte{}, soi...), exifSegment(binary.LittleEndian, 8)...), 8},
+		{"out of range", append(append([]byte{}, soi...), exifSegment(binary.BigEndian, 9)...), 1},
+		{"no exif", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0, 2}, 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"truncated segment", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E'}, 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3×2 image whose top left pixel is marked
+	marked := color.NRGBA{255, 0, 0, 255}
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, marked)
+	tests := []struct {
+		orientation int
+		w, h        int
+		x, y        int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+		{9, 3, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		b := dst.Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if got := color.NRGBAModel.Convert(dst.At(b.Min.X+tt.x, b.Min.Y+tt.y)); got != marked {
+			t.Errorf("orientation %d: marked pixel not at (%d, %d)", tt.orientation, tt.x, tt.y)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, transparent); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		maxBytes int64
+		invalid  bool
+		// size of the full variant and its content type
+		w, h        int
+		contentType string
+	}{
+		{name: "too large", data: encodeJPEG(t, 10, 10, 0), maxBytes: 10, invalid: true},
+		{name: "not an image", data: []byte("<html></html>"), maxBytes: 1 << 20, invalid: true},
+		{name: "corrupt jpeg", data: []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0}, maxBytes: 1 << 20, invalid: true},
+		{name: "scaled down", data: encodeJPEG(t, 4096, 1024, 0), maxBytes: 10 << 20, w: 2048, h: 512, contentType: "image/jpeg"},
+		{name: "small kept", data: encodeJPEG(t, 40, 20, 0), maxBytes: 1 << 20, w: 40, h: 20, contentType: "image/jpeg"},
+		{name: "rotated upright", data: encodeJPEG(t, 40, 20, 6), maxBytes: 1 << 20, w: 20, h: 40, contentType: "image/jpeg"},
+		{name: "transparent png", data: pngBuf.Bytes(), maxBytes: 1 << 20, w: 10, h: 10, contentType: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Process(tt.data, tt.maxBytes)
+			if tt.invalid {
+				if !errors.As(err, &InvalidImageError{}) {
+					t.Fatalf("Process() error = %v, want InvalidImageError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if len(got.Hash) != 64 {
+				t.Errorf("Hash = %q, want a SHA-256", got.Hash)
+			}
+			if len(got.Variants) != len(sizes) {
+				t.Fatalf("got %d variants, want %d", len(got.Variants), len(sizes))
+			}
+			full := got.Variants[0]
+			if full.Name != "full" || full.ContentType != tt.contentType {
+				t.Errorf("full variant = %s %s, want full %s", full.Name, full.ContentType, tt.contentType)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(full.Data))
+			if err != nil {
+				t.Fatalf("decoding full variant: %v", err)
+			}
+			if config.Width != tt.w || config.Height != tt.h {
+				t.Errorf("full variant is %dx%d, want %dx%d", config.Width, config.Height, tt.w, tt.h)
+			}
+			for i, size := range sizes {
+				config, _, err := image.DecodeConfig(bytes.NewReader(got.Variants[i].Data))
+				if err != nil {
+					t.Fatalf("decoding %s variant: %v", size.name, err)
+				}
+				if config.Width > size.max || config.Height > size.max {
+					t.Errorf("%s variant is %dx%d, larger than %d", size.name, config.Width, config.Height, size.max)
+				}
+			}
+			// metadata is dropped by re-encoding
+			if jpegOrientation(full.Data) != 1 {
+				t.Errorf("full variant kept the EXIF orientation")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"pottogether/config"
+	"pottogether/pkg/logger"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "pottogether"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	uploadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_size_bytes",
+		Help:      "Size of uploaded images before processing.",
+		Buckets:   prometheus.ExponentialBuckets(64<<10, 2, 8),
+	}, []string{"kind"})
+	uploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time to process and store an uploaded image.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"kind"})
+	recordsFinished = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_finished_total",
+		Help:      "Cooking records finished.",
+	})
+	signups = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signups_total",
+		Help:      "Users signed up.",
+	})
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by a rate limiter.",
+	}, []string{"limiter"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, uploadSize, uploadDuration, recordsFinished, signups, rateLimited)
+}
+
+// RegisterDB exposes the connection pool stats of the database
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "mariadb"))
+}
+
+// RegisterActiveRecords exposes the number of records being cooked. It is
+// counted every METRICS_REFRESH_SECONDS rather than on every scrape.
+func RegisterActiveRecords(count func(ctx context.Context) (int, error)) {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_cooking_records",
+		Help:      "Records currently being cooked.",
+	})
+	prometheus.MustRegister(gauge)
+	refresh := func() {
+		n, err := count(context.Background())
+		if err != nil {
+			logger.Warn("[METRICS] Error counting active records: " + err.Error())
+			return
+		}
+		gauge.Set(float64(n))
+	}
+	refresh()
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.Viper.GetInt("METRICS_REFRESH_SECONDS")) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			refresh()
+		}
+	}()
+}
+
+// GinMetrics records the count and latency of each request. Routes are the
+// registered patterns so that IDs in the path don't create new series.
+func GinMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics, requiring METRICS_TOKEN as bearer token. No
+// request is let through while the token is not set.
+func Handler() gin.HandlerFunc {
+	h := promhttp.Handler()
+	return func(c *gin.Context) {
+		token := config.Viper.GetString("METRICS_TOKEN")
+		if token == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// ObserveUpload records the size and processing time of an uploaded image
+func ObserveUpload(kind string, size int, duration time.Duration) {
+	uploadSize.WithLabelValues(kind).Observe(float64(size))
+	uploadDuration.WithLabelValues(kind).Observe(duration.Seconds())
+}
+
+func RecordFinished() {
+	recordsFinished.Inc()
+}
+
+func SignedUp() {
+	signups.Inc()
+}
+
+func RateLimited(limiter string) {
+	rateLimited.WithLabelValues(limiter).Inc()
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"pottogether/pkg/mariadb/query"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// apnsTokenLifetime is how long a provider token is reused, Apple rejects
+// tokens older than an hour
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsPusher sends notifications to Apple devices over the HTTP/2 provider
+// API, authenticated with a token signed by the .p8 key
+type APNsPusher struct {
+	keyID  string
+	teamID string
+	topic  string
+	host   string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+func NewAPNsPusher(keyFile string, keyID string, teamID string, topic string, production bool) (*APNsPusher, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(content)
+	if err != nil {
+		return nil, err
+	}
+	host := "https://api.sandbox.push.apple.com"
+	if production {
+		host = "https://api.push.apple.com"
+	}
+	return &APNsPusher{
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		host:   host,
+		key:    key,
+		// the default transport negotiates HTTP/2, which APNs requires
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (a *APNsPusher) Push(token string, n query.Notification) error {
+	providerToken, err := a.getProviderToken()
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": n.Title,
+				"body":  n.Body,
+			},
+			"sound": "default",
+		},
+		"type":           n.Type,
+		"notificationID": n.ID,
+	}
+	for k, v := range n.Data {
+		payload[k] = v
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.host+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reason)
+	if resp.StatusCode == http.StatusGone || reason.Reason == "BadDeviceToken" {
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("apns responded %d: %s", resp.StatusCode, reason.Reason)
+}
+
+// getProviderToken signs a new provider token when the current one is too old
+func (a *APNsPusher) getProviderToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Since(a.issuedAt) < apnsTokenLifetime {
+		return a.token, nil
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.keyID
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.token = signed
+	a.issuedAt = now
+	return signed, nil
+}
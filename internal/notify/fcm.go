@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pottogether/pkg/mariadb/query"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMPusher sends notifications with the Firebase Cloud Messaging HTTP v1 API,
+// authenticated as a Google service account
+type FCMPusher struct {
+	projectID   string
+	clientEmail string
+	tokenURI    string
+	key         *rsa.PrivateKey
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMPusher reads the service account JSON downloaded from the Firebase console
+func NewFCMPusher(credentialsFile string) (*FCMPusher, error) {
+	content, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var credentials struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(content, &credentials); err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(credentials.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	if credentials.TokenURI == "" {
+		credentials.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &FCMPusher{
+		projectID:   credentials.ProjectID,
+		clientEmail: credentials.ClientEmail,
+		tokenURI:    credentials.TokenURI,
+		key:         key,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (f *FCMPusher) Push(token string, n query.Notification) error {
+	accessToken, err := f.getAccessToken()
+	if err != nil {
+		return err
+	}
+	// data values must be strings
+	data := map[string]string{"type": n.Type, "notificationID": fmt.Sprint(n.ID)}
+	for k, v := range n.Data {
+		data[k] = fmt.Sprint(v)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": token,
+			"notification": map[string]string{
+				"title": n.Title,
+				"body":  n.Body,
+			},
+			"data": data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := "https://fcm.googleapis.com/v1/projects/" + f.projectID + "/messages:send"
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		// the token was unregistered
+		return ErrInvalidToken
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("fcm responded %d: %s", resp.StatusCode, msg)
+	}
+}
+
+// getAccessToken exchanges a signed assertion for an OAuth2 access token,
+// reusing it until shortly before it expires
+func (f *FCMPusher) getAccessToken() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Now().Before(f.expiresAt) {
+		return f.accessToken, nil
+	}
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(f.key)
+	if err != nil {
+		return "", err
+	}
+	resp, err := f.client.PostForm(f.tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("fcm token endpoint responded %d: %s", resp.StatusCode, msg)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	f.accessToken = token.AccessToken
+	f.expiresAt = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return f.accessToken, nil
+}
@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"pottogether/config"
+	"pottogether/internal/tracing"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// pollInterval is how often the dispatcher looks for pending pushes
+	pollInterval = 5 * time.Second
+	// batchSize is the number of pushes claimed per poll
+	batchSize = 100
+	// leaseSeconds hides claimed pushes from other dispatchers while sending
+	leaseSeconds = 60
+	// maxAttempts is the number of attempts before a push is given up
+	maxAttempts = 5
+	// retryBase is the delay before the first retry, doubled on each attempt
+	retryBase = 30 * time.Second
+)
+
+// ErrInvalidToken is returned by pushers when the device token is no longer
+// valid, so that it can be removed
+var ErrInvalidToken = fmt.Errorf("invalid device token")
+
+// Pusher delivers a notification to a single device
+type Pusher interface {
+	Push(token string, n query.Notification) error
+}
+
+var pushers = map[string]Pusher{}
+
+// Init sets up a pusher per platform. Platforms without credentials fall back
+// to the logging pusher, which is what local and test environments use.
+func Init() error {
+	pushers["fcm"] = LogPusher{Platform: "fcm"}
+	if file := config.Viper.GetString("FCM_CREDENTIALS_FILE"); file != "" {
+		fcm, err := NewFCMPusher(file)
+		if err != nil {
+			return fmt.Errorf("error setting up fcm: %w", err)
+		}
+		pushers["fcm"] = fcm
+	}
+	pushers["apns"] = LogPusher{Platform: "apns"}
+	if file := config.Viper.GetString("APNS_KEY_FILE"); file != "" {
+		apns, err := NewAPNsPusher(
+			file,
+			config.Viper.GetString("APNS_KEY_ID"),
+			config.Viper.GetString("APNS_TEAM_ID"),
+			config.Viper.GetString("APNS_TOPIC"),
+			config.Viper.GetBool("APNS_PRODUCTION"),
+		)
+		if err != nil {
+			return fmt.Errorf("error setting up apns: %w", err)
+		}
+		pushers["apns"] = apns
+	}
+	return nil
+}
+
+// Start runs the dispatcher in the background. Pushes are stored in the
+// database, so several servers can dispatch and a restart loses nothing.
+func Start() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := dispatch(context.Background()); err != nil {
+				logger.Error("[NOTIFY] Error dispatching pushes: " + err.Error())
+			}
+		}
+	}()
+}
+
+// dispatch sends the pushes that are due, batches with pushes are traced
+func dispatch(ctx context.Context) (err error) {
+	pushes, err := query.ClaimPendingPushes(ctx, batchSize, leaseSeconds)
+	if err != nil || len(pushes) == 0 {
+		return err
+	}
+	ctx, span := tracing.Start(ctx, "notify.dispatch", attribute.Int("notify.pushes", len(pushes)))
+	defer func() { tracing.End(span, err) }()
+	for _, p := range pushes {
+		err := deliver(ctx, p)
+		if err != nil {
+			logger.Warn("[NOTIFY] Error pushing notification " + strconv.Itoa(p.ID) + ": " + err.Error())
+		}
+		giveUp := p.Attempts+1 >= maxAttempts
+		retry := retryBase << p.Attempts
+		if err := query.CompletePush(ctx, p.ID, err == nil, giveUp, int(retry.Seconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver pushes the notification to the devices of the user it did not reach
+// yet. Invalid tokens are dropped, any other failure makes the push retried
+// for the devices that failed.
+func deliver(ctx context.Context, p query.PendingPush) error {
+	devices, err := query.GetUndeliveredDevices(ctx, p.ID, p.UserID)
+	if err != nil {
+		return err
+	}
+	var failed error
+	for _, device := range devices {
+		pusher, ok := pushers[device.Platform]
+		if !ok {
+			continue
+		}
+		err := pusher.Push(device.Token, p.Notification)
+		if err == ErrInvalidToken {
+			if err := query.RemoveDeviceToken(ctx, device.Platform, device.Token); err != nil {
+				failed = err
+			}
+		} else if err != nil {
+			failed = err
+		} else if err := query.MarkDelivered(ctx, p.ID, device.ID); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// LogPusher only logs the notifications it is given
+type LogPusher struct {
+	Platform string
+}
+
+func (l LogPusher) Push(token string, n query.Notification) error {
+	logger.Info("[NOTIFY] " + l.Platform + " push to " + shortToken(token) + ": " + n.Title + " - " + n.Body)
+	return nil
+}
+
+// shortToken keeps enough of a device token to tell devices apart in logs,
+// the full token would let anyone reading them push to the device
+func shortToken(token string) string {
+	if len(token) <= 8 {
+		return "..."
+	}
+	return token[:8] + "..."
+}
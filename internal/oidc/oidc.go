@@ -0,0 +1,214 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"net/http"
+	"os"
+	"pottogether/config"
+	"pottogether/internal/tracing"
+	"strings"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/oauth2"
+)
+
+// defaultIssuers are used for the well-known providers when no
+// OIDC_<NAME>_ISSUER is configured
+var defaultIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+	"apple":  "https://appleid.apple.com",
+}
+
+// Identity is the account of a user at a provider, as stated by a verified
+// ID token
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider verifies the ID tokens of an OpenID Connect provider and exchanges
+// its authorization codes. Apps of each platform have their own client ID,
+// tokens issued to any of them are accepted.
+type Provider struct {
+	Name      string
+	ClientIDs []string
+	oauth     oauth2.Config
+	verifier  *gooidc.IDTokenVerifier
+	// appleKey signs the client secret, which Apple expects as a JWT
+	appleKey   *ecdsa.PrivateKey
+	appleKeyID string
+	appleTeam  string
+}
+
+var (
+	mu        sync.Mutex
+	providers = map[string]*Provider{}
+)
+
+// Get returns the configured provider, discovering its endpoints and keys on
+// first use. Providers are the comma separated names in OIDC_PROVIDERS.
+func Get(ctx context.Context, name string) (*Provider, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if p, ok := providers[name]; ok {
+		return p, nil
+	}
+	configured := false
+	for _, n := range strings.Split(config.Viper.GetString("OIDC_PROVIDERS"), ",") {
+		if strings.TrimSpace(n) == name && name != "" {
+			configured = true
+		}
+	}
+	if !configured {
+		return nil, fmt.Errorf("unknown provider %s", name)
+	}
+	p, err := newProvider(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	providers[name] = p
+	return p, nil
+}
+
+func newProvider(ctx context.Context, name string) (*Provider, error) {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+	issuer := config.Viper.GetString(prefix + "ISSUER")
+	if issuer == "" {
+		issuer = defaultIssuers[name]
+	}
+	var clientIDs []string
+	for _, id := range strings.Split(config.Viper.GetString(prefix+"CLIENT_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			clientIDs = append(clientIDs, id)
+		}
+	}
+	if issuer == "" {
+		return nil, fmt.Errorf("provider %s has no %sISSUER", name, prefix)
+	} else if len(clientIDs) == 0 {
+		return nil, fmt.Errorf("provider %s has no %sCLIENT_IDS", name, prefix)
+	}
+	// the context is kept to fetch rotated keys later, it must outlive the request
+	ctx = gooidc.ClientContext(tracing.Detach(ctx), &http.Client{Timeout: 10 * time.Second})
+	ctx, span := tracing.Start(ctx, "oidc.Discover", attribute.String("oidc.provider", name))
+	discovered, err := gooidc.NewProvider(ctx, issuer)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering provider %s: %w", name, err)
+	}
+	p := &Provider{
+		Name:      name,
+		ClientIDs: clientIDs,
+		oauth: oauth2.Config{
+			ClientID:     clientIDs[0],
+			ClientSecret: config.Viper.GetString(prefix + "CLIENT_SECRET"),
+			Endpoint:     discovered.Endpoint(),
+		},
+		// the audience is checked against all client IDs in verify
+		verifier: discovered.Verifier(&gooidc.Config{SkipClientIDCheck: true}),
+	}
+	if file := config.Viper.GetString(prefix + "KEY_FILE"); file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if p.appleKey, err = jwt.ParseECPrivateKeyFromPEM(content); err != nil {
+			return nil, err
+		}
+		p.appleKeyID = config.Viper.GetString(prefix + "KEY_ID")
+		p.appleTeam = config.Viper.GetString(prefix + "TEAM_ID")
+	}
+	return p, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience and expiry of an ID
+// token obtained by the app, and that it carries the nonce. The caller must
+// make sure the nonce was issued by the server and is used only once.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (Identity, error) {
+	if nonce == "" {
+		return Identity{}, fmt.Errorf("invalid id token: nonce is required")
+	}
+	token, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid id token: %w", err)
+	}
+	audience := false
+	for _, aud := range token.Audience {
+		for _, id := range p.ClientIDs {
+			audience = audience || aud == id
+		}
+	}
+	if !audience {
+		return Identity{}, fmt.Errorf("invalid id token: issued to another client")
+	}
+	if token.Nonce != nonce {
+		return Identity{}, fmt.Errorf("invalid id token: nonce does not match")
+	}
+	var claims struct {
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"`
+		Name          string      `json:"name"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("invalid id token: %w", err)
+	}
+	return Identity{
+		Provider: p.Name,
+		Subject:  token.Subject,
+		Email:    claims.Email,
+		// Apple sends the flag as a string
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:          claims.Name,
+	}, nil
+}
+
+// Exchange redeems an authorization code obtained with PKCE by a web client
+// and verifies the ID token returned with it
+func (p *Provider) Exchange(ctx context.Context, code string, redirectURI string, codeVerifier string, nonce string) (Identity, error) {
+	if codeVerifier == "" {
+		return Identity{}, fmt.Errorf("error exchanging code: code verifier is required")
+	}
+	conf := p.oauth
+	conf.RedirectURL = redirectURI
+	if p.appleKey != nil {
+		secret, err := p.appleClientSecret()
+		if err != nil {
+			return Identity{}, err
+		}
+		conf.ClientSecret = secret
+	}
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", codeVerifier)}
+	spanCtx, span := tracing.Start(ctx, "oidc.Exchange", attribute.String("oidc.provider", p.Name))
+	token, err := conf.Exchange(spanCtx, code, opts...)
+	tracing.End(span, err)
+	if err != nil {
+		return Identity{}, fmt.Errorf("error exchanging code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("error exchanging code: no id token returned")
+	}
+	return p.VerifyIDToken(ctx, rawIDToken, nonce)
+}
+
+// appleClientSecret signs the short-lived client secret Apple expects
+func (p *Provider) appleClientSecret() (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": p.appleTeam,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"aud": "https://appleid.apple.com",
+		"sub": p.oauth.ClientID,
+	})
+	token.Header["kid"] = p.appleKeyID
+	return token.SignedString(p.appleKey)
+}
@@ -0,0 +1,174 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pottogether/config"
+	"pottogether/internal/oidc/oidctest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// newMock starts a mock provider issuing tokens to the web client and returns
+// it configured as the provider "mock", accepting the ios and web clients
+func newMock(t *testing.T) (*Provider, string) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	issuer := "http://" + srv.Listener.Addr().String()
+	mock, err := oidctest.New(issuer, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = mock.Handler()
+	srv.Start()
+	t.Cleanup(srv.Close)
+	vp := viper.New()
+	vp.Set("OIDC_MOCK_ISSUER", issuer)
+	vp.Set("OIDC_MOCK_CLIENT_IDS", "ios, web")
+	previous := config.Viper
+	config.Viper = vp
+	t.Cleanup(func() { config.Viper = previous })
+	p, err := newProvider(context.Background(), "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, issuer
+}
+
+// issue gets an ID token from the mock as a native sign in SDK would
+func issue(t *testing.T, issuer string, params url.Values) string {
+	t.Helper()
+	resp, err := http.Get(issuer + "/issue?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.IDToken
+}
+
+// authorize runs the authorization request of the code flow and returns the code
+func authorize(t *testing.T, issuer string, params url.Values) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(issuer + "/authorize?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code")
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p, issuer := newMock(t)
+	user := url.Values{"sub": {"42"}, "email": {"a@b.co"}, "name": {"Ann"}, "nonce": {"n1"}}
+	token := issue(t, issuer, user)
+	tests := []struct {
+		name      string
+		clientIDs []string
+		token     string
+		nonce     string
+		want      Identity
+		wantErr   string
+	}{
+		{
+			name:  "valid",
+			token: token, nonce: "n1",
+			want: Identity{Provider: "mock", Subject: "42", Email: "a@b.co", EmailVerified: true, Name: "Ann"},
+		},
+		{
+			name:  "unverified email",
+			token: issue(t, issuer, url.Values{"sub": {"42"}, "email": {"a@b.co"}, "email_verified": {"false"}, "nonce": {"n1"}}), nonce: "n1",
+			want: Identity{Provider: "mock", Subject: "42", Email: "a@b.co"},
+		},
+		{name: "other nonce", token: token, nonce: "n2", wantErr: "invalid id token: nonce does not match"},
+		{name: "no nonce", token: token, nonce: "", wantErr: "invalid id token: nonce is required"},
+		{name: "token without nonce", token: issue(t, issuer, url.Values{"sub": {"42"}}), nonce: "n1", wantErr: "invalid id token: nonce does not match"},
+		{name: "other client", clientIDs: []string{"ios"}, token: token, nonce: "n1", wantErr: "invalid id token: issued to another client"},
+		{name: "tampered", token: token[:len(token)-4] + "AAAA", nonce: "n1", wantErr: "invalid id token"},
+		{name: "not a token", token: "abc", nonce: "n1", wantErr: "invalid id token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := *p
+			if tt.clientIDs != nil {
+				provider.ClientIDs = tt.clientIDs
+			}
+			got, err := provider.VerifyIDToken(context.Background(), tt.token, tt.nonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("VerifyIDToken() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyIDToken() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("VerifyIDToken() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchange(t *testing.T) {
+	p, issuer := newMock(t)
+	verifier := "a-verifier-long-enough-for-pkce-0123456789abcdef"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	redirectURI := "https://app.example/callback"
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		wantErr  string
+	}{
+		{name: "valid", verifier: verifier, nonce: "n1"},
+		{name: "wrong verifier", verifier: "another-verifier", nonce: "n1", wantErr: "error exchanging code"},
+		{name: "no verifier", verifier: "", nonce: "n1", wantErr: "error exchanging code: code verifier is required"},
+		{name: "other nonce", verifier: verifier, nonce: "n2", wantErr: "invalid id token: nonce does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := authorize(t, issuer, url.Values{
+				"redirect_uri":          {redirectURI},
+				"login_hint":            {"a@b.co"},
+				"sub":                   {"42"},
+				"nonce":                 {"n1"},
+				"code_challenge":        {challenge},
+				"code_challenge_method": {"S256"},
+			})
+			got, err := p.Exchange(context.Background(), code, redirectURI, tt.verifier, tt.nonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if got.Subject != "42" || got.Email != "a@b.co" || !got.EmailVerified {
+				t.Errorf("Exchange() = %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package oidctest is an OpenID Connect provider for development and tests,
+// served by cmd/mockoidc
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const keyID = "mock"
+
+type grant struct {
+	claims    jwt.MapClaims
+	challenge string
+	expiresAt time.Time
+}
+
+// Provider is an OpenID Connect provider that signs in whoever asks. ID tokens
+// are issued by GET /issue, and by /authorize and /token in the code flow.
+type Provider struct {
+	issuer   string
+	clientID string
+	key      *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// New creates a provider issuing tokens for clientID, reached at issuer
+func New(issuer string, clientID string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{issuer: issuer, clientID: clientID, key: key, grants: map[string]grant{}}, nil
+}
+
+// Handler serves the discovery document, keys and endpoints of the provider
+func (p *Provider) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/issue", p.issue)
+	return mux
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// claims describes the user given by the sub, email (or login_hint), name,
+// email_verified and nonce parameters
+func (p *Provider) claims(q url.Values) jwt.MapClaims {
+	now := time.Now()
+	email := q.Get("email")
+	if email == "" {
+		email = q.Get("login_hint")
+	}
+	sub := q.Get("sub")
+	if sub == "" {
+		sub = email
+	}
+	claims := jwt.MapClaims{
+		"iss":            p.issuer,
+		"aud":            p.clientID,
+		"sub":            sub,
+		"email":          email,
+		"email_verified": q.Get("email_verified") != "false",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+	if name := q.Get("name"); name != "" {
+		claims["name"] = name
+	}
+	if nonce := q.Get("nonce"); nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return claims
+}
+
+func (p *Provider) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(p.key)
+}
+
+// issue returns an ID token right away, as a native sign in SDK would
+func (p *Provider) issue(w http.ResponseWriter, r *http.Request) {
+	signed, err := p.sign(p.claims(r.URL.Query()))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id_token": signed})
+}
+
+// authorize signs the user in without asking and redirects back with a code
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := hex.EncodeToString(buf)
+	p.mu.Lock()
+	p.grants[code] = grant{claims: p.claims(q), challenge: q.Get("code_challenge"), expiresAt: time.Now().Add(time.Minute)}
+	p.mu.Unlock()
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeems a code once, checking the PKCE verifier when a challenge was sent
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	code := r.Form.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+	if !ok || time.Now().After(g.expiresAt) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if g.challenge != "" {
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	}
+	signed, err := p.sign(g.claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": code,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
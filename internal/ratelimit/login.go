@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"pottogether/config"
+	"pottogether/pkg/mariadb/query"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	accountLimiter     *Limiter
+	accountLimiterOnce sync.Once
+)
+
+// account returns the key of the account behind an email, so that the same
+// address in another case is limited together
+func account(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CheckLogin returns how long logins to the account must wait, 0 if one may
+// be attempted now. Attempts are limited per account, and accounts are
+// locked after repeated failures whatever the IP they come from.
+func CheckLogin(ctx context.Context, email string) (time.Duration, error) {
+	accountLimiterOnce.Do(func() {
+		accountLimiter = New("login_account", config.Viper.GetInt("LOGIN_ACCOUNT_RATE_PER_MINUTE"), config.Viper.GetInt("LOGIN_ACCOUNT_BURST"))
+	})
+	locked, err := query.GetLoginLock(ctx, account(email))
+	if err != nil {
+		return 0, err
+	} else if locked > 0 {
+		return time.Duration(locked) * time.Second, nil
+	}
+	if allowed, wait := accountLimiter.Allow(ctx, account(email)); !allowed {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// LoginFailed counts a failed login, returning how long the account is now
+// locked for
+func LoginFailed(ctx context.Context, email string) (time.Duration, error) {
+	locked, err := query.RecordLoginFailure(ctx, account(email),
+		config.Viper.GetInt("LOGIN_LOCKOUT_THRESHOLD"),
+		config.Viper.GetInt("LOGIN_LOCKOUT_BASE_SECONDS"),
+		config.Viper.GetInt("LOGIN_LOCKOUT_MAX_SECONDS"))
+	return time.Duration(locked) * time.Second, err
+}
+
+// LoginSucceeded resets the failures of the account
+func LoginSucceeded(ctx context.Context, email string) error {
+	return query.ClearLoginFailures(ctx, account(email))
+}
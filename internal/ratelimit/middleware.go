@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"pottogether/pkg/errhandler"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ByIP limits the requests of each client IP
+func ByIP(l *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if allowed, wait := l.Allow(c.Request.Context(), "ip:"+c.ClientIP()); !allowed {
+			Reject(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// UserWrites limits the requests changing data of each authenticated user,
+// reads are not limited
+func UserWrites(l *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if allowed, wait := l.Allow(c.Request.Context(), "user:"+strconv.Itoa(c.GetInt("id"))); !allowed {
+			Reject(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Reject answers 429, telling the client in Retry-After when to try again
+func Reject(c *gin.Context, wait time.Duration) {
+	retryAfter := int(math.Max(1, math.Ceil(wait.Seconds())))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	errhandler.TooManyRequests(c, fmt.Errorf("retry after %d seconds", retryAfter), "Too many requests")
+	c.Abort()
+}
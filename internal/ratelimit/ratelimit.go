@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"pottogether/config"
+	"pottogether/internal/metrics"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"sync"
+	"time"
+)
+
+// Limiter hands out tokens per key from buckets holding up to Burst tokens,
+// refilled with Rate tokens per second. Buckets live in memory, or in mariadb
+// when RATE_LIMIT_BACKEND is set to share them between instances.
+type Limiter struct {
+	Name  string
+	Rate  float64
+	Burst int
+	store store
+}
+
+type store interface {
+	take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+// New creates a limiter allowing perMinute requests per key on average and
+// burst at once. A limiter with perMinute 0 allows everything.
+func New(name string, perMinute int, burst int) *Limiter {
+	l := &Limiter{Name: name, Rate: float64(perMinute) / 60, Burst: burst}
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	switch config.Viper.GetString("RATE_LIMIT_BACKEND") {
+	case "mariadb":
+		l.store = dbStore{}
+	default:
+		l.store = newMemoryStore()
+	}
+	return l
+}
+
+// Allow takes a token for the key, returning false and the time until the
+// next token when there is none left. Requests are let through when the
+// shared backend fails, so that an outage does not lock everyone out.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	if l.Rate <= 0 {
+		return true, 0
+	}
+	allowed, wait, err := l.store.take(ctx, l.Name+":"+key, l.Rate, l.Burst)
+	if err != nil {
+		logger.WarnCtx(ctx, "[RATELIMIT] Error taking token from "+l.Name+": "+err.Error())
+		return true, 0
+	}
+	if !allowed {
+		metrics.RateLimited(l.Name)
+	}
+	return allowed, wait
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// memoryStore keeps the buckets of this instance, full buckets are swept
+// once a minute
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{buckets: map[string]*bucket{}, swept: time.Now()}
+}
+
+func (s *memoryStore) take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) > time.Minute {
+		full := time.Duration(float64(burst) / rate * float64(time.Second))
+		for k, b := range s.buckets {
+			if now.Sub(b.updated) > full {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, seconds((1 - b.tokens) / rate), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// dbStore keeps the buckets in mariadb, shared by all instances
+type dbStore struct{}
+
+func (dbStore) take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	allowed, wait, err := query.TakeToken(ctx, key, rate, burst)
+	return allowed, seconds(wait), err
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
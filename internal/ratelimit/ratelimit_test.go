@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// step is a take from the bucket after it was left alone for idle seconds
+type step struct {
+	idle    float64
+	allowed bool
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+		wait  time.Duration
+	}{
+		{
+			name: "burst then empty", rate: 1, burst: 3,
+			steps: []step{{0, true}, {0, true}, {0, true}, {0, false}},
+			wait:  time.Second,
+		},
+		{
+			name: "refilled over time", rate: 0.5, burst: 1,
+			steps: []step{{0, true}, {1, false}, {1, true}},
+		},
+		{
+			name: "capped at burst", rate: 1, burst: 2,
+			steps: []step{{0, true}, {3600, true}, {0, true}, {0, false}},
+			wait:  time.Second,
+		},
+		{
+			name: "partial token", rate: 2, burst: 1,
+			steps: []step{{0, true}, {0.25, false}},
+			wait:  250 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoryStore()
+			var wait time.Duration
+			for i, st := range tt.steps {
+				// age the bucket rather than sleeping
+				if b, ok := s.buckets["k"]; ok {
+					b.updated = b.updated.Add(-time.Duration(st.idle * float64(time.Second)))
+				}
+				allowed, w, err := s.take(context.Background(), "k", tt.rate, tt.burst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if allowed != st.allowed {
+					t.Fatalf("take %d allowed = %v, want %v", i, allowed, st.allowed)
+				}
+				wait = w
+			}
+			// the wait is computed from the current time, allow for its drift
+			if d := wait - tt.wait; d > 10*time.Millisecond || d < -10*time.Millisecond {
+				t.Errorf("wait = %v, want %v", wait, tt.wait)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreKeys(t *testing.T) {
+	s := newMemoryStore()
+	ctx := context.Background()
+	if allowed, _, _ := s.take(ctx, "a", 1, 1); !allowed {
+		t.Fatal("first take of a refused")
+	}
+	if allowed, _, _ := s.take(ctx, "a", 1, 1); allowed {
+		t.Fatal("second take of a allowed")
+	}
+	if allowed, _, _ := s.take(ctx, "b", 1, 1); !allowed {
+		t.Fatal("b shares the bucket of a")
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := newMemoryStore()
+	ctx := context.Background()
+	s.take(ctx, "idle", 1, 5)
+	s.take(ctx, "busy", 1, 5)
+	// idle has been refilled for longer than burst/rate, busy has not
+	s.buckets["idle"].updated = time.Now().Add(-10 * time.Second)
+	s.buckets["busy"].updated = time.Now().Add(-2 * time.Second)
+	s.swept = time.Now().Add(-2 * time.Minute)
+	s.take(ctx, "other", 1, 5)
+	if _, ok := s.buckets["idle"]; ok {
+		t.Error("full bucket was not swept")
+	}
+	if _, ok := s.buckets["busy"]; !ok {
+		t.Error("bucket still refilling was swept")
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *Limiter
+		takes   int
+		allowed bool
+	}{
+		{"disabled", &Limiter{Name: "t", Rate: 0, Burst: 1, store: newMemoryStore()}, 10, true},
+		{"within burst", &Limiter{Name: "t", Rate: 1, Burst: 3, store: newMemoryStore()}, 3, true},
+		{"over burst", &Limiter{Name: "t", Rate: 1, Burst: 3, store: newMemoryStore()}, 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var allowed bool
+			for i := 0; i < tt.takes; i++ {
+				allowed, _ = tt.limiter.Allow(context.Background(), "1.2.3.4")
+			}
+			if allowed != tt.allowed {
+				t.Errorf("take %d allowed = %v, want %v", tt.takes, allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestAccount(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"a@b.co", "a@b.co"},
+		{" A@B.Co ", "a@b.co"},
+	}
+	for _, tt := range tests {
+		if got := account(tt.email); got != tt.want {
+			t.Errorf("account(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
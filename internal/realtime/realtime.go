@@ -0,0 +1,410 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"pottogether/config"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
+	maxMessage = 4096
+	sendBuffer = 32
+	// presence rows are refreshed at this interval and ignored once they are
+	// presenceStale seconds old, which happens when an instance stops
+	presenceRefresh = 30 * time.Second
+	presenceStale   = 90
+	// events read from the database per poll, and how far behind the latest
+	// id read the next poll starts, for ids committed out of order
+	eventBatch    = 500
+	eventLookback = 100
+)
+
+// Event is the envelope of every message sent over a room connection
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Client is a single websocket connection of a user to a room
+type Client struct {
+	UserID int
+	RoomID int
+	conn   *websocket.Conn
+	send   chan []byte
+}
+
+// Handler is called for every event a client sends
+type Handler func(client *Client, event Event)
+
+// The hub only holds the connections to this instance. Events are also
+// published to the realtime_event table, which every instance polls to deliver
+// the events published by the others to its own connections, and presence is
+// kept per instance in realtime_presence, so rooms work across instances
+// without sticky sessions.
+var (
+	mu    sync.RWMutex
+	rooms = map[int]map[*Client]bool{}
+	// instance tells the events and presence of this process apart
+	instance = hostname() + ":" + strconv.Itoa(os.Getpid())
+)
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+}
+
+// checkOrigin lets browsers connect from the API's own origin or the ones in
+// WS_ALLOWED_ORIGINS. Native clients send no origin.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(config.Viper.GetString("WS_ALLOWED_ORIGINS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve upgrades the request and blocks until the connection is closed.
+// Presence is broadcast to the room when the client joins and leaves.
+func Serve(w http.ResponseWriter, r *http.Request, roomID int, userID int, handler Handler) error {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	client := &Client{UserID: userID, RoomID: roomID, conn: conn, send: make(chan []byte, sendBuffer)}
+	register(client)
+	go client.writePump()
+	client.readPump(handler)
+	return nil
+}
+
+// Broadcast sends an event to every member connected to the room, through
+// this instance or any other. Members are looked up on every delivery, so that
+// users who left the room stop receiving its events; their connections are
+// closed.
+func Broadcast(ctx context.Context, roomID int, eventType string, data interface{}) {
+	message, err := encode(eventType, data)
+	if err != nil {
+		logger.ErrorCtx(ctx, "[REALTIME] Error encoding event: "+err.Error())
+		return
+	}
+	deliver(ctx, roomID, message)
+	if err := query.PublishRealtimeEvent(ctx, roomID, instance, string(message)); err != nil {
+		logger.ErrorCtx(ctx, "[REALTIME] Error publishing event: "+err.Error())
+	}
+}
+
+// Start delivers the events published by the other instances to the
+// connections to this one, and keeps the presence of these connections fresh
+func Start() {
+	go func() {
+		ctx := context.Background()
+		start, err := query.GetLastRealtimeEventID(ctx)
+		if err != nil {
+			logger.Error("[REALTIME] Error getting the last event: " + err.Error())
+		}
+		f := &feed{start: start, cursor: start, delivered: map[int64]bool{}}
+		poll := time.NewTicker(time.Duration(config.Viper.GetInt("REALTIME_POLL_MS")) * time.Millisecond)
+		defer poll.Stop()
+		refresh := time.NewTicker(presenceRefresh)
+		defer refresh.Stop()
+		for {
+			select {
+			case <-poll.C:
+				f.receive(ctx)
+			case <-refresh.C:
+				refreshPresence(ctx)
+			}
+		}
+	}()
+}
+
+// feed tracks the events read from the database. Events are read again for a
+// while after the cursor passes them, in case an id below the cursor commits
+// late; delivered remembers those already sent. Events published before the
+// instance started are skipped.
+type feed struct {
+	start     int64
+	cursor    int64
+	delivered map[int64]bool
+}
+
+// receive delivers the events published by other instances since the last poll
+func (f *feed) receive(ctx context.Context) {
+	events, err := query.GetRealtimeEvents(ctx, f.cursor-eventLookback, eventBatch)
+	if err != nil {
+		logger.Error("[REALTIME] Error getting events: " + err.Error())
+		return
+	}
+	for _, event := range events {
+		if event.ID > f.cursor {
+			f.cursor = event.ID
+		}
+		if event.ID <= f.start || f.delivered[event.ID] {
+			continue
+		}
+		f.delivered[event.ID] = true
+		if event.Origin != instance {
+			deliver(ctx, event.RoomID, []byte(event.Payload))
+		}
+	}
+	for id := range f.delivered {
+		if id <= f.cursor-eventLookback {
+			delete(f.delivered, id)
+		}
+	}
+}
+
+// deliver sends a message to the members connected to the room through this
+// instance and closes the connections of the others
+func deliver(ctx context.Context, roomID int, message []byte) {
+	mu.RLock()
+	connected := len(rooms[roomID]) > 0
+	mu.RUnlock()
+	if !connected {
+		return
+	}
+	members, err := query.GetMemberIDs(ctx, roomID)
+	if err != nil {
+		logger.ErrorCtx(ctx, "[REALTIME] Error getting room members: "+err.Error())
+		return
+	}
+	mu.Lock()
+	left := []int{}
+	for client := range rooms[roomID] {
+		if members[client.UserID] {
+			client.enqueue(message)
+		} else {
+			remove(client)
+			left = append(left, client.UserID)
+		}
+	}
+	mu.Unlock()
+	if len(left) > 0 {
+		for _, userID := range left {
+			updatePresence(ctx, roomID, userID)
+		}
+		broadcastPresence(ctx, roomID)
+	}
+}
+
+// Disconnect closes the connections of the user to the room through this
+// instance. Those through other instances are closed by the next event
+// delivered to the room, once the user is no longer a member.
+func Disconnect(ctx context.Context, roomID int, userID int) {
+	mu.Lock()
+	left := false
+	for client := range rooms[roomID] {
+		if client.UserID == userID {
+			remove(client)
+			left = true
+		}
+	}
+	mu.Unlock()
+	if left {
+		updatePresence(ctx, roomID, userID)
+		broadcastPresence(ctx, roomID)
+	}
+}
+
+// Send sends an event to this client only
+func (c *Client) Send(eventType string, data interface{}) {
+	message, err := encode(eventType, data)
+	if err != nil {
+		logger.Error("[REALTIME] Error encoding event: " + err.Error())
+		return
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	// the send channel is closed once the client is unregistered
+	if rooms[c.RoomID][c] {
+		c.enqueue(message)
+	}
+}
+
+// Online returns the ids of the users connected to the room through any
+// instance
+func Online(ctx context.Context, roomID int) ([]int, error) {
+	return query.GetOnline(ctx, roomID, presenceStale)
+}
+
+func encode(eventType string, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Event{Type: eventType, Data: raw})
+}
+
+// enqueue drops the message for clients too slow to keep up; callers hold mu
+func (c *Client) enqueue(message []byte) {
+	select {
+	case c.send <- message:
+	default:
+		logger.Warn("[REALTIME] Dropping message for slow client")
+	}
+}
+
+func register(client *Client) {
+	mu.Lock()
+	if rooms[client.RoomID] == nil {
+		rooms[client.RoomID] = map[*Client]bool{}
+	}
+	rooms[client.RoomID][client] = true
+	mu.Unlock()
+	ctx := context.Background()
+	updatePresence(ctx, client.RoomID, client.UserID)
+	broadcastPresence(ctx, client.RoomID)
+}
+
+func unregister(client *Client) {
+	mu.Lock()
+	_, ok := rooms[client.RoomID][client]
+	remove(client)
+	mu.Unlock()
+	if ok {
+		ctx := context.Background()
+		updatePresence(ctx, client.RoomID, client.UserID)
+		broadcastPresence(ctx, client.RoomID)
+	}
+}
+
+// remove closes the send channel of the client, which closes its connection;
+// callers hold mu
+func remove(client *Client) {
+	if _, ok := rooms[client.RoomID][client]; ok {
+		delete(rooms[client.RoomID], client)
+		close(client.send)
+		if len(rooms[client.RoomID]) == 0 {
+			delete(rooms, client.RoomID)
+		}
+	}
+}
+
+// updatePresence records whether the user is still connected to the room
+// through this instance
+func updatePresence(ctx context.Context, roomID int, userID int) {
+	if err := query.SetPresence(ctx, instance, roomID, userID, connected(roomID, userID)); err != nil {
+		logger.ErrorCtx(ctx, "[REALTIME] Error updating presence: "+err.Error())
+	}
+}
+
+// refreshPresence records again every user connected through this instance,
+// which also repairs rows left behind by concurrent updates
+func refreshPresence(ctx context.Context) {
+	mu.RLock()
+	users := map[int]map[int]bool{}
+	for roomID, clients := range rooms {
+		users[roomID] = map[int]bool{}
+		for client := range clients {
+			users[roomID][client.UserID] = true
+		}
+	}
+	mu.RUnlock()
+	for roomID, ids := range users {
+		for userID := range ids {
+			if err := query.SetPresence(ctx, instance, roomID, userID, true); err != nil {
+				logger.Error("[REALTIME] Error refreshing presence: " + err.Error())
+				return
+			}
+		}
+	}
+}
+
+func connected(roomID int, userID int) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	for client := range rooms[roomID] {
+		if client.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func broadcastPresence(ctx context.Context, roomID int) {
+	online, err := Online(ctx, roomID)
+	if err != nil {
+		logger.ErrorCtx(ctx, "[REALTIME] Error getting online users: "+err.Error())
+		return
+	}
+	Broadcast(ctx, roomID, "presence", map[string]interface{}{"online": online})
+}
+
+func (c *Client) readPump(handler Handler) {
+	defer func() {
+		unregister(c)
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(maxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		var event Event
+		if err := c.conn.ReadJSON(&event); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logger.Warn("[REALTIME] " + err.Error())
+			}
+			return
+		}
+		handler(c, event)
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
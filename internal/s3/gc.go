@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"context"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"time"
+)
+
+// gcPrefixes are the prefixes of stored images, raw uploads are removed by
+// the upload expiry instead
+var gcPrefixes = []string{"record/", "ingredient/"}
+
+type Orphan struct {
+	Key          string  `json:"key"`
+	Size         int64   `json:"size"`
+	LastModified int64   `json:"lastModified"`
+	Tracked      bool    `json:"tracked"`
+	OwnerType    *string `json:"ownerType"`
+	OwnerID      *int    `json:"ownerID"`
+}
+
+type GCReport struct {
+	DryRun      bool     `json:"dryRun"`
+	Scanned     int      `json:"scanned"`
+	Orphans     []Orphan `json:"orphans"`
+	OrphanBytes int64    `json:"orphanBytes"`
+	Deleted     int      `json:"deleted"`
+}
+
+// CollectOrphans deletes stored images that are not referenced by any record
+// or ingredient and were last written before the grace period. Images are
+// content addressed, re-uploading one refreshes its modification time, so an
+// image attached during the scan is never old enough to be deleted. In dry run
+// mode the orphans are only reported.
+func CollectOrphans(ctx context.Context, grace time.Duration, dryRun bool) (GCReport, error) {
+	report := GCReport{DryRun: dryRun, Orphans: []Orphan{}}
+	referenced, err := query.GetReferencedMedia(ctx)
+	if err != nil {
+		return report, err
+	}
+	cutoff := time.Now().Add(-grace)
+	for _, prefix := range gcPrefixes {
+		err := ListObjects(ctx, prefix, func(key string, size int64, modified time.Time) error {
+			report.Scanned++
+			if referenced[key] || modified.After(cutoff) {
+				return nil
+			}
+			media, tracked, err := query.GetMedia(ctx, key)
+			if err != nil {
+				return err
+			}
+			report.Orphans = append(report.Orphans, Orphan{
+				Key:          key,
+				Size:         size,
+				LastModified: modified.Unix(),
+				Tracked:      tracked,
+				OwnerType:    media.OwnerType,
+				OwnerID:      media.OwnerID,
+			})
+			report.OrphanBytes += size
+			if dryRun {
+				return nil
+			}
+			logger.InfoCtx(ctx, "[S3] Deleting orphaned image "+key)
+			if err := DeleteObject(ctx, key); err != nil {
+				return err
+			}
+			if tracked {
+				if err := query.MarkMediaDeleted(ctx, key); err != nil {
+					return err
+				}
+			}
+			report.Deleted++
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
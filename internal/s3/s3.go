@@ -2,17 +2,27 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"pottogether/config"
+	"pottogether/internal/media"
+	"pottogether/internal/metrics"
+	"pottogether/internal/tracing"
 	"pottogether/pkg/errhandler"
 	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb/query"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -37,61 +47,271 @@ func InitS3Session() {
 	s3Session = s
 }
 
-func UploadImage(image []byte, filename string) (string, error) {
+// UploadImage stores the image under the given key. Keys are content
+// addressed, so objects never change and can be cached forever. Public images
+// are readable by anyone and returned as permanent URLs, private ones are
+// returned as keys to be signed with SignURL.
+func UploadImage(ctx context.Context, image []byte, filename string, public bool) (string, error) {
 	filetype := http.DetectContentType(image)
 	fileBytes := bytes.NewReader(image)
 	fileSize := int64(len(image))
-	logger.Info("[S3] Uploading image " + filename)
+	logger.InfoCtx(ctx, "[S3] Uploading image "+filename)
 	params := &s3.PutObjectInput{
 		Bucket:        aws.String(S3_BUCKET),
 		Key:           aws.String(filename),
 		Body:          fileBytes,
 		ContentLength: aws.Int64(fileSize),
 		ContentType:   aws.String(filetype),
-		ACL:           aws.String("public-read"),
+		CacheControl:  aws.String("private, max-age=31536000, immutable"),
 	}
-	_, err := s3.New(s3Session).PutObject(params)
+	if public {
+		params.CacheControl = aws.String("public, max-age=31536000, immutable")
+		params.ACL = aws.String("public-read")
+	}
+	ctx, span := startSpan(ctx, "PutObject", filename)
+	_, err := s3.New(s3Session).PutObjectWithContext(ctx, params)
+	tracing.End(span, err)
 	if err != nil {
-		logger.Error("[S3] " + err.Error())
+		logger.ErrorCtx(ctx, "[S3] "+err.Error())
 		return "", err
 	}
-	logger.Info("[S3] Image uploaded")
+	logger.InfoCtx(ctx, "[S3] Image uploaded")
+	if !public {
+		return filename, nil
+	}
 	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", S3_BUCKET, S3_REGION, filename)
 	return url, nil
 }
 
-// upload middleware
-func UploadMiddleware(c *gin.Context, kind string, filename string) {
+// SignURL returns a short-lived URL to read a private object, or an empty
+// string if there is no object. Records are created with a "null" placeholder.
+func SignURL(key string) string {
+	if key == "" || key == "null" {
+		return ""
+	}
+	if s3Session == nil {
+		InitS3Session()
+	}
+	req, _ := s3.New(s3Session).GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(S3_BUCKET),
+		Key:    aws.String(key),
+	})
+	url, err := req.Presign(time.Duration(config.Viper.GetInt("MEDIA_URL_TTL_SECONDS")) * time.Second)
+	if err != nil {
+		logger.Warn("[S3] Error signing " + key + ": " + err.Error())
+		return ""
+	}
+	return url
+}
+
+// SignImages replaces the keys of private images with signed URLs in place
+func SignImages(keys ...*string) {
+	for _, key := range keys {
+		*key = SignURL(*key)
+	}
+}
+
+// UploadMiddleware validates the "image" form file, renders its variants and
+// uploads them with StoreImage. The results are set in the context as image,
+// imageMedium and imageThumbnail. Returns false after responding
+// with an error.
+func UploadMiddleware(c *gin.Context, kind string) bool {
 	file, err := c.FormFile("image")
 	if err != nil {
-		errhandler.Error(c, err, "Error retrieving image from the form")
+		errhandler.Info(c, err, "Error retrieving image from the form")
+		c.Abort()
+		return false
+	}
+	maxBytes := config.Viper.GetInt64("UPLOAD_MAX_BYTES")
+	if file.Size > maxBytes {
+		errhandler.Info(c, fmt.Errorf("image is larger than %d bytes", maxBytes), "Invalid image")
 		c.Abort()
-		return
+		return false
 	}
 	fileBytes, err := file.Open()
 	if err != nil {
 		errhandler.Error(c, err, "Error opening image")
 		c.Abort()
-		return
+		return false
 	}
 	defer fileBytes.Close()
-	buffer := make([]byte, file.Size)
-	if _, err := fileBytes.Read(buffer); err != nil {
+	buffer, err := io.ReadAll(io.LimitReader(fileBytes, maxBytes+1))
+	if err != nil {
 		errhandler.Error(c, err, "Error reading image")
 		c.Abort()
-		return
+		return false
+	}
+	images, err := StoreImage(c.Request.Context(), kind, buffer)
+	if err != nil {
+		if _, ok := err.(media.InvalidImageError); ok {
+			errhandler.Info(c, err, "Invalid image")
+		} else {
+			errhandler.Error(c, err, "Error uploading image to s3")
+		}
+		c.Abort()
+		return false
+	}
+	c.Set("image", images.Full)
+	c.Set("imageMedium", images.Medium)
+	c.Set("imageThumbnail", images.Thumbnail)
+	return true
+}
+
+// Images are the variants of an uploaded image, keys for record photos and
+// URLs for ingredients
+type Images struct {
+	Full      string
+	Medium    string
+	Thumbnail string
+}
+
+// StoreImage validates the image, renders its variants and uploads them under
+// <kind>/<hash>/<variant>. Record photos may come from private rooms and are
+// stored privately, ingredient images are public. Validation errors are
+// media.InvalidImageError.
+func StoreImage(ctx context.Context, kind string, data []byte) (Images, error) {
+	var images Images
+	start := time.Now()
+	_, span := tracing.Start(ctx, "media.Process", attribute.String("media.kind", kind), attribute.Int("media.size", len(data)))
+	processed, err := media.Process(data, config.Viper.GetInt64("UPLOAD_MAX_BYTES"))
+	tracing.End(span, err)
+	if err != nil {
+		return images, err
 	}
-	// upload image to s3
 	InitS3Session()
-	if filename == "" {
-		filename = file.Filename
+	for _, variant := range processed.Variants {
+		path := fmt.Sprintf("%s/%s/%s.%s", kind, processed.Hash, variant.Name, variant.Ext)
+		url, err := UploadImage(ctx, variant.Data, path, kind != "record")
+		if err != nil {
+			return images, err
+		}
+		if err := query.TrackMedia(ctx, path, kind, int64(len(variant.Data))); err != nil {
+			return images, err
+		}
+		switch variant.Name {
+		case "full":
+			images.Full = url
+		case "medium":
+			images.Medium = url
+		case "thumbnail":
+			images.Thumbnail = url
+		}
+	}
+	metrics.ObserveUpload(kind, len(data), time.Since(start))
+	return images, nil
+}
+
+// PresignUpload returns a URL the client can PUT the object to directly. The
+// content type and size are part of the signature, so the object must be
+// uploaded with exactly these headers.
+func PresignUpload(key string, contentType string, size int64, ttl time.Duration) (string, error) {
+	if s3Session == nil {
+		InitS3Session()
+	}
+	req, _ := s3.New(s3Session).PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        aws.String(S3_BUCKET),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	})
+	return req.Presign(ttl)
+}
+
+// HeadObject returns the size and content type of an object, or
+// ErrObjectNotFound if it does not exist
+func HeadObject(ctx context.Context, key string) (int64, string, error) {
+	if s3Session == nil {
+		InitS3Session()
 	}
-	path := fmt.Sprintf("%s/%s", kind, filename)
-	url, err := UploadImage(buffer, path)
+	ctx, span := startSpan(ctx, "HeadObject", key)
+	output, err := s3.New(s3Session).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(S3_BUCKET),
+		Key:    aws.String(key),
+	})
+	tracing.End(span, err)
 	if err != nil {
-		errhandler.Error(c, err, "Error uploading image to s3")
-		c.Abort()
-		return
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+			return 0, "", ErrObjectNotFound
+		}
+		return 0, "", err
 	}
-	c.Set("image", url)
+	return aws.Int64Value(output.ContentLength), aws.StringValue(output.ContentType), nil
+}
+
+// ErrObjectNotFound is returned when the requested object does not exist
+var ErrObjectNotFound = fmt.Errorf("object does not exist")
+
+// GetObject downloads an object, reading at most maxBytes
+func GetObject(ctx context.Context, key string, maxBytes int64) ([]byte, error) {
+	if s3Session == nil {
+		InitS3Session()
+	}
+	ctx, span := startSpan(ctx, "GetObject", key)
+	output, err := s3.New(s3Session).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(S3_BUCKET),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(output.Body, maxBytes))
+	tracing.End(span, err)
+	return data, err
+}
+
+func DeleteObject(ctx context.Context, key string) error {
+	if s3Session == nil {
+		InitS3Session()
+	}
+	ctx, span := startSpan(ctx, "DeleteObject", key)
+	_, err := s3.New(s3Session).DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(S3_BUCKET),
+		Key:    aws.String(key),
+	})
+	tracing.End(span, err)
+	return err
+}
+
+// ListObjects calls fn for every object under the prefix
+func ListObjects(ctx context.Context, prefix string, fn func(key string, size int64, modified time.Time) error) error {
+	if s3Session == nil {
+		InitS3Session()
+	}
+	var fnErr error
+	err := s3.New(s3Session).ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(S3_BUCKET),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			fnErr = fn(aws.StringValue(object.Key), aws.Int64Value(object.Size), aws.TimeValue(object.LastModified))
+			if fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// Ping checks that the bucket can be reached within timeout
+func Ping(ctx context.Context, timeout time.Duration) error {
+	if s3Session == nil {
+		InitS3Session()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := s3.New(s3Session).HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(S3_BUCKET),
+	})
+	return err
+}
+
+// startSpan traces a storage call on the object
+func startSpan(ctx context.Context, operation string, key string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "s3."+operation, attribute.String("s3.bucket", S3_BUCKET), attribute.String("s3.key", key))
 }
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pottogether/config"
+	"pottogether/internal/version"
+	"pottogether/pkg/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ServiceName = "pottogether"
+
+// Init sets up the exporter chosen by TRACING_EXPORTER: "otlp", "stdout" or
+// "none". The returned function flushes the pending spans on shutdown.
+func Init() (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Viper.GetString("TRACING_EXPORTER") {
+	case "otlp":
+		// the standard OTEL_EXPORTER_OTLP_* variables apply when not set here
+		var opts []otlptracehttp.Option
+		if endpoint := config.Viper.GetString("TRACING_OTLP_ENDPOINT"); endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if config.Viper.GetBool("TRACING_OTLP_INSECURE") {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "none", "":
+		logger.Info("[TRACING] Tracing disabled")
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %q", config.Viper.GetString("TRACING_EXPORTER"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName), semconv.ServiceVersion(version.Version))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.Viper.GetFloat64("TRACING_SAMPLE_RATIO")))),
+	)
+	otel.SetTracerProvider(provider)
+	logger.Info("[TRACING] Exporting spans to " + config.Viper.GetString("TRACING_EXPORTER"))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span, marking it failed if err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a context in the same trace as ctx that is not cancelled
+// with it, for work that outlives the request
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
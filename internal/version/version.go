@@ -0,0 +1,48 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with
+//
+//	-ldflags "-X pottogether/internal/version.Version=... -X pottogether/internal/version.Commit=... -X pottogether/internal/version.BuildTime=..."
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information, falling back to the VCS details the Go
+// toolchain stamps into the binary
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
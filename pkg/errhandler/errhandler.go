@@ -12,7 +12,7 @@ func Info(c *gin.Context, err error, msg string) {
 		"isSuccess": false,
 		"message":   msg + ": " + err.Error(),
 	})
-	logger.Error(msg + ": " + err.Error())
+	logger.ErrorCtx(c.Request.Context(), msg+": "+err.Error())
 }
 
 func Error(c *gin.Context, err error, msg string) {
@@ -20,7 +20,7 @@ func Error(c *gin.Context, err error, msg string) {
 		"isSuccess": false,
 		"message":   msg + ": " + err.Error(),
 	})
-	logger.Error(msg + ": " + err.Error())
+	logger.ErrorCtx(c.Request.Context(), msg+": "+err.Error())
 }
 
 func Unauthorized(c *gin.Context, err error, msg string) {
@@ -28,5 +28,21 @@ func Unauthorized(c *gin.Context, err error, msg string) {
 		"isSuccess": false,
 		"message":   msg + ": " + err.Error(),
 	})
-	logger.Info(msg + ": " + err.Error())
+	logger.InfoCtx(c.Request.Context(), msg+": "+err.Error())
+}
+
+func Forbidden(c *gin.Context, err error, msg string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"isSuccess": false,
+		"message":   msg + ": " + err.Error(),
+	})
+	logger.InfoCtx(c.Request.Context(), msg+": "+err.Error())
+}
+
+func TooManyRequests(c *gin.Context, err error, msg string) {
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"isSuccess": false,
+		"message":   msg + ": " + err.Error(),
+	})
+	logger.InfoCtx(c.Request.Context(), msg+": "+err.Error())
 }
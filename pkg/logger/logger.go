@@ -1,23 +1,33 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"pottogether/config"
 	"runtime"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var Log *zap.Logger
 
+// InitLogger logs to the console and to a JSON file rotated by size, at the
+// level set by LOG_LEVEL. Sensitive fields are redacted from every line.
 func InitLogger(path string) {
+	level := zap.NewAtomicLevel()
+	if err := level.UnmarshalText([]byte(config.Viper.GetString("LOG_LEVEL"))); err != nil {
+		fmt.Println("Invalid LOG_LEVEL, using info:", err)
+		level.SetLevel(zapcore.InfoLevel)
+	}
 	// file logger
-	file, _ := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	fileWriteSyncer := zapcore.AddSync(file)
+	fileWriteSyncer := zapcore.AddSync(RotatingWriter(path))
 	productionCfg := zap.NewProductionEncoderConfig()
 	productionCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 	fileEncoder := zapcore.NewJSONEncoder(productionCfg)
@@ -28,10 +38,22 @@ func InitLogger(path string) {
 	consoleEncoder := zapcore.NewConsoleEncoder(developmentCfg)
 	// set core
 	core := zapcore.NewTee(
-		zapcore.NewCore(consoleEncoder, stdout, zapcore.DebugLevel),
-		zapcore.NewCore(fileEncoder, fileWriteSyncer, zapcore.DebugLevel),
+		zapcore.NewCore(consoleEncoder, stdout, level),
+		zapcore.NewCore(fileEncoder, fileWriteSyncer, level),
 	)
-	Log = zap.New(core)
+	Log = zap.New(redactCore{core})
+}
+
+// RotatingWriter appends to the file, rotating it once it reaches
+// LOG_MAX_SIZE_MB and keeping LOG_MAX_BACKUPS files for LOG_MAX_AGE_DAYS
+func RotatingWriter(path string) io.Writer {
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    config.Viper.GetInt("LOG_MAX_SIZE_MB"),
+		MaxBackups: config.Viper.GetInt("LOG_MAX_BACKUPS"),
+		MaxAge:     config.Viper.GetInt("LOG_MAX_AGE_DAYS"),
+		Compress:   config.Viper.GetBool("LOG_COMPRESS"),
+	}
 }
 
 func Info(message string, fields ...zap.Field) {
@@ -79,6 +101,43 @@ func Panic(message string, fields ...zap.Field) {
 	Log.Panic(message, fields...)
 }
 
+// InfoCtx logs with the request details of ctx, see Fields
+func InfoCtx(ctx context.Context, message string, fields ...zap.Field) {
+	if Log == nil {
+		return
+	}
+	fields = append(fields, Fields(ctx)...)
+	fields = append(fields, getCallerInfoForLog()...)
+	Log.Info(message, fields...)
+}
+
+func DebugCtx(ctx context.Context, message string, fields ...zap.Field) {
+	if Log == nil {
+		return
+	}
+	fields = append(fields, Fields(ctx)...)
+	fields = append(fields, getCallerInfoForLog()...)
+	Log.Debug(message, fields...)
+}
+
+func WarnCtx(ctx context.Context, message string, fields ...zap.Field) {
+	if Log == nil {
+		return
+	}
+	fields = append(fields, Fields(ctx)...)
+	fields = append(fields, getCallerInfoForLog()...)
+	Log.Warn(message, fields...)
+}
+
+func ErrorCtx(ctx context.Context, message string, fields ...zap.Field) {
+	if Log == nil {
+		return
+	}
+	fields = append(fields, Fields(ctx)...)
+	fields = append(fields, getCallerInfoForLog()...)
+	Log.Error(message, fields...)
+}
+
 func getCallerInfoForLog() (callerFields []zap.Field) {
 	pc, file, line, ok := runtime.Caller(2)
 	if !ok {
@@ -100,8 +159,8 @@ func GinLog() gin.HandlerFunc {
 		clientIP := c.ClientIP()
 		method := c.Request.Method
 		statusCode := c.Writer.Status()
-		requestURI := c.Request.RequestURI
+		requestURI := redactPath(c)
 		ginInfo := fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %s", endTime.Format("2006/01/02 - 15:04:05"), statusCode, latencyTime, clientIP, method, requestURI)
-		Info(ginInfo)
+		InfoCtx(c.Request.Context(), ginInfo)
 	}
 }
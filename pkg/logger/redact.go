@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are the parts of field names whose values are never logged
+var sensitiveKeys = []string{"password", "passwd", "token", "secret", "authorization", "cookie", "credential", "apikey", "api_key", "email", "verifier", "ticket"}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	// key=value and key:value pairs, as in query strings and %+v output
+	pairPattern = regexp.MustCompile(`(?i)\b(password|passwd|token|secret|ticket)(["']?\s*[:=]\s*["']?)[^\s&,"'}]+`)
+)
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactString masks emails, bearer tokens and secrets in key=value pairs
+func redactString(s string) string {
+	s = emailPattern.ReplaceAllString(s, "[EMAIL]")
+	s = bearerPattern.ReplaceAllString(s, "${1}"+redacted)
+	return pairPattern.ReplaceAllString(s, "${1}${2}"+redacted)
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch {
+		case sensitiveKey(f.Key):
+			out[i] = zap.String(f.Key, redacted)
+		case f.Type == zapcore.StringType:
+			f.String = redactString(f.String)
+			out[i] = f
+		default:
+			out[i] = f
+		}
+	}
+	return out
+}
+
+// redactCore redacts the message and fields of every entry
+type redactCore struct {
+	zapcore.Core
+}
+
+func (c redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = redactString(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// Redact logs v, e.g. a request body, as JSON with its sensitive fields masked
+func Redact(key string, v interface{}) zap.Field {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return zap.String(key, "[unloggable: "+err.Error()+"]")
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return zap.String(key, "[unloggable: "+err.Error()+"]")
+	}
+	return zap.Any(key, redactValue(value))
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if sensitiveKey(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+		return v
+	case string:
+		return redactString(v)
+	default:
+		return v
+	}
+}
+
+// redactPath returns the request URI with sensitive path parameters masked,
+// such as the token of the calendar feed
+func redactPath(c *gin.Context) string {
+	uri := c.Request.RequestURI
+	for _, param := range c.Params {
+		if sensitiveKey(param.Key) && param.Value != "" {
+			uri = strings.Replace(uri, param.Value, redacted, 1)
+		}
+	}
+	return redactString(uri)
+}
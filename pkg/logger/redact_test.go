@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"user a.b+c@example.com signed up", "user [EMAIL] signed up"},
+		{"Authorization: Bearer eyJhbGciOi.J9.x-y_z", "Authorization: Bearer [REDACTED]"},
+		{"/calendar?token=abc123&x=1", "/calendar?token=[REDACTED]&x=1"},
+		{`{"password": "hunter2", "name": "a"}`, `{"password": "[REDACTED]", "name": "a"}`},
+		{"{Ticket:deadbeef Room:3}", "{Ticket:[REDACTED] Room:3}"},
+		{"nothing to hide", "nothing to hide"},
+	}
+	for _, tt := range tests {
+		if got := redactString(tt.in); got != tt.want {
+			t.Errorf("redactString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSensitiveKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"password", true},
+		{"newPassword", true},
+		{"idToken", true},
+		{"codeVerifier", true},
+		{"X-Api_Key", true},
+		{"Email", true},
+		{"name", false},
+		{"roomID", false},
+	}
+	for _, tt := range tests {
+		if got := sensitiveKey(tt.key); got != tt.want {
+			t.Errorf("sensitiveKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	type member struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	tests := []struct {
+		name string
+		in   interface{}
+		want interface{}
+	}{
+		{
+			name: "struct",
+			in: struct {
+				Email    string `json:"email"`
+				Password string `json:"password"`
+				Note     string `json:"note"`
+				Avatar   int    `json:"avatar"`
+			}{"a@b.co", "hunter2", "mail me at c@d.co", 3},
+			want: map[string]interface{}{"email": redacted, "password": redacted, "note": "mail me at [EMAIL]", "avatar": float64(3)},
+		},
+		{
+			name: "nested",
+			in:   map[string]interface{}{"members": []member{{"a", "a@b.co"}}},
+			want: map[string]interface{}{"members": []interface{}{map[string]interface{}{"name": "a", "email": redacted}}},
+		},
+		{
+			name: "unloggable",
+			in:   make(chan int),
+			want: "[unloggable: json: unsupported type: chan int]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Redact("request", tt.in)
+			got := f.Interface
+			if f.Type == zapcore.StringType {
+				got = f.String
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Redact() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactCore(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(redactCore{core}).With(zap.String("token", "abc"))
+	log.Info("login of a@b.co", zap.String("query", "password=x"), zap.Int("id", 1))
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if entries[0].Message != "login of [EMAIL]" {
+		t.Errorf("message = %q", entries[0].Message)
+	}
+	want := map[string]interface{}{"token": redacted, "query": "password=" + redacted, "id": int64(1)}
+	if got := entries[0].ContextMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/calendar/s3cr3t/feed.ics?ticket=t1", nil)
+	c.Params = gin.Params{{Key: "token", Value: "s3cr3t"}, {Key: "roomID", Value: "3"}}
+	want := "/calendar/[REDACTED]/feed.ics?ticket=[REDACTED]"
+	if got := redactPath(c); got != want {
+		t.Errorf("redactPath() = %q, want %q", got, want)
+	}
+}
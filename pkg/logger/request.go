@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestKey struct{}
+
+// requestInfo is attached to every line logged with the request context. The
+// user is filled in once the token is validated.
+type requestInfo struct {
+	id     string
+	route  string
+	userID int
+}
+
+// validRequestID limits the IDs accepted from clients and proxies
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID keeps the X-Request-ID of the request or generates one, returns
+// it in the response and stores it with the route in the request context and
+// on the span
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Set("requestID", id)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("http.request_id", id))
+		info := &requestInfo{id: id, route: c.FullPath()}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestKey{}, info))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// SetUserID records the authenticated user of the request for its log lines
+func SetUserID(ctx context.Context, userID int) {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// Fields returns the request, user, route and trace IDs of ctx as log fields,
+// so that log lines can be matched to requests and traces
+func Fields(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		fields = append(fields, zap.String("request_id", info.id), zap.String("route", info.route))
+		if info.userID != 0 {
+			fields = append(fields, zap.Int("user_id", info.userID))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+	return fields
+}
@@ -1,29 +1,72 @@
 package mariadb
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"pottogether/config"
+	"pottogether/pkg/logger"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/go-sql-driver/mysql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var DB *sql.DB
 
 func Connect_init() error {
 	var err error
+	connectionString := dsn()
+	// queries are traced when they run within a traced request or job
+	DB, err = otelsql.Open("mysql", connectionString,
+		otelsql.WithAttributes(semconv.DBSystemMySQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, method otelsql.Method, query string, args []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}))
+	if err != nil {
+		return err
+	}
+	DB.SetConnMaxLifetime(time.Minute * 5)
+	// sql.Open does not connect, make sure the database is reachable before
+	// serving, giving it some time in case it is still starting
+	retries := config.Viper.GetInt("MARIADB_CONNECT_RETRIES")
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		if err = Ping(context.Background(), 5*time.Second); err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			DB.Close()
+			return err
+		}
+		logger.Warn(fmt.Sprintf("[MARIADB] Database not reachable, retrying in %v: %s", backoff, err.Error()))
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// dsn is the connection string of the configured database
+func dsn() string {
 	dbUser := config.Viper.GetString("MARIADB_USER")
 	dbPass := config.Viper.GetString("MARIADB_PASSWORD")
 	dbHost := config.Viper.GetString("MARIADB_HOST")
 	dbPort := config.Viper.GetInt("MARIADB_PORT")
 	dbName := config.Viper.GetString("MARIADB_DATABASE")
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", dbUser, dbPass, dbHost, dbPort, dbName)
+}
 
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", dbUser, dbPass, dbHost, dbPort, dbName)
-	DB, err = sql.Open("mysql", connectionString)
-	if err != nil {
-		return err
-	}
-	DB.SetConnMaxLifetime(time.Minute * 5)
-	return nil
+// Ping checks that the database answers within timeout
+func Ping(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return DB.PingContext(ctx)
 }
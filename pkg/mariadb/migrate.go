@@ -0,0 +1,91 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pottogether/pkg/logger"
+	"sort"
+	"strings"
+)
+
+// Migrate applies the .sql scripts of dir that were not applied yet, in file
+// name order, recording each one in schema_migrations. The scripts are
+// written to be re-runnable, so a database they were already loaded into by
+// hand or by the mariadb image's initdb is brought in line safely.
+func Migrate(ctx context.Context, dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	// the scripts hold several statements each, which the driver only runs
+	// on a connection that allows it
+	db, err := sql.Open("mysql", dsn()+"?multiStatements=true")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// instances starting together wait for the first one to migrate
+	var locked sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK('schema_migrations', 300)").Scan(&locked); err != nil {
+		return err
+	} else if locked.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for the migration lock")
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK('schema_migrations')")
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    VARCHAR(255) NOT NULL,
+			applied_at DATETIME     NOT NULL,
+			PRIMARY KEY (version)
+		)`
+	if _, err = conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	applied := map[string]bool{}
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, file := range files {
+		version := strings.TrimSuffix(filepath.Base(file), ".sql")
+		if applied[version] {
+			continue
+		}
+		script, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		// MariaDB commits DDL implicitly, a failed script is retried as a
+		// whole on the next start
+		if _, err = conn.ExecContext(ctx, string(script)); err != nil {
+			return fmt.Errorf("error applying migration %s: %w", version, err)
+		}
+		query = "INSERT INTO schema_migrations (version, applied_at) VALUES (?, NOW())"
+		if _, err = conn.ExecContext(ctx, query, version); err != nil {
+			return err
+		}
+		logger.Info("[MARIADB] Applied migration " + version)
+	}
+	return nil
+}
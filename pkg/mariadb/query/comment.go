@@ -0,0 +1,220 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb"
+)
+
+type Comment struct {
+	ID        int    `json:"commentID"`
+	RecordID  int    `json:"recordID"`
+	ParentID  *int   `json:"parentID"`
+	UserID    int    `json:"userID"`
+	Username  string `json:"username"`
+	Avatar    *int   `json:"avatar"`
+	Content   string `json:"content"`
+	CreatedAt int    `json:"createdAt"`
+	UpdatedAt *int   `json:"updatedAt"`
+	Deleted   bool   `json:"deleted"`
+}
+
+type reactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// checkRecordAccess returns the room of the record, failing unless the user is
+// a member of it and the record is finished
+func checkRecordAccess(ctx context.Context, recordID int, userID int) (int, error) {
+	var roomID, status int
+	query := "SELECT room_id, status FROM record WHERE id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, recordID).Scan(&roomID, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return -1, fmt.Errorf("record does not exist")
+		}
+		return -1, err
+	}
+	member, err := CheckMember(ctx, roomID, userID)
+	if err != nil {
+		return -1, err
+	} else if !member {
+		return -1, fmt.Errorf("user not in room")
+	} else if status != 1 {
+		return -1, fmt.Errorf("record is not finished")
+	}
+	return roomID, nil
+}
+
+func AddReaction(ctx context.Context, recordID int, userID int, emoji string) error {
+	if _, err := checkRecordAccess(ctx, recordID, userID); err != nil {
+		return err
+	}
+	query := `
+		INSERT IGNORE INTO record_reaction (record_id, user_id, emoji, created_at)
+		VALUES (?, ?, ?, NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, recordID, userID, emoji)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 1 {
+		if err := notifyRecordReaction(ctx, recordID, userID, emoji); err != nil {
+			logger.WarnCtx(ctx, "Error notifying record reaction: "+err.Error())
+		}
+	}
+	return nil
+}
+
+func RemoveReaction(ctx context.Context, recordID int, userID int, emoji string) error {
+	query := "DELETE FROM record_reaction WHERE record_id = ? AND user_id = ? AND emoji = ?"
+	result, err := mariadb.DB.ExecContext(ctx, query, recordID, userID, emoji)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("reaction does not exist")
+	}
+	return nil
+}
+
+// GetReactions summarizes the reactions of a record for the user
+func GetReactions(ctx context.Context, recordID int, userID int) ([]reactionSummary, error) {
+	reactions := []reactionSummary{}
+	query := `
+		SELECT emoji, COUNT(*), SUM(user_id = ?)
+		FROM record_reaction
+		WHERE record_id = ?
+		GROUP BY emoji
+		ORDER BY COUNT(*) DESC, MIN(created_at)`
+	rows, err := mariadb.DB.QueryContext(ctx, query, userID, recordID)
+	if err != nil {
+		return reactions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reaction reactionSummary
+		if err := rows.Scan(&reaction.Emoji, &reaction.Count, &reaction.Reacted); err != nil {
+			return reactions, err
+		}
+		reactions = append(reactions, reaction)
+	}
+	return reactions, rows.Err()
+}
+
+func GetComments(ctx context.Context, recordID int, userID int) ([]Comment, error) {
+	comments := []Comment{}
+	if _, err := checkRecordAccess(ctx, recordID, userID); err != nil {
+		return comments, err
+	}
+	query := `
+		SELECT c.id, c.record_id, c.parent_id, c.user_id, u.username, u.avatar,
+			IF(c.deleted_at IS NULL, c.content, ''), UNIX_TIMESTAMP(c.created_at),
+			UNIX_TIMESTAMP(c.updated_at), c.deleted_at IS NOT NULL
+		FROM record_comment c
+		INNER JOIN user u ON c.user_id = u.id
+		WHERE c.record_id = ?
+		ORDER BY c.created_at, c.id`
+	rows, err := mariadb.DB.QueryContext(ctx, query, recordID)
+	if err != nil {
+		return comments, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var comment Comment
+		if err := rows.Scan(&comment.ID, &comment.RecordID, &comment.ParentID, &comment.UserID, &comment.Username, &comment.Avatar, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt, &comment.Deleted); err != nil {
+			return comments, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// AddComment comments on a record, or replies to parentID if it is set
+func AddComment(ctx context.Context, recordID int, userID int, parentID *int, content string) (int, error) {
+	if _, err := checkRecordAccess(ctx, recordID, userID); err != nil {
+		return -1, err
+	}
+	if parentID != nil {
+		query := "SELECT EXISTS(SELECT 1 FROM record_comment WHERE id = ? AND record_id = ?)"
+		var exists bool
+		err := mariadb.DB.QueryRowContext(ctx, query, *parentID, recordID).Scan(&exists)
+		if err != nil {
+			return -1, err
+		} else if !exists {
+			return -1, fmt.Errorf("parent comment does not exist")
+		}
+	}
+	query := `
+		INSERT INTO record_comment (record_id, user_id, parent_id, content, created_at)
+		VALUES (?, ?, ?, ?, NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, recordID, userID, parentID, content)
+	if err != nil {
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// EditComment changes the content of the user's own comment, as long as the
+// user is still a member of the room
+func EditComment(ctx context.Context, recordID int, commentID int, userID int, content string) error {
+	if _, err := checkRecordAccess(ctx, recordID, userID); err != nil {
+		return err
+	}
+	var authorID int
+	var deleted bool
+	query := "SELECT user_id, deleted_at IS NOT NULL FROM record_comment WHERE id = ? AND record_id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, commentID, recordID).Scan(&authorID, &deleted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("comment does not exist")
+		}
+		return err
+	} else if deleted {
+		return fmt.Errorf("comment does not exist")
+	} else if authorID != userID {
+		return fmt.Errorf("comment does not belong to user")
+	}
+	query = "UPDATE record_comment SET content = ?, updated_at = NOW() WHERE id = ?"
+	_, err = mariadb.DB.ExecContext(ctx, query, content, commentID)
+	return err
+}
+
+// DeleteComment deletes a comment by its author or by an admin of the room.
+// Comments are soft deleted so that their replies stay in the thread.
+func DeleteComment(ctx context.Context, recordID int, commentID int, userID int) error {
+	var authorID, roomID int
+	query := `
+		SELECT c.user_id, r.room_id
+		FROM record_comment c
+		INNER JOIN record r ON c.record_id = r.id
+		WHERE c.id = ? AND c.record_id = ? AND c.deleted_at IS NULL`
+	err := mariadb.DB.QueryRowContext(ctx, query, commentID, recordID).Scan(&authorID, &roomID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("comment does not exist")
+		}
+		return err
+	}
+	if authorID != userID {
+		admin, err := CheckAdmin(ctx, roomID, userID)
+		if err != nil {
+			return err
+		} else if !admin {
+			return fmt.Errorf("comment does not belong to user")
+		}
+	}
+	query = "UPDATE record_comment SET deleted_at = NOW() WHERE id = ?"
+	_, err = mariadb.DB.ExecContext(ctx, query, commentID)
+	return err
+}
@@ -0,0 +1,44 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestCheckRecordAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  [][]driver.Value
+		member  bool
+		want    int
+		wantErr string
+	}{
+		{name: "finished", record: [][]driver.Value{{int64(3), int64(1)}}, member: true, want: 3},
+		{name: "cooking", record: [][]driver.Value{{int64(3), int64(0)}}, member: true, wantErr: "record is not finished"},
+		{name: "abandoned", record: [][]driver.Value{{int64(3), int64(2)}}, member: true, wantErr: "record is not finished"},
+		{name: "not a member", record: [][]driver.Value{{int64(3), int64(1)}}, member: false, wantErr: "user not in room"},
+		{name: "missing", record: nil, member: true, wantErr: "record does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeDB(t,
+				fakeResult{"FROM record WHERE id = ?", tt.record},
+				fakeResult{"FROM room_user", [][]driver.Value{{tt.member}}},
+			)
+			got, err := checkRecordAccess(context.Background(), 7, 1)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("checkRecordAccess() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkRecordAccess() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("checkRecordAccess() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"pottogether/pkg/mariadb"
+	"time"
+)
+
+// Digest is the weekly summary of a user and their rooms
+type Digest struct {
+	UserID            int               `json:"userID"`
+	Username          string            `json:"username"`
+	Email             string            `json:"email"`
+	WeekStart         string            `json:"weekStart"`
+	WeekEnd           string            `json:"weekEnd"`
+	TotalTime         int               `json:"totalTime"`
+	PreviousTotalTime int               `json:"previousTotalTime"`
+	SessionCount      int               `json:"sessionCount"`
+	BestDay           *dateRecord       `json:"bestDay"`
+	Streak            int               `json:"streak"`
+	Ingredients       []ingredientStats `json:"ingredients"`
+	Rooms             []digestRoom      `json:"rooms"`
+}
+
+type digestRoom struct {
+	RoomID            int    `json:"roomID"`
+	Name              string `json:"name"`
+	TotalTime         int    `json:"totalTime"`
+	PreviousTotalTime int    `json:"previousTotalTime"`
+	// Rank of the user in the room for the week, 0 when they did not focus
+	Rank    int `json:"rank"`
+	Members int `json:"members"`
+}
+
+// DigestRecipient is a user due for the digest of the week starting at WeekStart
+type DigestRecipient struct {
+	UserID    int
+	WeekStart string
+}
+
+// GetDigestRecipients returns the users who opted in to the weekly digest and
+// whose local time is Monday at the given hour, with the last week to sum up
+func GetDigestRecipients(ctx context.Context, hour int) ([]DigestRecipient, error) {
+	recipients := []DigestRecipient{}
+	query := `
+		SELECT u.id, u.timezone
+		FROM user u
+		INNER JOIN notification_preference p ON p.user_id = u.id AND p.type = ? AND p.email = 1`
+	rows, err := mariadb.DB.QueryContext(ctx, query, NotifyWeeklyDigest)
+	if err != nil {
+		return recipients, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var timezone string
+		if err := rows.Scan(&id, &timezone); err != nil {
+			return recipients, err
+		}
+		now := time.Now().In(loadLocation(timezone))
+		if now.Weekday() != time.Monday || now.Hour() != hour {
+			continue
+		}
+		weekStart := now.AddDate(0, 0, -7).Format(dateLayout)
+		recipients = append(recipients, DigestRecipient{UserID: id, WeekStart: weekStart})
+	}
+	return recipients, nil
+}
+
+// MarkDigestSent claims the digest of the week for the user, returning false
+// if it was already sent
+func MarkDigestSent(ctx context.Context, userID int, weekStart string) (bool, error) {
+	query := "INSERT IGNORE INTO digest_sent (user_id, week_start, sent_at) VALUES (?, ?, NOW())"
+	result, err := mariadb.DB.ExecContext(ctx, query, userID, weekStart)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// UnmarkDigestSent releases the claim when sending failed, so it is retried
+func UnmarkDigestSent(ctx context.Context, userID int, weekStart string) error {
+	query := "DELETE FROM digest_sent WHERE user_id = ? AND week_start = ?"
+	_, err := mariadb.DB.ExecContext(ctx, query, userID, weekStart)
+	return err
+}
+
+// GetWeeklyDigest sums up the week starting on the Monday weekStart, reusing
+// the rollup and statistics queries
+func GetWeeklyDigest(ctx context.Context, userID int, weekStart string) (Digest, error) {
+	result := Digest{UserID: userID, WeekStart: weekStart, Rooms: []digestRoom{}}
+	start, err := time.Parse(dateLayout, weekStart)
+	if err != nil {
+		return result, err
+	}
+	end := start.AddDate(0, 0, 6)
+	result.WeekEnd = end.Format(dateLayout)
+	query := "SELECT username, email FROM user WHERE id = ?"
+	if err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&result.Username, &result.Email); err != nil {
+		return result, err
+	}
+	// Daily totals of finished records, on the user's local days like the
+	// room totals below
+	week, err := getDailyTotals(ctx, userID, weekStart, result.WeekEnd)
+	if err != nil {
+		return result, err
+	}
+	for i, day := range week {
+		result.TotalTime += day.Length
+		if result.BestDay == nil || day.Length > result.BestDay.Length {
+			result.BestDay = &week[i]
+		}
+	}
+	previous, err := getDailyTotals(ctx, userID, start.AddDate(0, 0, -7).Format(dateLayout), start.AddDate(0, 0, -1).Format(dateLayout))
+	if err != nil {
+		return result, err
+	}
+	for _, day := range previous {
+		result.PreviousTotalTime += day.Length
+	}
+	// Sessions and ingredients
+	stats, err := GetUserStats(ctx, userID, StatsRange{From: weekStart, To: result.WeekEnd, Granularity: "day"})
+	if err != nil {
+		return result, err
+	}
+	result.SessionCount = stats.Summary.SessionCount
+	result.Ingredients = stats.Ingredients
+	// Streak as of the end of the week
+	if result.Streak, err = getStreak(ctx, userID, end); err != nil {
+		return result, err
+	}
+	// Rooms
+	query = `
+		SELECT r.id, r.roomname,
+			(SELECT COUNT(*) FROM room_user WHERE room_id = r.id),
+			(SELECT COALESCE(SUM(total_time), 0) FROM focus_daily WHERE room_id = r.id AND day BETWEEN ? AND ?),
+			(SELECT COALESCE(SUM(total_time), 0) FROM focus_daily WHERE room_id = r.id AND day BETWEEN ? AND ?)
+		FROM room r
+		INNER JOIN room_user ru ON ru.room_id = r.id AND ru.user_id = ?
+		ORDER BY r.id`
+	prevStart := start.AddDate(0, 0, -7).Format(dateLayout)
+	prevEnd := start.AddDate(0, 0, -1).Format(dateLayout)
+	rows, err := mariadb.DB.QueryContext(ctx, query, weekStart, result.WeekEnd, prevStart, prevEnd, userID)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var room digestRoom
+		if err := rows.Scan(&room.RoomID, &room.Name, &room.Members, &room.TotalTime, &room.PreviousTotalTime); err != nil {
+			return result, err
+		}
+		result.Rooms = append(result.Rooms, room)
+	}
+	rows.Close()
+	for i := range result.Rooms {
+		rank, err := getRoomRank(ctx, result.Rooms[i].RoomID, userID, weekStart, result.WeekEnd)
+		if err != nil {
+			return result, err
+		}
+		result.Rooms[i].Rank = rank
+	}
+	return result, nil
+}
+
+// getDailyTotals returns the user's focus time per day between the two dates
+// from the focus_daily rollup
+func getDailyTotals(ctx context.Context, userID int, from string, to string) ([]dateRecord, error) {
+	days := []dateRecord{}
+	query := `
+		SELECT day, SUM(total_time)
+		FROM focus_daily
+		WHERE user_id = ? AND day BETWEEN ? AND ?
+		GROUP BY day
+		ORDER BY day`
+	rows, err := mariadb.DB.QueryContext(ctx, query, userID, from, to)
+	if err != nil {
+		return days, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day dateRecord
+		if err := rows.Scan(&day.Date, &day.Length); err != nil {
+			return days, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// getRoomRank returns the rank of the user by focus time in the room between
+// the two dates, or 0 if they did not focus
+func getRoomRank(ctx context.Context, roomID int, userID int, from string, to string) (int, error) {
+	query := `
+		SELECT rnk FROM (
+			SELECT user_id, RANK() OVER (ORDER BY SUM(total_time) DESC) AS rnk
+			FROM focus_daily
+			WHERE room_id = ? AND day BETWEEN ? AND ?
+			GROUP BY user_id
+		) ranked
+		WHERE user_id = ?`
+	var rank int
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID, from, to, userID).Scan(&rank)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return rank, nil
+}
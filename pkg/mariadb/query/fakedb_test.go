@@ -0,0 +1,91 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"pottogether/pkg/mariadb"
+	"strings"
+	"testing"
+)
+
+// fakeResult answers the queries containing match with rows
+type fakeResult struct {
+	match string
+	rows  [][]driver.Value
+}
+
+// useFakeDB replaces the database with one answering queries from results,
+// the first result whose match the query contains is used
+func useFakeDB(t *testing.T, results ...fakeResult) {
+	t.Helper()
+	previous := mariadb.DB
+	mariadb.DB = sql.OpenDB(fakeConnector{results})
+	t.Cleanup(func() {
+		mariadb.DB.Close()
+		mariadb.DB = previous
+	})
+}
+
+type fakeConnector struct {
+	results []fakeResult
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn(c), nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	results []fakeResult
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	for _, result := range c.results {
+		if strings.Contains(query, result.match) {
+			return &fakeRows{values: result.rows}, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.values) == 0 {
+		return nil
+	}
+	return make([]string, len(r.values[0]))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
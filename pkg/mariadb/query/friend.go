@@ -0,0 +1,247 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/pkg/mariadb"
+)
+
+// friendIDs selects the ids of a user's friends, taking the user id three times
+const friendIDs = `
+	SELECT IF(requester_id = ?, addressee_id, requester_id) FROM friendship
+	WHERE (requester_id = ? OR addressee_id = ?) AND status = 'accepted'`
+
+type Friend struct {
+	ID       int    `json:"userID"`
+	Username string `json:"username"`
+	Avatar   *int   `json:"avatar"`
+	Since    int    `json:"since"`
+}
+
+type FriendRequests struct {
+	Incoming []Friend `json:"incoming"`
+	Outgoing []Friend `json:"outgoing"`
+}
+
+type FriendActivity struct {
+	ID          int    `json:"userID"`
+	Username    string `json:"username"`
+	Avatar      *int   `json:"avatar"`
+	RecordID    int    `json:"recordID"`
+	RoomID      int    `json:"roomID"`
+	Ingredient  string `json:"ingredient"`
+	CookingTime int    `json:"cookingTime"`
+}
+
+func CheckFriend(ctx context.Context, userID int, otherID int) (bool, error) {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM friendship
+		WHERE ((requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?))
+		AND status = 'accepted')`
+	var exists bool
+	err := mariadb.DB.QueryRowContext(ctx, query, userID, otherID, otherID, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// CanViewProfile checks the profile visibility setting of userID against the viewer
+func CanViewProfile(ctx context.Context, viewerID int, userID int) (bool, error) {
+	if viewerID == userID {
+		return true, nil
+	}
+	var visibility string
+	query := "SELECT profile_visibility FROM user WHERE id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&visibility)
+	if err != nil {
+		return false, err
+	}
+	if visibility == "public" {
+		return true, nil
+	}
+	return CheckFriend(ctx, viewerID, userID)
+}
+
+// SendFriendRequest sends a friend request, or accepts the pending request
+// in the other direction if there is one
+func SendFriendRequest(ctx context.Context, userID int, targetID int) error {
+	if userID == targetID {
+		return fmt.Errorf("cannot add yourself")
+	}
+	exists, err := CheckUser(ctx, targetID)
+	if err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("user does not exist")
+	}
+	// The pair has a single row whatever its direction, a request crossing
+	// the pending one in the other direction accepts it in the same statement
+	query := `
+		INSERT INTO friendship (requester_id, addressee_id, status, created_at)
+		VALUES (?, ?, 'pending', NOW())
+		ON DUPLICATE KEY UPDATE
+			responded_at = IF(status = 'pending' AND requester_id = VALUES(addressee_id), NOW(), responded_at),
+			status = IF(status = 'pending' AND requester_id = VALUES(addressee_id), 'accepted', status)`
+	result, err := mariadb.DB.ExecContext(ctx, query, userID, targetID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected > 0 {
+		return nil
+	}
+	// Nothing changed, tell why
+	var status string
+	query = `
+		SELECT status FROM friendship
+		WHERE (requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)`
+	err = mariadb.DB.QueryRowContext(ctx, query, userID, targetID, targetID, userID).Scan(&status)
+	if err != nil {
+		return err
+	} else if status == "accepted" {
+		return fmt.Errorf("already friends")
+	}
+	return fmt.Errorf("friend request already sent")
+}
+
+// RespondFriendRequest accepts or declines the pending request from requesterID
+func RespondFriendRequest(ctx context.Context, userID int, requesterID int, accept bool) error {
+	var result sql.Result
+	var err error
+	if accept {
+		query := `
+			UPDATE friendship SET status = 'accepted', responded_at = NOW()
+			WHERE requester_id = ? AND addressee_id = ? AND status = 'pending'`
+		result, err = mariadb.DB.ExecContext(ctx, query, requesterID, userID)
+	} else {
+		query := `
+			DELETE FROM friendship
+			WHERE requester_id = ? AND addressee_id = ? AND status = 'pending'`
+		result, err = mariadb.DB.ExecContext(ctx, query, requesterID, userID)
+	}
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("friend request does not exist")
+	}
+	return nil
+}
+
+// CancelFriendRequest withdraws a pending request sent to targetID
+func CancelFriendRequest(ctx context.Context, userID int, targetID int) error {
+	query := `
+		DELETE FROM friendship
+		WHERE requester_id = ? AND addressee_id = ? AND status = 'pending'`
+	result, err := mariadb.DB.ExecContext(ctx, query, userID, targetID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("friend request does not exist")
+	}
+	return nil
+}
+
+func RemoveFriend(ctx context.Context, userID int, friendID int) error {
+	query := `
+		DELETE FROM friendship
+		WHERE ((requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?))
+		AND status = 'accepted'`
+	result, err := mariadb.DB.ExecContext(ctx, query, userID, friendID, friendID, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("not friends")
+	}
+	return nil
+}
+
+func GetFriends(ctx context.Context, userID int) ([]Friend, error) {
+	query := `
+		SELECT u.id, u.username, u.avatar, UNIX_TIMESTAMP(f.responded_at)
+		FROM friendship f
+		INNER JOIN user u ON u.id = IF(f.requester_id = ?, f.addressee_id, f.requester_id)
+		WHERE (f.requester_id = ? OR f.addressee_id = ?) AND f.status = 'accepted'
+		ORDER BY u.username`
+	return getFriendList(ctx, query, userID, userID, userID)
+}
+
+func GetFriendRequests(ctx context.Context, userID int) (FriendRequests, error) {
+	var result FriendRequests
+	var err error
+	query := `
+		SELECT u.id, u.username, u.avatar, UNIX_TIMESTAMP(f.created_at)
+		FROM friendship f
+		INNER JOIN user u ON u.id = f.requester_id
+		WHERE f.addressee_id = ? AND f.status = 'pending'
+		ORDER BY f.created_at DESC`
+	result.Incoming, err = getFriendList(ctx, query, userID)
+	if err != nil {
+		return result, err
+	}
+	query = `
+		SELECT u.id, u.username, u.avatar, UNIX_TIMESTAMP(f.created_at)
+		FROM friendship f
+		INNER JOIN user u ON u.id = f.addressee_id
+		WHERE f.requester_id = ? AND f.status = 'pending'
+		ORDER BY f.created_at DESC`
+	result.Outgoing, err = getFriendList(ctx, query, userID)
+	return result, err
+}
+
+func getFriendList(ctx context.Context, query string, args ...interface{}) ([]Friend, error) {
+	friends := []Friend{}
+	rows, err := mariadb.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return friends, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var friend Friend
+		if err := rows.Scan(&friend.ID, &friend.Username, &friend.Avatar, &friend.Since); err != nil {
+			return friends, err
+		}
+		friends = append(friends, friend)
+	}
+	return friends, nil
+}
+
+// GetFriendActivity returns the friends who are cooking right now
+func GetFriendActivity(ctx context.Context, userID int) ([]FriendActivity, error) {
+	activity := []FriendActivity{}
+	query := `
+		SELECT u.id, u.username, u.avatar, r.id, r.room_id, i.name, TIMESTAMPDIFF(SECOND, r.created_at, NOW())
+		FROM record r
+		INNER JOIN user u ON r.user_id = u.id
+		INNER JOIN ingredient i ON r.ingredient_id = i.id
+		WHERE ` + activeRecord + ` AND r.user_id IN (` + friendIDs + `)
+		ORDER BY r.created_at DESC`
+	rows, err := mariadb.DB.QueryContext(ctx, query, append(activeRecordArgs(), userID, userID, userID)...)
+	if err != nil {
+		return activity, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a FriendActivity
+		if err := rows.Scan(&a.ID, &a.Username, &a.Avatar, &a.RecordID, &a.RoomID, &a.Ingredient, &a.CookingTime); err != nil {
+			return activity, err
+		}
+		activity = append(activity, a)
+	}
+	return activity, nil
+}
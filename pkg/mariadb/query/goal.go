@@ -0,0 +1,242 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"pottogether/pkg/mariadb"
+	"time"
+)
+
+type Goals struct {
+	Progress []goalProgress   `json:"progress"`
+	History  []goalCompletion `json:"history"`
+}
+
+type goalProgress struct {
+	Period      string `json:"period"`
+	PeriodStart string `json:"periodStart"`
+	Target      int    `json:"target"`
+	Progress    int    `json:"progress"`
+	Completed   bool   `json:"completed"`
+}
+
+type goalCompletion struct {
+	Period      string `json:"period"`
+	PeriodStart string `json:"periodStart"`
+	Target      int    `json:"target"`
+	CompletedAt int    `json:"completedAt"`
+}
+
+// SetUserGoal sets the user's daily or weekly goal, removing it if target is 0
+func SetUserGoal(ctx context.Context, userID int, period string, target int) error {
+	if period != "daily" && period != "weekly" {
+		return fmt.Errorf("invalid period")
+	}
+	if target == 0 {
+		query := "DELETE FROM user_goal WHERE user_id = ? AND period = ?"
+		_, err := mariadb.DB.ExecContext(ctx, query, userID, period)
+		return err
+	}
+	query := `
+		INSERT INTO user_goal (user_id, period, target)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE target = VALUES(target)`
+	_, err := mariadb.DB.ExecContext(ctx, query, userID, period, target)
+	return err
+}
+
+// SetRoomGoal sets the room's shared weekly goal, removing it if target is 0.
+// Only admins may set it, its weeks start in the admin's timezone.
+func SetRoomGoal(ctx context.Context, roomID int, userID int, period string, target int) error {
+	if period != "weekly" {
+		return fmt.Errorf("invalid period")
+	}
+	admin, err := CheckAdmin(ctx, roomID, userID)
+	if err != nil {
+		return err
+	} else if !admin {
+		return fmt.Errorf("user is not a room admin")
+	}
+	if target == 0 {
+		query := "DELETE FROM room_goal WHERE room_id = ? AND period = ?"
+		_, err := mariadb.DB.ExecContext(ctx, query, roomID, period)
+		return err
+	}
+	query := `
+		INSERT INTO room_goal (room_id, period, target, timezone)
+		VALUES (?, ?, ?, (SELECT timezone FROM user WHERE id = ?))
+		ON DUPLICATE KEY UPDATE target = VALUES(target), timezone = VALUES(timezone)`
+	_, err = mariadb.DB.ExecContext(ctx, query, roomID, period, target, userID)
+	return err
+}
+
+func GetUserGoals(ctx context.Context, userID int) (Goals, error) {
+	var result Goals
+	var err error
+	result.Progress, err = getUserGoalProgress(ctx, userID)
+	if err != nil {
+		return result, err
+	}
+	result.History, err = getGoalHistory(ctx, "user", userID)
+	return result, err
+}
+
+// GetRoomGoals returns the progress and history of the room's goals. Private
+// rooms are only shown to their members.
+func GetRoomGoals(ctx context.Context, roomID int, userID int) (Goals, error) {
+	var result Goals
+	// Check if room exists
+	query := "SELECT EXISTS(SELECT 1 FROM room WHERE id = ?)"
+	var exists bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&exists)
+	if err != nil {
+		return result, err
+	} else if !exists {
+		return result, fmt.Errorf("room does not exist")
+	}
+	visible, err := CheckRoomVisible(ctx, roomID, userID)
+	if err != nil {
+		return result, err
+	} else if !visible {
+		return result, fmt.Errorf("user not in room")
+	}
+	result.Progress, err = getRoomGoalProgress(ctx, roomID)
+	if err != nil {
+		return result, err
+	}
+	result.History, err = getGoalHistory(ctx, "room", roomID)
+	return result, err
+}
+
+// getUserGoalProgress measures the user's goals against the current day and
+// week in their own timezone
+func getUserGoalProgress(ctx context.Context, userID int) ([]goalProgress, error) {
+	var timezone string
+	query := "SELECT timezone FROM user WHERE id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&timezone)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+		SELECT g.period, g.target, COALESCE(SUM(f.total_time), 0)
+		FROM user_goal g
+		LEFT JOIN focus_daily f ON f.user_id = g.user_id
+			AND f.day >= IF(g.period = 'daily', ?, ?)
+		WHERE g.user_id = ?
+		GROUP BY g.period, g.target`
+	return getGoalProgress(ctx, query, time.Now().In(loadLocation(timezone)), userID)
+}
+
+// getRoomGoalProgress measures the room's goals against the current week in
+// the timezone of the goal
+func getRoomGoalProgress(ctx context.Context, roomID int) ([]goalProgress, error) {
+	var timezone string
+	query := "SELECT COALESCE(MAX(timezone), 'UTC') FROM room_goal WHERE room_id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&timezone)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+		SELECT g.period, g.target, COALESCE(SUM(f.total_time), 0)
+		FROM room_goal g
+		LEFT JOIN focus_daily f ON f.room_id = g.room_id
+			AND f.day >= IF(g.period = 'daily', ?, ?)
+		WHERE g.room_id = ?
+		GROUP BY g.period, g.target`
+	return getGoalProgress(ctx, query, time.Now().In(loadLocation(timezone)), roomID)
+}
+
+// getGoalProgress runs a progress query taking the start of the day, the start
+// of the week and the owner id
+func getGoalProgress(ctx context.Context, query string, now time.Time, id int) ([]goalProgress, error) {
+	progress := []goalProgress{}
+	today, _ := periodStartAt("daily", now)
+	week, _ := periodStartAt("weekly", now)
+	rows, err := mariadb.DB.QueryContext(ctx, query, today, week, id)
+	if err != nil {
+		return progress, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var goal goalProgress
+		if err := rows.Scan(&goal.Period, &goal.Target, &goal.Progress); err != nil {
+			return progress, err
+		}
+		goal.PeriodStart = week
+		if goal.Period == "daily" {
+			goal.PeriodStart = today
+		}
+		goal.Completed = goal.Progress >= goal.Target
+		progress = append(progress, goal)
+	}
+	return progress, rows.Err()
+}
+
+func getGoalHistory(ctx context.Context, ownerType string, ownerID int) ([]goalCompletion, error) {
+	history := []goalCompletion{}
+	query := `
+		SELECT period, period_start, target, UNIX_TIMESTAMP(completed_at)
+		FROM goal_completion
+		WHERE owner_type = ? AND owner_id = ?
+		ORDER BY period_start DESC, period
+		LIMIT 50`
+	rows, err := mariadb.DB.QueryContext(ctx, query, ownerType, ownerID)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var completion goalCompletion
+		if err := rows.Scan(&completion.Period, &completion.PeriodStart, &completion.Target, &completion.CompletedAt); err != nil {
+			return history, err
+		}
+		history = append(history, completion)
+	}
+	return history, rows.Err()
+}
+
+// recordGoalCompletions stores the goals of the user and room that are now reached.
+// Each goal is only recorded once per period.
+func recordGoalCompletions(ctx context.Context, userID int, roomID int) error {
+	userGoals, err := getUserGoalProgress(ctx, userID)
+	if err != nil {
+		return err
+	}
+	roomGoals, err := getRoomGoalProgress(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT IGNORE INTO goal_completion (owner_type, owner_id, period, period_start, target, completed_at)
+		VALUES (?, ?, ?, ?, ?, NOW())`
+	for _, goal := range userGoals {
+		if goal.Completed {
+			if err := completeGoal(ctx, query, "user", userID, goal); err != nil {
+				return err
+			}
+		}
+	}
+	for _, goal := range roomGoals {
+		if goal.Completed {
+			if err := completeGoal(ctx, query, "room", roomID, goal); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// completeGoal stores the completion and notifies the first time it is reached
+func completeGoal(ctx context.Context, query string, ownerType string, ownerID int, goal goalProgress) error {
+	result, err := mariadb.DB.ExecContext(ctx, query, ownerType, ownerID, goal.Period, goal.PeriodStart, goal.Target)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 1 {
+		return notifyGoalReached(ctx, ownerType, ownerID, goal)
+	}
+	return nil
+}
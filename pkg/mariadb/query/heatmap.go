@@ -0,0 +1,128 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"pottogether/pkg/mariadb"
+	"time"
+)
+
+type Heatmap struct {
+	Year         int          `json:"year"`
+	Timezone     string       `json:"timezone"`
+	TotalMinutes int          `json:"totalMinutes"`
+	ActiveDays   int          `json:"activeDays"`
+	Days         []heatmapDay `json:"days"`
+}
+
+type heatmapDay struct {
+	Date    string `json:"date"`
+	Minutes int    `json:"minutes"`
+}
+
+// loadLocation falls back to UTC for unknown timezones
+func loadLocation(timezone string) *time.Location {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// utcOffset returns the current UTC offset of the timezone, e.g. "+08:00"
+func utcOffset(timezone string) string {
+	return time.Now().In(loadLocation(timezone)).Format("-07:00")
+}
+
+// localTime returns the SQL expression converting the datetime column to the
+// timezone, along with its arguments. CONVERT_TZ returns NULL when the
+// database has no named zones loaded, today's offset is used then.
+func localTime(column string, timezone string) (string, []interface{}) {
+	expr := "COALESCE(CONVERT_TZ(" + column + ", @@session.time_zone, ?), CONVERT_TZ(" + column + ", @@session.time_zone, ?))"
+	return expr, []interface{}{timezone, utcOffset(timezone)}
+}
+
+// localDate returns today's date in the given timezone
+func localDate(timezone string) string {
+	return time.Now().In(loadLocation(timezone)).Format(dateLayout)
+}
+
+// GetUserHeatmap returns the user's focus minutes for every day of the year,
+// read from the focus_daily rollup which is already bucketed in their timezone
+func GetUserHeatmap(ctx context.Context, userID int, year int) (Heatmap, error) {
+	result := Heatmap{Year: year}
+	query := "SELECT timezone FROM user WHERE id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&result.Timezone)
+	if err != nil {
+		return result, err
+	}
+	query = `
+		SELECT day, SUM(total_time)
+		FROM focus_daily
+		WHERE user_id = ? AND day BETWEEN ? AND ?
+		GROUP BY day`
+	return fillHeatmap(ctx, result, query, userID)
+}
+
+// GetRoomHeatmap returns the room's focus minutes for every day of the year,
+// with each member's time counted on their own local day. Private rooms are
+// only shown to their members.
+func GetRoomHeatmap(ctx context.Context, roomID int, userID int, year int) (Heatmap, error) {
+	result := Heatmap{Year: year}
+	// Check if room exists
+	query := "SELECT EXISTS(SELECT 1 FROM room WHERE id = ?)"
+	var exists bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&exists)
+	if err != nil {
+		return result, err
+	} else if !exists {
+		return result, fmt.Errorf("room does not exist")
+	}
+	visible, err := CheckRoomVisible(ctx, roomID, userID)
+	if err != nil {
+		return result, err
+	} else if !visible {
+		return result, fmt.Errorf("user not in room")
+	}
+	query = `
+		SELECT day, SUM(total_time)
+		FROM focus_daily
+		WHERE room_id = ? AND day BETWEEN ? AND ?
+		GROUP BY day`
+	return fillHeatmap(ctx, result, query, roomID)
+}
+
+// fillHeatmap runs the per-day query and expands it to one entry per calendar day
+func fillHeatmap(ctx context.Context, result Heatmap, query string, id int) (Heatmap, error) {
+	start := time.Date(result.Year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, -1)
+	rows, err := mariadb.DB.QueryContext(ctx, query, id, start.Format(dateLayout), end.Format(dateLayout))
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	// time_interval is stored in seconds
+	seconds := map[string]int{}
+	for rows.Next() {
+		var day string
+		var total int
+		if err := rows.Scan(&day, &total); err != nil {
+			return result, err
+		}
+		seconds[day] = total
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	result.Days = make([]heatmapDay, 0, end.YearDay())
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format(dateLayout)
+		day := heatmapDay{Date: date, Minutes: seconds[date] / 60}
+		if seconds[date] > 0 {
+			result.ActiveDays++
+		}
+		result.TotalMinutes += day.Minutes
+		result.Days = append(result.Days, day)
+	}
+	return result, nil
+}
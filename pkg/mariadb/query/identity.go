@@ -0,0 +1,87 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"pottogether/pkg/mariadb"
+)
+
+// GetIdentityUser returns the user linked to the provider account and its
+// email, -1 if there is none. The login time of the identity is updated.
+func GetIdentityUser(ctx context.Context, provider string, subject string) (int, string, error) {
+	var id int
+	var email string
+	query := `
+		SELECT u.id, u.email FROM users_identity ui
+		INNER JOIN user u ON ui.user_id = u.id
+		WHERE ui.provider = ? AND ui.subject = ?`
+	err := mariadb.DB.QueryRowContext(ctx, query, provider, subject).Scan(&id, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return -1, "", nil
+		}
+		return -1, "", err
+	}
+	query = "UPDATE users_identity SET last_login_at = NOW() WHERE provider = ? AND subject = ?"
+	if _, err := mariadb.DB.ExecContext(ctx, query, provider, subject); err != nil {
+		return -1, "", err
+	}
+	return id, email, nil
+}
+
+// GetUserIDByEmail returns the id of the user with the email, -1 if there is none
+func GetUserIDByEmail(ctx context.Context, email string) (int, error) {
+	var id int
+	query := "SELECT id FROM user WHERE email = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, email).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return -1, nil
+		}
+		return -1, err
+	}
+	return id, nil
+}
+
+// LinkIdentity links the provider account to an existing user
+func LinkIdentity(ctx context.Context, userID int, provider string, subject string, email string) error {
+	return linkIdentity(ctx, mariadb.DB, userID, provider, subject, email)
+}
+
+func linkIdentity(ctx context.Context, db execer, userID int, provider string, subject string, email string) error {
+	query := `
+		INSERT INTO users_identity (user_id, provider, subject, email, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, NOW(), NOW())`
+	_, err := db.ExecContext(ctx, query, userID, provider, subject, email)
+	return err
+}
+
+// SignUpWithIdentity registers a user without password, linked to the
+// provider account
+func SignUpWithIdentity(ctx context.Context, user User, provider string, subject string) (int, error) {
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, err
+	}
+	query := `
+		INSERT INTO user (avatar, email, username, password, created_at, level, total_time)
+		VALUES (?, ?, ?, '', NOW(), 1, 0)`
+	result, err := tx.ExecContext(ctx, query, user.Avatar, user.Email, user.Name)
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	if err := linkIdentity(ctx, tx, int(id), provider, subject, user.Email); err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
@@ -1,25 +1,28 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"pottogether/pkg/mariadb"
 )
 
 type Ingredient struct {
-	ID          int    `json:"ingredientID"`
-	Name        string `json:"name"`
-	Image       string `json:"image"`
-	Interval    int    `json:"interval"`
-	Requirement string `json:"requirement"`
+	ID             int    `json:"ingredientID"`
+	Name           string `json:"name"`
+	Image          string `json:"image"`
+	ImageMedium    string `json:"imageMedium"`
+	ImageThumbnail string `json:"imageThumbnail"`
+	Interval       int    `json:"interval"`
+	Requirement    string `json:"requirement"`
 }
 
 // get all ingredients
-func GetIngredients() ([]Ingredient, error) {
+func GetIngredients(ctx context.Context) ([]Ingredient, error) {
 	query := `
-		SELECT id, name, image, time_interval, requirement
+		SELECT id, name, image, COALESCE(image_medium, image), COALESCE(image_thumbnail, image), time_interval, requirement
 		FROM ingredient
 	`
-	rows, err := mariadb.DB.Query(query)
+	rows, err := mariadb.DB.QueryContext(ctx, query)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return []Ingredient{}, nil
@@ -30,7 +33,7 @@ func GetIngredients() ([]Ingredient, error) {
 	var ingredients []Ingredient
 	for rows.Next() {
 		var ingredient Ingredient
-		err = rows.Scan(&ingredient.ID, &ingredient.Name, &ingredient.Image, &ingredient.Interval, &ingredient.Requirement)
+		err = rows.Scan(&ingredient.ID, &ingredient.Name, &ingredient.Image, &ingredient.ImageMedium, &ingredient.ImageThumbnail, &ingredient.Interval, &ingredient.Requirement)
 		if err != nil {
 			return nil, err
 		}
@@ -39,12 +42,12 @@ func GetIngredients() ([]Ingredient, error) {
 	return ingredients, nil
 }
 
-func AddIngredient(ingredient Ingredient) (int, error) {
+func AddIngredient(ctx context.Context, ingredient Ingredient) (int, error) {
 	query := `
-		INSERT INTO ingredient (name, image, time_interval, requirement)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO ingredient (name, image, image_medium, image_thumbnail, time_interval, requirement)
+		VALUES (?, ?, ?, ?, ?, ?)
 	`
-	result, err := mariadb.DB.Exec(query, ingredient.Name, ingredient.Image, ingredient.Interval, ingredient.Requirement)
+	result, err := mariadb.DB.ExecContext(ctx, query, ingredient.Name, ingredient.Image, ingredient.ImageMedium, ingredient.ImageThumbnail, ingredient.Interval, ingredient.Requirement)
 	if err != nil {
 		return -1, err
 	}
@@ -52,5 +55,8 @@ func AddIngredient(ingredient Ingredient) (int, error) {
 	if err != nil {
 		return -1, err
 	}
+	if err := attachMedia(ctx, mariadb.DB, "ingredient", int(id), ingredient.Image, ingredient.ImageMedium, ingredient.ImageThumbnail); err != nil {
+		return -1, err
+	}
 	return int(id), nil
 }
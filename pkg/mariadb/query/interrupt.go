@@ -0,0 +1,202 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/pkg/mariadb"
+	"time"
+)
+
+type recordInterrupt struct {
+	ID         int     `json:"interruptID"`
+	OccurredAt int     `json:"occurredAt"`
+	Reason     *string `json:"reason"`
+}
+
+type focusQuality struct {
+	Interrupts        []recordInterrupt `json:"interrupts"`
+	InterruptsPerHour float64           `json:"interruptsPerHour"`
+	LongestStretch    int               `json:"longestStretch"`
+}
+
+type hourlyStats struct {
+	Hour              int     `json:"hour"`
+	TotalTime         int     `json:"totalTime"`
+	SessionCount      int     `json:"sessionCount"`
+	InterruptCount    int     `json:"interruptCount"`
+	InterruptsPerHour float64 `json:"interruptsPerHour"`
+}
+
+// interruptsPerHour normalizes an interrupt count by focus time in seconds
+func interruptsPerHour(count int, seconds int) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(count) * 3600 / float64(seconds)
+}
+
+// AddInterrupt records an interruption of a cooking record. occurredAt is a unix
+// timestamp between the start of the record and now, or 0 for now.
+func AddInterrupt(ctx context.Context, recordID int, userID int, reason *string, occurredAt int) (int, error) {
+	// Begin transaction
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, err
+	}
+	// Check record owner and status, locking the record so that it cannot be
+	// finished before the interrupt is added
+	var ownerID, status, createdAt int
+	query := "SELECT user_id, status, UNIX_TIMESTAMP(created_at) FROM record WHERE id = ? FOR UPDATE"
+	err = tx.QueryRowContext(ctx, query, recordID).Scan(&ownerID, &status, &createdAt)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return -1, fmt.Errorf("record does not exist")
+		}
+		return -1, err
+	} else if ownerID != userID {
+		tx.Rollback()
+		return -1, fmt.Errorf("record does not belong to user")
+	} else if status != 0 {
+		tx.Rollback()
+		return -1, fmt.Errorf("record is not cooking")
+	} else if occurredAt != 0 && (occurredAt < createdAt || occurredAt > int(time.Now().Unix())) {
+		tx.Rollback()
+		return -1, fmt.Errorf("occurredAt is outside the record")
+	}
+	query = `
+		INSERT INTO record_interrupt (record_id, occurred_at, reason)
+		VALUES (?, IF(? = 0, NOW(), FROM_UNIXTIME(?)), ?)`
+	result, err := tx.ExecContext(ctx, query, recordID, occurredAt, occurredAt, reason)
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	// Keep the record counter in sync
+	query = "UPDATE record SET interrupt = interrupt + 1 WHERE id = ?"
+	_, err = tx.ExecContext(ctx, query, recordID)
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	// Commit transaction
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// getFocusQuality derives the interrupt metrics of a single record. A record
+// still cooking is measured up to now.
+func getFocusQuality(ctx context.Context, recordID int) (focusQuality, error) {
+	result := focusQuality{Interrupts: []recordInterrupt{}}
+	var start, end, interval, status int
+	query := `
+		SELECT UNIX_TIMESTAMP(created_at), UNIX_TIMESTAMP(IF(status = 0, NOW(), finish_time)), time_interval, status
+		FROM record WHERE id = ?`
+	err := mariadb.DB.QueryRowContext(ctx, query, recordID).Scan(&start, &end, &interval, &status)
+	if err != nil {
+		return result, err
+	}
+	query = `
+		SELECT id, UNIX_TIMESTAMP(occurred_at), reason
+		FROM record_interrupt
+		WHERE record_id = ?
+		ORDER BY occurred_at`
+	rows, err := mariadb.DB.QueryContext(ctx, query, recordID)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	last := start
+	for rows.Next() {
+		var interrupt recordInterrupt
+		if err := rows.Scan(&interrupt.ID, &interrupt.OccurredAt, &interrupt.Reason); err != nil {
+			return result, err
+		}
+		if interrupt.OccurredAt-last > result.LongestStretch {
+			result.LongestStretch = interrupt.OccurredAt - last
+		}
+		last = interrupt.OccurredAt
+		result.Interrupts = append(result.Interrupts, interrupt)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	if end-last > result.LongestStretch {
+		result.LongestStretch = end - last
+	}
+	if status == 0 {
+		interval = end - start
+	}
+	result.InterruptsPerHour = interruptsPerHour(len(result.Interrupts), interval)
+	return result, nil
+}
+
+// getLongestStretch returns the longest gap between interrupts across the
+// finished records matching where, bounded by each record's start and finish
+func getLongestStretch(ctx context.Context, where string, args ...interface{}) (int, error) {
+	query := `
+		SELECT COALESCE(MAX(gap), 0) FROM (
+			SELECT TIMESTAMPDIFF(SECOND, LAG(t) OVER (PARTITION BY record_id ORDER BY t), t) AS gap
+			FROM (
+				SELECT id AS record_id, created_at AS t FROM record WHERE ` + where + `
+				UNION ALL
+				SELECT id, finish_time FROM record WHERE ` + where + `
+				UNION ALL
+				SELECT ri.record_id, ri.occurred_at FROM record_interrupt ri
+				INNER JOIN record ON ri.record_id = record.id
+				WHERE ` + where + `
+			) points
+		) gaps`
+	allArgs := append(append(append([]interface{}{}, args...), args...), args...)
+	var longest int
+	err := mariadb.DB.QueryRowContext(ctx, query, allArgs...).Scan(&longest)
+	if err != nil {
+		return 0, err
+	}
+	return longest, nil
+}
+
+// getHourlyStats buckets the finished records matching where by the local hour
+// they started in. timezone is an IANA name, so that each record is bucketed
+// with the offset of its own date.
+func getHourlyStats(ctx context.Context, timezone string, where string, args ...interface{}) ([]hourlyStats, error) {
+	hours := make([]hourlyStats, 24)
+	for i := range hours {
+		hours[i].Hour = i
+	}
+	localCreated, localArgs := localTime("created_at", timezone)
+	hourExpr := "HOUR(" + localCreated + ")"
+	args = append(localArgs, args...)
+	query := `
+		SELECT ` + hourExpr + ` AS hour, SUM(time_interval), COUNT(*), SUM(interrupt)
+		FROM record
+		WHERE ` + where + `
+		GROUP BY hour`
+	rows, err := mariadb.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return hours, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h hourlyStats
+		if err := rows.Scan(&h.Hour, &h.TotalTime, &h.SessionCount, &h.InterruptCount); err != nil {
+			return hours, err
+		}
+		h.InterruptsPerHour = interruptsPerHour(h.InterruptCount, h.TotalTime)
+		hours[h.Hour] = h
+	}
+	if err := rows.Err(); err != nil {
+		return hours, err
+	}
+	return hours, nil
+}
@@ -0,0 +1,169 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"pottogether/pkg/mariadb"
+)
+
+type Job struct {
+	Name      string  `json:"name"`
+	NextRunAt int     `json:"nextRunAt"`
+	Running   bool    `json:"running"`
+	LastRun   *JobRun `json:"lastRun"`
+}
+
+type JobRun struct {
+	ID         int     `json:"runID"`
+	Name       string  `json:"name"`
+	Trigger    string  `json:"trigger"`
+	Runner     string  `json:"runner"`
+	Status     string  `json:"status"`
+	Result     *string `json:"result"`
+	StartedAt  int     `json:"startedAt"`
+	FinishedAt *int    `json:"finishedAt"`
+}
+
+// RegisterJob creates the job row, due right away, if it does not exist yet
+func RegisterJob(ctx context.Context, name string) error {
+	query := "INSERT IGNORE INTO job (name, next_run_at) VALUES (?, NOW())"
+	_, err := mariadb.DB.ExecContext(ctx, query, name)
+	return err
+}
+
+// ClaimJob takes the lease of the job for the runner. Unless force is set, the
+// job must also be due. Returns false when the job is not due or another
+// runner holds the lease.
+func ClaimJob(ctx context.Context, name string, runner string, leaseSeconds int, force bool) (bool, error) {
+	query := `
+		UPDATE job SET locked_by = ?, locked_until = DATE_ADD(NOW(), INTERVAL ? SECOND)
+		WHERE name = ? AND (? OR next_run_at <= NOW()) AND (locked_until IS NULL OR locked_until < NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, runner, leaseSeconds, name, force)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// RenewJob extends the lease the runner holds on the job. Returns false when
+// the runner no longer holds it.
+func RenewJob(ctx context.Context, name string, runner string, leaseSeconds int) (bool, error) {
+	query := "UPDATE job SET locked_until = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE name = ? AND locked_by = ?"
+	result, err := mariadb.DB.ExecContext(ctx, query, leaseSeconds, name, runner)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// ReleaseJob gives the lease back and schedules the next run
+func ReleaseJob(ctx context.Context, name string, runner string, intervalSeconds int) error {
+	query := `
+		UPDATE job SET locked_by = NULL, locked_until = NULL, next_run_at = DATE_ADD(NOW(), INTERVAL ? SECOND)
+		WHERE name = ? AND locked_by = ?`
+	_, err := mariadb.DB.ExecContext(ctx, query, intervalSeconds, name, runner)
+	return err
+}
+
+func StartJobRun(ctx context.Context, name string, trigger string, runner string) (int, error) {
+	query := `
+		INSERT INTO job_run (name, trigger_by, runner, status, started_at)
+		VALUES (?, ?, ?, 'running', NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, name, trigger, runner)
+	if err != nil {
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// FinishJobRun stores the outcome of a run, result is the error message of a
+// failed run or the summary of a successful one
+func FinishJobRun(ctx context.Context, runID int, success bool, result string) error {
+	status := "failed"
+	if success {
+		status = "success"
+	}
+	query := "UPDATE job_run SET status = ?, result = ?, finished_at = NOW() WHERE id = ?"
+	_, err := mariadb.DB.ExecContext(ctx, query, status, result, runID)
+	return err
+}
+
+// GetJobs returns the registered jobs with their last run
+func GetJobs(ctx context.Context) ([]Job, error) {
+	jobs := []Job{}
+	query := `
+		SELECT name, UNIX_TIMESTAMP(next_run_at), locked_until IS NOT NULL AND locked_until >= NOW()
+		FROM job
+		ORDER BY name`
+	rows, err := mariadb.DB.QueryContext(ctx, query)
+	if err != nil {
+		return jobs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.Name, &j.NextRunAt, &j.Running); err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	for i := range jobs {
+		runs, err := GetJobRuns(ctx, jobs[i].Name, 1)
+		if err != nil {
+			return jobs, err
+		}
+		if len(runs) > 0 {
+			jobs[i].LastRun = &runs[0]
+		}
+	}
+	return jobs, nil
+}
+
+// GetJobRuns returns the latest runs of the job, newest first
+func GetJobRuns(ctx context.Context, name string, limit int) ([]JobRun, error) {
+	runs := []JobRun{}
+	query := `
+		SELECT id, name, trigger_by, runner, status, result, UNIX_TIMESTAMP(started_at), UNIX_TIMESTAMP(finished_at)
+		FROM job_run
+		WHERE name = ?
+		ORDER BY id DESC
+		LIMIT ?`
+	rows, err := mariadb.DB.QueryContext(ctx, query, name, limit)
+	if err != nil {
+		return runs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r JobRun
+		if err := rows.Scan(&r.ID, &r.Name, &r.Trigger, &r.Runner, &r.Status, &r.Result, &r.StartedAt, &r.FinishedAt); err != nil {
+			return runs, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+// CheckJob reports whether the job is registered
+func CheckJob(ctx context.Context, name string) error {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM job WHERE name = ?)"
+	if err := mariadb.DB.QueryRowContext(ctx, query, name).Scan(&exists); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("job does not exist")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"pottogether/pkg/mariadb"
+	"time"
+)
+
+type Leaderboard struct {
+	Period  string             `json:"period"`
+	Entries []leaderboardEntry `json:"entries"`
+	Me      leaderboardEntry   `json:"me"`
+}
+
+type leaderboardEntry struct {
+	Rank      int    `json:"rank"`
+	UserID    int    `json:"userID"`
+	Username  string `json:"username"`
+	Avatar    *int   `json:"avatar"`
+	TotalTime int    `json:"totalTime"`
+}
+
+// periodStart returns the first day (inclusive) of the given leaderboard period
+// in the timezone, or an empty string for the all-time leaderboard
+func periodStart(period string, timezone string) (string, error) {
+	return periodStartAt(period, time.Now().In(loadLocation(timezone)))
+}
+
+// periodStartAt is periodStart relative to the date of now, in now's location
+func periodStartAt(period string, now time.Time) (string, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case "daily":
+		return today.Format(dateLayout), nil
+	case "weekly":
+		// weeks start on Monday
+		offset := (int(today.Weekday()) + 6) % 7
+		return today.AddDate(0, 0, -offset).Format(dateLayout), nil
+	case "monthly":
+		return today.AddDate(0, 0, 1-today.Day()).Format(dateLayout), nil
+	case "all":
+		return "", nil
+	}
+	return "", fmt.Errorf("invalid period")
+}
+
+// GetRoomLeaderboard ranks everyone who cooked in the room during the period.
+// Private rooms are only shown to their members.
+func GetRoomLeaderboard(ctx context.Context, roomID int, userID int, period string, limit int) (Leaderboard, error) {
+	// Check if room exists
+	query := "SELECT EXISTS(SELECT 1 FROM room WHERE id = ?)"
+	var exists bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&exists)
+	if err != nil {
+		return Leaderboard{}, err
+	} else if !exists {
+		return Leaderboard{}, fmt.Errorf("room does not exist")
+	}
+	visible, err := CheckRoomVisible(ctx, roomID, userID)
+	if err != nil {
+		return Leaderboard{}, err
+	} else if !visible {
+		return Leaderboard{}, fmt.Errorf("user not in room")
+	}
+	return getLeaderboard(ctx, "f.room_id = ?", []interface{}{roomID}, userID, period, limit)
+}
+
+// GetGlobalLeaderboard ranks every user
+func GetGlobalLeaderboard(ctx context.Context, userID int, period string, limit int) (Leaderboard, error) {
+	return getLeaderboard(ctx, "", nil, userID, period, limit)
+}
+
+// GetFriendsLeaderboard ranks the user against their friends
+func GetFriendsLeaderboard(ctx context.Context, userID int, period string, limit int) (Leaderboard, error) {
+	filter := "(f.user_id = ? OR f.user_id IN (" + friendIDs + "))"
+	return getLeaderboard(ctx, filter, []interface{}{userID, userID, userID, userID}, userID, period, limit)
+}
+
+// getLeaderboard ranks users by focus time from the focus_daily rollup.
+// Tied users share a rank, and the caller's own entry is always returned in Me.
+func getLeaderboard(ctx context.Context, filter string, filterArgs []interface{}, userID int, period string, limit int) (Leaderboard, error) {
+	result := Leaderboard{Period: period, Entries: []leaderboardEntry{}}
+	// the period starts at midnight where the viewer is, matching the days
+	// of the rollup which are local to each user
+	var timezone string
+	query := "SELECT timezone FROM user WHERE id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&timezone)
+	if err != nil {
+		return result, err
+	}
+	start, err := periodStart(period, timezone)
+	if err != nil {
+		return result, err
+	}
+	where := "1 = 1"
+	args := []interface{}{}
+	if start != "" {
+		where += " AND f.day >= ?"
+		args = append(args, start)
+	}
+	if filter != "" {
+		where += " AND " + filter
+		args = append(args, filterArgs...)
+	}
+	args = append(args, limit, userID)
+	query = `
+		SELECT rnk, user_id, username, avatar, total FROM (
+			SELECT
+				RANK() OVER (ORDER BY SUM(f.total_time) DESC) AS rnk,
+				f.user_id, u.username, u.avatar,
+				SUM(f.total_time) AS total
+			FROM focus_daily f
+			INNER JOIN user u ON f.user_id = u.id
+			WHERE ` + where + `
+			GROUP BY f.user_id, u.username, u.avatar
+		) ranked
+		WHERE rnk <= ? OR user_id = ?
+		ORDER BY rnk, user_id`
+	rows, err := mariadb.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	found := false
+	for rows.Next() {
+		var entry leaderboardEntry
+		if err := rows.Scan(&entry.Rank, &entry.UserID, &entry.Username, &entry.Avatar, &entry.TotalTime); err != nil {
+			return result, err
+		}
+		if entry.UserID == userID {
+			result.Me = entry
+			found = true
+		}
+		if entry.Rank <= limit {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	// The caller has no focus time in this period and is unranked
+	if !found {
+		query = "SELECT id, username, avatar FROM user WHERE id = ?"
+		err = mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&result.Me.UserID, &result.Me.Username, &result.Me.Avatar)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
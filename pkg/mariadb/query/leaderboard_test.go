@@ -0,0 +1,44 @@
+package query
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestPeriodStartAt(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sunday 2026-10-18 23:30 in Sydney, still Sunday morning in UTC
+	sunday := time.Date(2026, time.October, 18, 23, 30, 0, 0, sydney)
+	tests := []struct {
+		name    string
+		period  string
+		now     time.Time
+		want    string
+		wantErr bool
+	}{
+		{name: "daily", period: "daily", now: sunday, want: "2026-10-18"},
+		{name: "weekly from sunday", period: "weekly", now: sunday, want: "2026-10-12"},
+		{name: "weekly on monday", period: "weekly", now: sunday.Add(time.Hour), want: "2026-10-19"},
+		{name: "monthly", period: "monthly", now: sunday, want: "2026-10-01"},
+		{name: "monthly on the first", period: "monthly", now: time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC), want: "2026-03-01"},
+		{name: "weekly across a month", period: "weekly", now: time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC), want: "2026-02-23"},
+		{name: "same instant in UTC", period: "weekly", now: sunday.Add(time.Hour).UTC(), want: "2026-10-12"},
+		{name: "all", period: "all", now: sunday, want: ""},
+		{name: "invalid", period: "yearly", now: sunday, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := periodStartAt(tt.period, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("periodStartAt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("periodStartAt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"pottogether/pkg/mariadb"
+	"strings"
+)
+
+type Media struct {
+	Key       string  `json:"key"`
+	Kind      string  `json:"kind"`
+	OwnerType *string `json:"ownerType"`
+	OwnerID   *int    `json:"ownerID"`
+	Size      *int64  `json:"size"`
+	CreatedAt int     `json:"createdAt"`
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// TrackMedia records an uploaded object, it stays unattached until an entity
+// references it
+func TrackMedia(ctx context.Context, key string, kind string, size int64) error {
+	query := `
+		INSERT INTO media (object_key, kind, size, created_at)
+		VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE size = VALUES(size), deleted_at = NULL`
+	_, err := mariadb.DB.ExecContext(ctx, query, key, kind, size)
+	return err
+}
+
+// attachMedia sets the owner of the objects behind the given images, which
+// may be keys or public URLs
+func attachMedia(ctx context.Context, db execer, ownerType string, ownerID int, images ...string) error {
+	for _, image := range images {
+		key := mediaKey(image)
+		if key == "" {
+			continue
+		}
+		query := "UPDATE media SET owner_type = ?, owner_id = ? WHERE object_key = ?"
+		if _, err := db.ExecContext(ctx, query, ownerType, ownerID, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucketURL prefixes the public URLs of the objects of the bucket, as built by
+// s3.UploadImage from s3.S3_BUCKET and s3.S3_REGION
+const bucketURL = "https://pottogether.s3.ap-southeast-2.amazonaws.com/"
+
+// mediaKey returns the object key of a stored image. Record photos are stored
+// as keys, ingredient images as public URLs. URLs outside the bucket are not
+// media of ours.
+func mediaKey(image string) string {
+	if image == "" || image == "null" {
+		return ""
+	}
+	if strings.HasPrefix(image, bucketURL) {
+		return strings.TrimPrefix(image, bucketURL)
+	}
+	if strings.Contains(image, "://") {
+		return ""
+	}
+	return image
+}
+
+// GetReferencedMedia returns the keys of all objects used by records and
+// ingredients
+func GetReferencedMedia(ctx context.Context) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	query := `
+		SELECT image, image_medium, image_thumbnail FROM record
+		UNION ALL
+		SELECT image, image_medium, image_thumbnail FROM ingredient`
+	rows, err := mariadb.DB.QueryContext(ctx, query)
+	if err != nil {
+		return referenced, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var image, medium, thumbnail sql.NullString
+		if err := rows.Scan(&image, &medium, &thumbnail); err != nil {
+			return referenced, err
+		}
+		for _, s := range []sql.NullString{image, medium, thumbnail} {
+			if key := mediaKey(s.String); key != "" {
+				referenced[key] = true
+			}
+		}
+	}
+	return referenced, rows.Err()
+}
+
+// GetMedia returns the tracked object, found is false for untracked objects
+func GetMedia(ctx context.Context, key string) (Media, bool, error) {
+	var media Media
+	query := `
+		SELECT object_key, kind, owner_type, owner_id, size, UNIX_TIMESTAMP(created_at)
+		FROM media
+		WHERE object_key = ?`
+	err := mariadb.DB.QueryRowContext(ctx, query, key).Scan(&media.Key, &media.Kind, &media.OwnerType, &media.OwnerID, &media.Size, &media.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return media, false, nil
+		}
+		return media, false, err
+	}
+	return media, true, nil
+}
+
+func MarkMediaDeleted(ctx context.Context, key string) error {
+	query := "UPDATE media SET deleted_at = NOW() WHERE object_key = ?"
+	_, err := mariadb.DB.ExecContext(ctx, query, key)
+	return err
+}
@@ -0,0 +1,95 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/pkg/mariadb"
+)
+
+type Message struct {
+	ID        int     `json:"messageID"`
+	RoomID    int     `json:"roomID"`
+	UserID    *int    `json:"userID"`
+	Username  *string `json:"username"`
+	Avatar    *int    `json:"avatar"`
+	Kind      string  `json:"kind"`
+	Content   string  `json:"content"`
+	CreatedAt int     `json:"createdAt"`
+}
+
+// AddMessage stores a chat message. System messages are sent with a nil userID.
+func AddMessage(ctx context.Context, roomID int, userID *int, kind string, content string) (Message, error) {
+	query := `
+		INSERT INTO room_message (room_id, user_id, kind, content, created_at)
+		VALUES (?, ?, ?, ?, NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, roomID, userID, kind, content)
+	if err != nil {
+		return Message{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Message{}, err
+	}
+	query = `
+		SELECT m.id, m.room_id, m.user_id, u.username, u.avatar, m.kind, m.content, UNIX_TIMESTAMP(m.created_at)
+		FROM room_message m
+		LEFT JOIN user u ON m.user_id = u.id
+		WHERE m.id = ?`
+	var message Message
+	err = mariadb.DB.QueryRowContext(ctx, query, id).Scan(&message.ID, &message.RoomID, &message.UserID, &message.Username, &message.Avatar, &message.Kind, &message.Content, &message.CreatedAt)
+	if err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+// GetMessages returns up to limit messages older than before, newest first.
+// before is a message id, or 0 for the latest messages.
+func GetMessages(ctx context.Context, roomID int, before int, limit int) ([]Message, error) {
+	messages := []Message{}
+	query := `
+		SELECT m.id, m.room_id, m.user_id, u.username, u.avatar, m.kind, m.content, UNIX_TIMESTAMP(m.created_at)
+		FROM room_message m
+		LEFT JOIN user u ON m.user_id = u.id
+		WHERE m.room_id = ? AND m.deleted_at IS NULL AND (? = 0 OR m.id < ?)
+		ORDER BY m.id DESC
+		LIMIT ?`
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID, before, before, limit)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var message Message
+		if err := rows.Scan(&message.ID, &message.RoomID, &message.UserID, &message.Username, &message.Avatar, &message.Kind, &message.Content, &message.CreatedAt); err != nil {
+			return messages, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// DeleteMessage deletes a message by its author or by an admin of the room
+func DeleteMessage(ctx context.Context, roomID int, messageID int, userID int) error {
+	var authorID sql.NullInt64
+	query := "SELECT user_id FROM room_message WHERE id = ? AND room_id = ? AND deleted_at IS NULL"
+	err := mariadb.DB.QueryRowContext(ctx, query, messageID, roomID).Scan(&authorID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("message does not exist")
+		}
+		return err
+	}
+	if !authorID.Valid || int(authorID.Int64) != userID {
+		admin, err := CheckAdmin(ctx, roomID, userID)
+		if err != nil {
+			return err
+		} else if !admin {
+			return fmt.Errorf("message does not belong to user")
+		}
+	}
+	query = "UPDATE room_message SET deleted_at = NOW() WHERE id = ?"
+	_, err = mariadb.DB.ExecContext(ctx, query, messageID)
+	return err
+}
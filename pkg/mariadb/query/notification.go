@@ -0,0 +1,429 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"pottogether/pkg/mariadb"
+)
+
+// Notification types
+const (
+	NotifyRoomInvite     = "room_invite"
+	NotifyFriendCooking  = "friend_cooking"
+	NotifyGoalReached    = "goal_reached"
+	NotifyStreakAtRisk   = "streak_at_risk"
+	NotifyRecordReaction = "record_reaction"
+	// only the email channel applies to the weekly digest
+	NotifyWeeklyDigest = "weekly_digest"
+)
+
+// NotificationTypes lists the types users can set preferences for
+var NotificationTypes = []string{NotifyRoomInvite, NotifyFriendCooking, NotifyGoalReached, NotifyStreakAtRisk, NotifyRecordReaction, NotifyWeeklyDigest}
+
+type Notification struct {
+	ID        int                    `json:"notificationID"`
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Body      string                 `json:"body"`
+	Data      map[string]interface{} `json:"data"`
+	Read      bool                   `json:"read"`
+	CreatedAt int                    `json:"createdAt"`
+}
+
+type NotificationPreference struct {
+	Type  string `json:"type"`
+	InApp bool   `json:"inApp"`
+	Push  bool   `json:"push"`
+	Email bool   `json:"email"`
+}
+
+type Device struct {
+	ID       int    `json:"deviceID"`
+	UserID   int    `json:"userID"`
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+}
+
+// PendingPush is a notification waiting to be pushed to the user's devices
+type PendingPush struct {
+	Notification
+	UserID   int
+	Attempts int
+}
+
+func isNotificationType(t string) bool {
+	for _, known := range NotificationTypes {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultPreference is used for types the user never configured
+func defaultPreference(t string) NotificationPreference {
+	return NotificationPreference{Type: t, InApp: true, Push: true, Email: false}
+}
+
+func getPreference(ctx context.Context, userID int, t string) (NotificationPreference, error) {
+	pref := NotificationPreference{Type: t}
+	query := "SELECT in_app, push, email FROM notification_preference WHERE user_id = ? AND type = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, userID, t).Scan(&pref.InApp, &pref.Push, &pref.Email)
+	if err == sql.ErrNoRows {
+		return defaultPreference(t), nil
+	}
+	return pref, err
+}
+
+// Notify creates a notification for the user according to their preferences.
+// Push delivery happens asynchronously in the notify dispatcher.
+func Notify(ctx context.Context, userID int, t string, title string, body string, data map[string]interface{}) error {
+	pref, err := getPreference(ctx, userID, t)
+	if err != nil {
+		return err
+	}
+	if !pref.InApp && !pref.Push {
+		return nil
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	pushStatus := "none"
+	if pref.Push {
+		pushStatus = "pending"
+	}
+	query := `
+		INSERT INTO notification (user_id, type, title, body, data, in_app, push_status, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NOW(), NOW())`
+	_, err = mariadb.DB.ExecContext(ctx, query, userID, t, title, body, string(encoded), pref.InApp, pushStatus)
+	return err
+}
+
+// GetNotifications returns up to limit inbox notifications older than before,
+// newest first. before is a notification id, or 0 for the latest.
+func GetNotifications(ctx context.Context, userID int, before int, limit int, unreadOnly bool) ([]Notification, error) {
+	notifications := []Notification{}
+	query := `
+		SELECT id, type, title, body, data, read_at IS NOT NULL, UNIX_TIMESTAMP(created_at)
+		FROM notification
+		WHERE user_id = ? AND in_app = 1 AND (? = 0 OR id < ?) AND (? = 0 OR read_at IS NULL)
+		ORDER BY id DESC
+		LIMIT ?`
+	rows, err := mariadb.DB.QueryContext(ctx, query, userID, before, before, unreadOnly, limit)
+	if err != nil {
+		return notifications, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n Notification
+		var data string
+		if err := rows.Scan(&n.ID, &n.Type, &n.Title, &n.Body, &data, &n.Read, &n.CreatedAt); err != nil {
+			return notifications, err
+		}
+		if err := json.Unmarshal([]byte(data), &n.Data); err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+func GetUnreadCount(ctx context.Context, userID int) (int, error) {
+	query := "SELECT COUNT(*) FROM notification WHERE user_id = ? AND in_app = 1 AND read_at IS NULL"
+	var count int
+	err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks the given notifications as read, or all of them if ids is empty
+func MarkRead(ctx context.Context, userID int, ids []int) error {
+	if len(ids) == 0 {
+		query := "UPDATE notification SET read_at = NOW() WHERE user_id = ? AND read_at IS NULL"
+		_, err := mariadb.DB.ExecContext(ctx, query, userID)
+		return err
+	}
+	query := "UPDATE notification SET read_at = NOW() WHERE user_id = ? AND id = ? AND read_at IS NULL"
+	for _, id := range ids {
+		if _, err := mariadb.DB.ExecContext(ctx, query, userID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPreferences returns the preference of every notification type
+func GetPreferences(ctx context.Context, userID int) ([]NotificationPreference, error) {
+	prefs := make([]NotificationPreference, 0, len(NotificationTypes))
+	for _, t := range NotificationTypes {
+		pref, err := getPreference(ctx, userID, t)
+		if err != nil {
+			return prefs, err
+		}
+		prefs = append(prefs, pref)
+	}
+	return prefs, nil
+}
+
+func SetPreference(ctx context.Context, userID int, pref NotificationPreference) error {
+	if !isNotificationType(pref.Type) {
+		return fmt.Errorf("invalid notification type")
+	}
+	query := `
+		INSERT INTO notification_preference (user_id, type, in_app, push, email)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE in_app = VALUES(in_app), push = VALUES(push), email = VALUES(email)`
+	_, err := mariadb.DB.ExecContext(ctx, query, userID, pref.Type, pref.InApp, pref.Push, pref.Email)
+	return err
+}
+
+// AddDevice registers a push token, moving it to this user if another user
+// registered it before
+func AddDevice(ctx context.Context, userID int, platform string, token string) error {
+	if platform != "fcm" && platform != "apns" {
+		return fmt.Errorf("invalid platform")
+	}
+	query := `
+		INSERT INTO user_device (user_id, platform, token, created_at)
+		VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE user_id = VALUES(user_id)`
+	_, err := mariadb.DB.ExecContext(ctx, query, userID, platform, token)
+	return err
+}
+
+func RemoveDevice(ctx context.Context, userID int, token string) error {
+	query := "DELETE FROM user_device WHERE user_id = ? AND token = ?"
+	_, err := mariadb.DB.ExecContext(ctx, query, userID, token)
+	return err
+}
+
+// RemoveDeviceToken drops a token the push provider reported as invalid
+func RemoveDeviceToken(ctx context.Context, platform string, token string) error {
+	query := "DELETE FROM user_device WHERE platform = ? AND token = ?"
+	_, err := mariadb.DB.ExecContext(ctx, query, platform, token)
+	return err
+}
+
+// GetUndeliveredDevices returns the devices of the user the notification has
+// not been pushed to yet
+func GetUndeliveredDevices(ctx context.Context, notificationID int, userID int) ([]Device, error) {
+	devices := []Device{}
+	query := `
+		SELECT d.id, d.user_id, d.platform, d.token FROM user_device d
+		WHERE d.user_id = ?
+		AND NOT EXISTS (SELECT 1 FROM notification_delivery WHERE notification_id = ? AND device_id = d.id)`
+	rows, err := mariadb.DB.QueryContext(ctx, query, userID, notificationID)
+	if err != nil {
+		return devices, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Platform, &d.Token); err != nil {
+			return devices, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// MarkDelivered records that the notification reached the device
+func MarkDelivered(ctx context.Context, notificationID int, deviceID int) error {
+	query := "INSERT IGNORE INTO notification_delivery (notification_id, device_id, delivered_at) VALUES (?, ?, NOW())"
+	_, err := mariadb.DB.ExecContext(ctx, query, notificationID, deviceID)
+	return err
+}
+
+// ClaimPendingPushes takes up to limit notifications due for push delivery.
+// Claimed rows are hidden from other replicas for the lease duration.
+func ClaimPendingPushes(ctx context.Context, limit int, leaseSeconds int) ([]PendingPush, error) {
+	pushes := []PendingPush{}
+	query := `
+		SELECT id, user_id, type, title, body, data, push_attempts, UNIX_TIMESTAMP(created_at)
+		FROM notification
+		WHERE push_status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT ?`
+	rows, err := mariadb.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return pushes, err
+	}
+	defer rows.Close()
+	candidates := []PendingPush{}
+	for rows.Next() {
+		var p PendingPush
+		var data string
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Type, &p.Title, &p.Body, &data, &p.Attempts, &p.CreatedAt); err != nil {
+			return pushes, err
+		}
+		if err := json.Unmarshal([]byte(data), &p.Data); err != nil {
+			return pushes, err
+		}
+		candidates = append(candidates, p)
+	}
+	rows.Close()
+	query = `
+		UPDATE notification SET next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND)
+		WHERE id = ? AND push_status = 'pending' AND next_attempt_at <= NOW()`
+	for _, p := range candidates {
+		result, err := mariadb.DB.ExecContext(ctx, query, leaseSeconds, p.ID)
+		if err != nil {
+			return pushes, err
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 1 {
+			pushes = append(pushes, p)
+		}
+	}
+	return pushes, nil
+}
+
+// CompletePush records the outcome of a push attempt. Failed attempts are
+// retried after retrySeconds until the push is given up with status 'failed'.
+func CompletePush(ctx context.Context, id int, ok bool, giveUp bool, retrySeconds int) error {
+	if ok || giveUp {
+		status := "sent"
+		if !ok {
+			status = "failed"
+		}
+		query := "UPDATE notification SET push_status = ?, push_attempts = push_attempts + 1 WHERE id = ?"
+		if _, err := mariadb.DB.ExecContext(ctx, query, status, id); err != nil {
+			return err
+		}
+		// the deliveries are only needed while the push may be retried
+		query = "DELETE FROM notification_delivery WHERE notification_id = ?"
+		_, err := mariadb.DB.ExecContext(ctx, query, id)
+		return err
+	}
+	query := `
+		UPDATE notification
+		SET push_attempts = push_attempts + 1, next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND)
+		WHERE id = ?`
+	_, err := mariadb.DB.ExecContext(ctx, query, retrySeconds, id)
+	return err
+}
+
+// InviteToRoom notifies a user that a member invited them to the room
+func InviteToRoom(ctx context.Context, roomID int, userID int, inviteeID int) error {
+	member, err := CheckMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	} else if !member {
+		return fmt.Errorf("user not in room")
+	}
+	exists, err := CheckUser(ctx, inviteeID)
+	if err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("user does not exist")
+	}
+	member, err = CheckMember(ctx, roomID, inviteeID)
+	if err != nil {
+		return err
+	} else if member {
+		return fmt.Errorf("user already in the room")
+	}
+	var username, roomname string
+	query := "SELECT u.username, r.roomname FROM user u, room r WHERE u.id = ? AND r.id = ?"
+	if err := mariadb.DB.QueryRowContext(ctx, query, userID, roomID).Scan(&username, &roomname); err != nil {
+		return err
+	}
+	return Notify(ctx, inviteeID, NotifyRoomInvite, "Room invitation", username+" invited you to "+roomname, map[string]interface{}{
+		"roomID": roomID,
+		"userID": userID,
+	})
+}
+
+// NotifyFriendsCooking tells the user's friends they started cooking a record
+func NotifyFriendsCooking(ctx context.Context, userID int, recordID int) error {
+	var username, ingredient string
+	query := `
+		SELECT u.username, i.name
+		FROM record r
+		INNER JOIN user u ON r.user_id = u.id
+		INNER JOIN ingredient i ON r.ingredient_id = i.id
+		WHERE r.id = ?`
+	if err := mariadb.DB.QueryRowContext(ctx, query, recordID).Scan(&username, &ingredient); err != nil {
+		return err
+	}
+	friends, err := GetFriends(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, friend := range friends {
+		err := Notify(ctx, friend.ID, NotifyFriendCooking, "Friend is cooking", username+" started cooking "+ingredient, map[string]interface{}{
+			"recordID": recordID,
+			"userID":   userID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyRecordReaction tells the owner of a record someone reacted to it
+func notifyRecordReaction(ctx context.Context, recordID int, userID int, emoji string) error {
+	var ownerID int
+	var username string
+	query := `
+		SELECT r.user_id, u.username
+		FROM record r, user u
+		WHERE r.id = ? AND u.id = ?`
+	if err := mariadb.DB.QueryRowContext(ctx, query, recordID, userID).Scan(&ownerID, &username); err != nil {
+		return err
+	}
+	if ownerID == userID {
+		return nil
+	}
+	return Notify(ctx, ownerID, NotifyRecordReaction, "New reaction", username+" reacted "+emoji+" to your record", map[string]interface{}{
+		"recordID": recordID,
+		"userID":   userID,
+		"emoji":    emoji,
+	})
+}
+
+// notifyGoalReached tells the user, or every member of the room, that a goal
+// was completed
+func notifyGoalReached(ctx context.Context, ownerType string, ownerID int, goal goalProgress) error {
+	data := map[string]interface{}{
+		"period":      goal.Period,
+		"periodStart": goal.PeriodStart,
+		"target":      goal.Target,
+	}
+	title := "Goal reached"
+	body := "You reached your " + goal.Period + " focus goal"
+	if ownerType == "user" {
+		return Notify(ctx, ownerID, NotifyGoalReached, title, body, data)
+	}
+	data["roomID"] = ownerID
+	var roomname string
+	if err := mariadb.DB.QueryRowContext(ctx, "SELECT roomname FROM room WHERE id = ?", ownerID).Scan(&roomname); err != nil {
+		return err
+	}
+	body = roomname + " reached its " + goal.Period + " focus goal"
+	rows, err := mariadb.DB.QueryContext(ctx, "SELECT user_id FROM room_user WHERE room_id = ?", ownerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	members := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		members = append(members, id)
+	}
+	rows.Close()
+	for _, id := range members {
+		if err := Notify(ctx, id, NotifyGoalReached, title, body, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"pottogether/pkg/mariadb"
+)
+
+// TakeToken takes a token from the shared bucket refilled with rate tokens per
+// second up to burst. When the bucket is empty it returns false and the
+// seconds until the next token.
+func TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, float64, error) {
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	// make sure the row exists so that concurrent takers wait on its lock
+	query := "INSERT IGNORE INTO rate_limit_bucket (bucket_key, tokens, updated_at) VALUES (?, ?, NOW(3))"
+	if _, err = tx.ExecContext(ctx, query, key, burst); err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	var tokens float64
+	var elapsed int64
+	query = "SELECT tokens, TIMESTAMPDIFF(MICROSECOND, updated_at, NOW(3)) FROM rate_limit_bucket WHERE bucket_key = ? FOR UPDATE"
+	if err = tx.QueryRowContext(ctx, query, key).Scan(&tokens, &elapsed); err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	tokens = math.Min(float64(burst), tokens+float64(elapsed)/1e6*rate)
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	query = "UPDATE rate_limit_bucket SET tokens = ?, updated_at = NOW(3) WHERE bucket_key = ?"
+	if _, err = tx.ExecContext(ctx, query, tokens, key); err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, 0, err
+	}
+	if allowed {
+		return true, 0, nil
+	}
+	return false, (1 - tokens) / rate, nil
+}
+
+// DeleteIdleBuckets deletes the buckets untouched for idleSeconds, they are
+// full again by then
+func DeleteIdleBuckets(ctx context.Context, idleSeconds int) (int, error) {
+	query := "DELETE FROM rate_limit_bucket WHERE updated_at < DATE_SUB(NOW(3), INTERVAL ? SECOND)"
+	result, err := mariadb.DB.ExecContext(ctx, query, idleSeconds)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// GetLoginLock returns the seconds the account stays locked, 0 if it is not
+func GetLoginLock(ctx context.Context, email string) (int, error) {
+	var seconds int
+	query := "SELECT GREATEST(TIMESTAMPDIFF(SECOND, NOW(), locked_until), 0) FROM login_failure WHERE email = ? AND locked_until > NOW()"
+	err := mariadb.DB.QueryRowContext(ctx, query, email).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seconds, err
+}
+
+// RecordLoginFailure counts a failed login of the account. From threshold
+// consecutive failures on, the account is locked for baseSeconds, doubled
+// with each further failure up to maxSeconds. Failures older than maxSeconds
+// are forgotten. Returns the seconds the account is now locked for.
+func RecordLoginFailure(ctx context.Context, email string, threshold int, baseSeconds int, maxSeconds int) (int, error) {
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	query := `
+		INSERT INTO login_failure (email, failures, last_failed_at) VALUES (?, 1, NOW())
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failed_at < DATE_SUB(NOW(), INTERVAL ? SECOND), 1, failures + 1),
+			last_failed_at = NOW()`
+	if _, err = tx.ExecContext(ctx, query, email, maxSeconds); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var failures int
+	query = "SELECT failures FROM login_failure WHERE email = ?"
+	if err = tx.QueryRowContext(ctx, query, email).Scan(&failures); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	lock := 0
+	if failures >= threshold {
+		lock = maxSeconds
+		if shift := failures - threshold; shift < 31 {
+			lock = int(math.Min(float64(baseSeconds)*math.Pow(2, float64(shift)), float64(maxSeconds)))
+		}
+		query = "UPDATE login_failure SET locked_until = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE email = ?"
+		if _, err = tx.ExecContext(ctx, query, lock, email); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return lock, tx.Commit()
+}
+
+// ClearLoginFailures resets the failure count after a successful login
+func ClearLoginFailures(ctx context.Context, email string) error {
+	query := "DELETE FROM login_failure WHERE email = ?"
+	_, err := mariadb.DB.ExecContext(ctx, query, email)
+	return err
+}
+
+// DeleteStaleLoginFailures deletes unlocked failure counts older than
+// maxSeconds, they would be reset on the next failure anyway
+func DeleteStaleLoginFailures(ctx context.Context, maxSeconds int) (int, error) {
+	query := `
+		DELETE FROM login_failure
+		WHERE last_failed_at < DATE_SUB(NOW(), INTERVAL ? SECOND) AND (locked_until IS NULL OR locked_until < NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, maxSeconds)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
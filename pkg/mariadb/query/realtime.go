@@ -0,0 +1,116 @@
+package query
+
+import (
+	"context"
+	"pottogether/pkg/mariadb"
+)
+
+// RealtimeEvent is an encoded event broadcast to a room by an instance
+type RealtimeEvent struct {
+	ID      int64
+	RoomID  int
+	Origin  string
+	Payload string
+}
+
+// PublishRealtimeEvent stores an event for the other instances to deliver
+func PublishRealtimeEvent(ctx context.Context, roomID int, origin string, payload string) error {
+	query := "INSERT INTO realtime_event (room_id, origin, payload, created_at) VALUES (?, ?, ?, NOW())"
+	_, err := mariadb.DB.ExecContext(ctx, query, roomID, origin, payload)
+	return err
+}
+
+// GetLastRealtimeEventID returns the id of the latest event, 0 if there is none
+func GetLastRealtimeEventID(ctx context.Context) (int64, error) {
+	var id int64
+	query := "SELECT COALESCE(MAX(id), 0) FROM realtime_event"
+	err := mariadb.DB.QueryRowContext(ctx, query).Scan(&id)
+	return id, err
+}
+
+// GetRealtimeEvents returns up to limit events after afterID, oldest first
+func GetRealtimeEvents(ctx context.Context, afterID int64, limit int) ([]RealtimeEvent, error) {
+	events := []RealtimeEvent{}
+	query := "SELECT id, room_id, origin, payload FROM realtime_event WHERE id > ? ORDER BY id LIMIT ?"
+	rows, err := mariadb.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event RealtimeEvent
+		if err := rows.Scan(&event.ID, &event.RoomID, &event.Origin, &event.Payload); err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// DeleteOldRealtimeEvents deletes the events older than ageSeconds, which
+// every instance has read by then
+func DeleteOldRealtimeEvents(ctx context.Context, ageSeconds int) (int, error) {
+	query := "DELETE FROM realtime_event WHERE created_at < NOW() - INTERVAL ? SECOND"
+	result, err := mariadb.DB.ExecContext(ctx, query, ageSeconds)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// SetPresence records whether the user is connected to the room through the
+// instance
+func SetPresence(ctx context.Context, instance string, roomID int, userID int, online bool) error {
+	query := "DELETE FROM realtime_presence WHERE instance = ? AND room_id = ? AND user_id = ?"
+	if online {
+		query = `
+			INSERT INTO realtime_presence (instance, room_id, user_id, seen_at)
+			VALUES (?, ?, ?, NOW())
+			ON DUPLICATE KEY UPDATE seen_at = NOW()`
+	}
+	_, err := mariadb.DB.ExecContext(ctx, query, instance, roomID, userID)
+	return err
+}
+
+// GetOnline returns the ids of the users connected to the room through any
+// instance that refreshed its presence within staleSeconds
+func GetOnline(ctx context.Context, roomID int, staleSeconds int) ([]int, error) {
+	users := []int{}
+	query := `
+		SELECT DISTINCT user_id
+		FROM realtime_presence
+		WHERE room_id = ? AND seen_at > NOW() - INTERVAL ? SECOND
+		ORDER BY user_id`
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID, staleSeconds)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return users, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
+
+// DeleteStalePresence deletes the presence of instances that stopped
+// refreshing it
+func DeleteStalePresence(ctx context.Context, staleSeconds int) (int, error) {
+	query := "DELETE FROM realtime_presence WHERE seen_at < NOW() - INTERVAL ? SECOND"
+	result, err := mariadb.DB.ExecContext(ctx, query, staleSeconds)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
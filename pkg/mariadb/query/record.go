@@ -1,29 +1,39 @@
 package query
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/config"
+	"pottogether/internal/metrics"
 	"pottogether/pkg/logger"
 	"pottogether/pkg/mariadb"
 	"strconv"
 )
 
 type Record struct {
-	ID           int    `json:"recordID"`
-	UserID       int    `json:"userID"`
-	RoomID       int    `json:"roomID"`
-	PotID        string `json:"potID"`
-	IngredientID int    `json:"ingredientID"`
-	Image        string `json:"image"`
-	Caption      string `json:"caption"`
-	Interval     int    `json:"interval"`
-	FinishTime   int    `json:"finishTime"`
-	Interrupt    int    `json:"interrupt"`
-	Status       int    `json:"status"`
+	ID             int    `json:"recordID"`
+	UserID         int    `json:"userID"`
+	RoomID         int    `json:"roomID"`
+	PotID          string `json:"potID"`
+	IngredientID   int    `json:"ingredientID"`
+	Image          string `json:"image"`
+	ImageMedium    string `json:"imageMedium"`
+	ImageThumbnail string `json:"imageThumbnail"`
+	Caption        string `json:"caption"`
+	Interval       int    `json:"interval"`
+	FinishTime     int    `json:"finishTime"`
+	Interrupt      int    `json:"interrupt"`
+	Status         int    `json:"status"`
 }
 
 type RecordDetail struct {
 	ID              int    `json:"recordID"`
+	RoomID          int    `json:"roomID"`
 	Username        string `json:"username"`
 	Image           string `json:"image"`
+	ImageMedium     string `json:"imageMedium"`
+	ImageThumbnail  string `json:"imageThumbnail"`
 	Caption         string `json:"caption"`
 	Interval        int    `json:"interval"`
 	FinishTime      int    `json:"finishTime"`
@@ -32,13 +42,18 @@ type RecordDetail struct {
 	IngredientName  string `json:"ingredientName"`
 	Interrupt       int    `json:"interrupt"`
 	Status          int    `json:"status"`
+	ReactionCount   int    `json:"reactionCount"`
+	CommentCount    int    `json:"commentCount"`
+	// Only filled in by GetRecordDetail
+	Focus     *focusQuality     `json:"focus,omitempty"`
+	Reactions []reactionSummary `json:"reactions,omitempty"`
 }
 
-func CreateRecord(record Record) (int, error) {
+func CreateRecord(ctx context.Context, record Record) (int, error) {
 	query := `
 		INSERT INTO record (user_id, room_id, pot_id, ingredient_id, time_interval, interrupt, status, created_at, finish_time, image, caption)
 		VALUES (?, ?, ?, ?, 0, 0, 0, NOW(), NOW(), "null", "null")`
-	result, err := mariadb.DB.Exec(query, record.UserID, record.RoomID, record.PotID, record.IngredientID)
+	result, err := mariadb.DB.ExecContext(ctx, query, record.UserID, record.RoomID, record.PotID, record.IngredientID)
 	if err != nil {
 		return -1, err
 	}
@@ -49,36 +64,165 @@ func CreateRecord(record Record) (int, error) {
 	return int(id), nil
 }
 
-func UpdateRecord(record Record) error {
+// UpdateRecord saves the result of a record that is still cooking. The record
+// row is locked while it is read, so that a retried request finishing it at the
+// same time is rejected instead of being added to the rollup twice. Returns
+// whether this update finished the record.
+func UpdateRecord(ctx context.Context, record Record) (bool, error) {
+	// begin transaction
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
 	// check if record exists
-	query := `SELECT id FROM record WHERE id = ?`
-	err := mariadb.DB.QueryRow(query, record.ID).Scan(&record.ID)
+	var ownerID, prevStatus int
+	var timezone string
+	query := `
+		SELECT r.user_id, r.room_id, r.status, u.timezone
+		FROM record r
+		INNER JOIN user u ON r.user_id = u.id
+		WHERE r.id = ?
+		FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, record.ID).Scan(&ownerID, &record.RoomID, &prevStatus, &timezone)
 	if err != nil {
-		logger.Warn("Invalid recordID: " + strconv.Itoa(record.ID))
-		return err
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			logger.WarnCtx(ctx, "Invalid recordID: "+strconv.Itoa(record.ID))
+			return false, fmt.Errorf("record does not exist")
+		}
+		return false, err
+	} else if ownerID != record.UserID {
+		tx.Rollback()
+		return false, fmt.Errorf("record does not belong to user")
+	} else if prevStatus != 0 {
+		// finished and abandoned records are final
+		tx.Rollback()
+		return false, fmt.Errorf("record is not cooking")
 	}
 	// update record
 	query = `
 		UPDATE record
-		SET image = ?, caption = ?, time_interval = ?, finish_time = NOW(), interrupt = ?, status = ?
+		SET image = COALESCE(NULLIF(?, ''), image), image_medium = COALESCE(NULLIF(?, ''), image_medium),
+			image_thumbnail = COALESCE(NULLIF(?, ''), image_thumbnail), caption = ?, time_interval = ?, finish_time = NOW(),
+			interrupt = GREATEST(?, (SELECT COUNT(*) FROM record_interrupt WHERE record_id = ?)), status = ?
 		WHERE id = ?
 	`
-	_, err = mariadb.DB.Exec(query, record.Image, record.Caption, record.Interval, record.Interrupt, record.Status, record.ID)
+	_, err = tx.ExecContext(ctx, query, record.Image, record.ImageMedium, record.ImageThumbnail, record.Caption, record.Interval, record.Interrupt, record.ID, record.Status, record.ID)
 	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	err = attachMedia(ctx, tx, "record", record.ID, record.Image, record.ImageMedium, record.ImageThumbnail)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	// add to daily rollup, bucketed by the day in the user's own timezone
+	if record.Status == 1 {
+		err = addToRollup(ctx, tx, record.UserID, record.RoomID, timezone, record.Interval)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	// commit transaction
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	// check goals reached by this record, the record itself is already saved
+	if record.Status == 1 {
+		metrics.RecordFinished()
+		if err := recordGoalCompletions(ctx, record.UserID, record.RoomID); err != nil {
+			logger.WarnCtx(ctx, "Error recording goal completions: "+err.Error())
+		}
+	}
+	return record.Status == 1, nil
+}
+
+// CountActiveRecords returns the number of records being cooked
+func CountActiveRecords(ctx context.Context) (int, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM record r WHERE " + activeRecord
+	err := mariadb.DB.QueryRowContext(ctx, query, activeRecordArgs()...).Scan(&count)
+	return count, err
+}
+
+// activeRecord is the condition for records still being cooked. Group session
+// records are driven by the server, other records are active until the max
+// session length without heartbeats, or while heartbeats keep coming. Takes
+// the arguments of activeRecordArgs.
+const activeRecord = `r.status = 0 AND (r.session_id IS NOT NULL OR IF(r.last_heartbeat_at IS NULL,
+	r.created_at >= NOW() - INTERVAL ? SECOND,
+	r.last_heartbeat_at >= NOW() - INTERVAL ? SECOND))`
+
+func activeRecordArgs() []interface{} {
+	return []interface{}{
+		config.Viper.GetInt("RECORD_MAX_SESSION_SECONDS"),
+		config.Viper.GetInt("RECORD_HEARTBEAT_TIMEOUT_SECONDS"),
+	}
+}
+
+// Heartbeat keeps a cooking record alive while the client is open
+func Heartbeat(ctx context.Context, recordID int, userID int) error {
+	var ownerID, status int
+	query := "SELECT user_id, status FROM record WHERE id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, recordID).Scan(&ownerID, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("record does not exist")
+		}
 		return err
+	} else if ownerID != userID {
+		return fmt.Errorf("record does not belong to user")
+	} else if status != 0 {
+		return fmt.Errorf("record is not cooking")
+	}
+	query = "UPDATE record SET last_heartbeat_at = NOW() WHERE id = ? AND status = 0"
+	_, err = mariadb.DB.ExecContext(ctx, query, recordID)
+	return err
+}
+
+// AbandonStaleRecords marks the records that are no longer active as
+// abandoned. Returns the number of records abandoned.
+func AbandonStaleRecords(ctx context.Context) (int, error) {
+	query := `
+		UPDATE record r SET r.status = 2, r.finish_time = NOW()
+		WHERE r.status = 0 AND NOT (` + activeRecord + `)`
+	result, err := mariadb.DB.ExecContext(ctx, query, activeRecordArgs()...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return int(affected), nil
+}
+
+// addToRollup adds a finished record to the focus_daily rollup on today's
+// date in the user's timezone
+func addToRollup(ctx context.Context, tx *sql.Tx, userID int, roomID int, timezone string, interval int) error {
+	query := `
+		INSERT INTO focus_daily (user_id, room_id, day, total_time, record_cnt)
+		VALUES (?, ?, ?, ?, 1)
+		ON DUPLICATE KEY UPDATE total_time = total_time + VALUES(total_time), record_cnt = record_cnt + 1`
+	_, err := tx.ExecContext(ctx, query, userID, roomID, localDate(timezone), interval)
+	return err
 }
 
-func GetUserRecords(userID int) ([]RecordDetail, error) {
+func GetUserRecords(ctx context.Context, userID int) ([]RecordDetail, error) {
 	query := `
-		SELECT r.id, r.image, r.caption, r.time_interval, UNIX_TIMESTAMP(r.finish_time), r.ingredient_id, i.name, i.image, r.interrupt, r.status, u.username
+		SELECT r.id, r.room_id, r.image, COALESCE(r.image_medium, r.image), COALESCE(r.image_thumbnail, r.image), r.caption, r.time_interval, UNIX_TIMESTAMP(r.finish_time), r.ingredient_id, i.name, i.image, r.interrupt, r.status, u.username,
+			(SELECT COUNT(*) FROM record_reaction WHERE record_id = r.id),
+			(SELECT COUNT(*) FROM record_comment WHERE record_id = r.id AND deleted_at IS NULL)
 		FROM record r
 		INNER JOIN ingredient i ON r.ingredient_id = i.id
 		INNER JOIN user u ON r.user_id = u.id
 		WHERE r.user_id = ?
 		ORDER BY status DESC`
-	rows, err := mariadb.DB.Query(query, userID)
+	rows, err := mariadb.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +230,7 @@ func GetUserRecords(userID int) ([]RecordDetail, error) {
 	var records []RecordDetail
 	for rows.Next() {
 		var record RecordDetail
-		err = rows.Scan(&record.ID, &record.Image, &record.Caption, &record.Interval, &record.FinishTime, &record.IngredientID, &record.IngredientName, &record.IngredientImage, &record.Interrupt, &record.Status, &record.Username)
+		err = rows.Scan(&record.ID, &record.RoomID, &record.Image, &record.ImageMedium, &record.ImageThumbnail, &record.Caption, &record.Interval, &record.FinishTime, &record.IngredientID, &record.IngredientName, &record.IngredientImage, &record.Interrupt, &record.Status, &record.Username, &record.ReactionCount, &record.CommentCount)
 		if err != nil {
 			return nil, err
 		}
@@ -95,44 +239,75 @@ func GetUserRecords(userID int) ([]RecordDetail, error) {
 	return records, nil
 }
 
-func GetRecordDetail(recordID int) (RecordDetail, error) {
+func GetRecordDetail(ctx context.Context, recordID int, userID int) (RecordDetail, error) {
 	// check if record exists
-	query := `SELECT id FROM record WHERE id = ?`
-	err := mariadb.DB.QueryRow(query, recordID).Scan(&recordID)
+	var ownerID, roomID int
+	query := `SELECT user_id, room_id FROM record WHERE id = ?`
+	err := mariadb.DB.QueryRowContext(ctx, query, recordID).Scan(&ownerID, &roomID)
 	if err != nil {
-		logger.Warn("Invalid recordID: " + strconv.Itoa(recordID))
+		logger.WarnCtx(ctx, "Invalid recordID: "+strconv.Itoa(recordID))
 		return RecordDetail{}, err
 	}
+	// photos of private rooms are only shown to members
+	if ownerID != userID {
+		visible, err := CheckRoomVisible(ctx, roomID, userID)
+		if err != nil {
+			return RecordDetail{}, err
+		} else if !visible {
+			return RecordDetail{}, fmt.Errorf("user cannot view record")
+		}
+	}
 	query = `
-		SELECT r.id, r.image, r.caption, r.time_interval, UNIX_TIMESTAMP(r.finish_time), r.ingredient_id, i.name, i.image, r.interrupt, r.status, u.username
+		SELECT r.id, r.room_id, r.image, COALESCE(r.image_medium, r.image), COALESCE(r.image_thumbnail, r.image), r.caption, r.time_interval, UNIX_TIMESTAMP(r.finish_time), r.ingredient_id, i.name, i.image, r.interrupt, r.status, u.username,
+			(SELECT COUNT(*) FROM record_reaction WHERE record_id = r.id),
+			(SELECT COUNT(*) FROM record_comment WHERE record_id = r.id AND deleted_at IS NULL)
 		FROM record r
 		INNER JOIN ingredient i ON r.ingredient_id = i.id
 		INNER JOIN user u ON r.user_id = u.id
 		WHERE r.id = ?`
 	var record RecordDetail
-	err = mariadb.DB.QueryRow(query, recordID).Scan(&record.ID, &record.Image, &record.Caption, &record.Interval, &record.FinishTime, &record.IngredientID, &record.IngredientName, &record.IngredientImage, &record.Interrupt, &record.Status, &record.Username)
+	err = mariadb.DB.QueryRowContext(ctx, query, recordID).Scan(&record.ID, &record.RoomID, &record.Image, &record.ImageMedium, &record.ImageThumbnail, &record.Caption, &record.Interval, &record.FinishTime, &record.IngredientID, &record.IngredientName, &record.IngredientImage, &record.Interrupt, &record.Status, &record.Username, &record.ReactionCount, &record.CommentCount)
+	if err != nil {
+		return RecordDetail{}, err
+	}
+	// get interrupt metrics
+	focus, err := getFocusQuality(ctx, recordID)
+	if err != nil {
+		return RecordDetail{}, err
+	}
+	record.Focus = &focus
+	// get reactions
+	record.Reactions, err = GetReactions(ctx, recordID, userID)
 	if err != nil {
 		return RecordDetail{}, err
 	}
 	return record, nil
 }
 
-func GetRoomRecords(roomID int) ([]RecordDetail, error) {
+func GetRoomRecords(ctx context.Context, roomID int, userID int) ([]RecordDetail, error) {
 	// check if room exists
 	query := `SELECT id FROM room WHERE id = ?`
-	err := mariadb.DB.QueryRow(query, roomID).Scan(&roomID)
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&roomID)
+	if err != nil {
+		logger.WarnCtx(ctx, "Invalid roomID: "+strconv.Itoa(roomID))
+		return nil, err
+	}
+	visible, err := CheckRoomVisible(ctx, roomID, userID)
 	if err != nil {
-		logger.Warn("Invalid roomID: " + strconv.Itoa(roomID))
 		return nil, err
+	} else if !visible {
+		return nil, fmt.Errorf("user not in room")
 	}
 	query = `
-		SELECT r.id, r.image, r.caption, r.time_interval, UNIX_TIMESTAMP(r.finish_time), r.ingredient_id, i.name, i.image, r.interrupt, r.status, u.username
+		SELECT r.id, r.room_id, r.image, COALESCE(r.image_medium, r.image), COALESCE(r.image_thumbnail, r.image), r.caption, r.time_interval, UNIX_TIMESTAMP(r.finish_time), r.ingredient_id, i.name, i.image, r.interrupt, r.status, u.username,
+			(SELECT COUNT(*) FROM record_reaction WHERE record_id = r.id),
+			(SELECT COUNT(*) FROM record_comment WHERE record_id = r.id AND deleted_at IS NULL)
 		FROM record r
 		INNER JOIN ingredient i ON r.ingredient_id = i.id
 		INNER JOIN user u ON r.user_id = u.id
 		WHERE r.room_id = ?
 		ORDER BY status DESC`
-	rows, err := mariadb.DB.Query(query, roomID)
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID)
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +315,7 @@ func GetRoomRecords(roomID int) ([]RecordDetail, error) {
 	var records []RecordDetail
 	for rows.Next() {
 		var record RecordDetail
-		err = rows.Scan(&record.ID, &record.Image, &record.Caption, &record.Interval, &record.FinishTime, &record.IngredientID, &record.IngredientName, &record.IngredientImage, &record.Interrupt, &record.Status, &record.Username)
+		err = rows.Scan(&record.ID, &record.RoomID, &record.Image, &record.ImageMedium, &record.ImageThumbnail, &record.Caption, &record.Interval, &record.FinishTime, &record.IngredientID, &record.IngredientName, &record.IngredientImage, &record.Interrupt, &record.Status, &record.Username, &record.ReactionCount, &record.CommentCount)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,118 @@
+package query
+
+import (
+	"context"
+	"pottogether/pkg/mariadb"
+	"time"
+)
+
+type rollupKey struct {
+	roomID int
+	day    string
+}
+
+type rollupValue struct {
+	total int
+	count int
+}
+
+// RebuildFocusDaily recomputes the focus_daily rows of the last days from the
+// finished records, fixing any drift from the incremental updates. Returns the
+// number of users rebuilt.
+func RebuildFocusDaily(ctx context.Context, days int) (int, error) {
+	// look a day further back since local days can start up to 14 hours
+	// before the UTC one
+	query := `
+		SELECT DISTINCT r.user_id, u.timezone
+		FROM record r
+		INNER JOIN user u ON r.user_id = u.id
+		WHERE r.status = 1 AND r.finish_time >= NOW() - INTERVAL ? DAY
+		UNION
+		SELECT DISTINCT f.user_id, u.timezone
+		FROM focus_daily f
+		INNER JOIN user u ON f.user_id = u.id
+		WHERE f.day >= CURDATE() - INTERVAL ? DAY`
+	rows, err := mariadb.DB.QueryContext(ctx, query, days+1, days+1)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	users := map[int]string{}
+	for rows.Next() {
+		var id int
+		var timezone string
+		if err := rows.Scan(&id, &timezone); err != nil {
+			return 0, err
+		}
+		users[id] = timezone
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	for id, timezone := range users {
+		if err := rebuildUserFocusDaily(ctx, id, timezone, days); err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
+
+// rebuildUserFocusDaily rebuilds the user's rows in one transaction. The
+// user's records are read with a locking read, so that a record finished
+// meanwhile either is counted here or waits to be added on top of the rebuilt
+// rows.
+func rebuildUserFocusDaily(ctx context.Context, userID int, timezone string, days int) error {
+	loc := loadLocation(timezone)
+	from := time.Now().In(loc).AddDate(0, 0, -days).Format(dateLayout)
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	query := `
+		SELECT room_id, time_interval, UNIX_TIMESTAMP(finish_time)
+		FROM record
+		WHERE user_id = ? AND status = 1 AND finish_time >= NOW() - INTERVAL ? DAY
+		FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, userID, days+1)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer rows.Close()
+	rollup := map[rollupKey]rollupValue{}
+	for rows.Next() {
+		var roomID, interval int
+		var finishTime int64
+		if err := rows.Scan(&roomID, &interval, &finishTime); err != nil {
+			tx.Rollback()
+			return err
+		}
+		day := time.Unix(finishTime, 0).In(loc).Format(dateLayout)
+		if day < from {
+			continue
+		}
+		key := rollupKey{roomID, day}
+		value := rollup[key]
+		value.total += interval
+		value.count++
+		rollup[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	rows.Close()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM focus_daily WHERE user_id = ? AND day >= ?", userID, from); err != nil {
+		tx.Rollback()
+		return err
+	}
+	query = "INSERT INTO focus_daily (user_id, room_id, day, total_time, record_cnt) VALUES (?, ?, ?, ?, ?)"
+	for key, value := range rollup {
+		if _, err := tx.ExecContext(ctx, query, userID, key.roomID, key.day, value.total, value.count); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"pottogether/pkg/mariadb"
@@ -34,6 +35,7 @@ type RoomOverview struct {
 	Level      userLevel        `json:"level"`
 	Cooking    []todayRecord    `json:"cooking"`
 	Done       []todayRecord    `json:"done"`
+	Goals      []goalProgress   `json:"goals"`
 }
 
 type roomUser struct {
@@ -48,9 +50,97 @@ type roomDateRecord struct {
 	RoomTotal int    `json:"roomTotal"`
 }
 
-func CreateRoom(room Room, userID int) (int, string, error) {
+func CheckMember(ctx context.Context, roomID int, userID int) (bool, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM room_user WHERE room_id = ? AND user_id = ?)"
+	var exists bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// GetMemberIDs returns the ids of the members of the room
+func GetMemberIDs(ctx context.Context, roomID int) (map[int]bool, error) {
+	query := "SELECT user_id FROM room_user WHERE room_id = ?"
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		members[id] = true
+	}
+	return members, rows.Err()
+}
+
+// CheckRoomVisible reports whether the user can see the records of the room,
+// i.e. the room is public or the user is a member
+func CheckRoomVisible(ctx context.Context, roomID int, userID int) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM room r
+			WHERE r.id = ? AND (r.privacy = 'public'
+				OR EXISTS(SELECT 1 FROM room_user WHERE room_id = r.id AND user_id = ?)))`
+	var visible bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID, userID).Scan(&visible)
+	if err != nil {
+		return false, err
+	}
+	return visible, nil
+}
+
+// CheckAdmin reports whether the user is the owner or an admin of the room
+func CheckAdmin(ctx context.Context, roomID int, userID int) (bool, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM room_user WHERE room_id = ? AND user_id = ? AND role IN ('owner', 'admin'))"
+	var exists bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SetMemberRole lets the room owner promote a member to admin or demote them
+func SetMemberRole(ctx context.Context, roomID int, ownerID int, userID int, role string) error {
+	if role != "admin" && role != "member" {
+		return fmt.Errorf("invalid role")
+	}
+	query := "SELECT EXISTS(SELECT 1 FROM room_user WHERE room_id = ? AND user_id = ? AND role = 'owner')"
+	var isOwner bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID, ownerID).Scan(&isOwner)
+	if err != nil {
+		return err
+	} else if !isOwner {
+		return fmt.Errorf("user is not the room owner")
+	}
+	query = "UPDATE room_user SET role = ? WHERE room_id = ? AND user_id = ? AND role <> 'owner'"
+	result, err := mariadb.DB.ExecContext(ctx, query, role, roomID, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		member, err := CheckMember(ctx, roomID, userID)
+		if err != nil {
+			return err
+		} else if !member {
+			return fmt.Errorf("user not in room")
+		}
+	}
+	return nil
+}
+
+func CreateRoom(ctx context.Context, room Room, userID int) (int, string, error) {
 	// begin transaction
-	tx, err := mariadb.DB.Begin()
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return -1, "", err
 	}
@@ -59,7 +149,7 @@ func CreateRoom(room Room, userID int) (int, string, error) {
 	query := `
 		INSERT INTO room (roomname, current_pot, member_cnt, member_limit, privacy, category, level, total_time, created_at) 
 		VALUES (?, ?, ?, ?, ?, ?, 1, 0, NOW())`
-	result, err := tx.Exec(query, room.Name, potID, 1, room.MemberLimit, room.Privacy, room.Category)
+	result, err := tx.ExecContext(ctx, query, room.Name, potID, 1, room.MemberLimit, room.Privacy, room.Category)
 	if err != nil {
 		tx.Rollback()
 		return -1, "", err
@@ -74,16 +164,16 @@ func CreateRoom(room Room, userID int) (int, string, error) {
 	query = `
 		INSERT INTO pot (id, room_id)
 		VALUES (?, ?)`
-	_, err = tx.Exec(query, potID, id)
+	_, err = tx.ExecContext(ctx, query, potID, id)
 	if err != nil {
 		tx.Rollback()
 		return -1, "", err
 	}
-	// add user to room
+	// add user to room as its owner
 	query = `
-		INSERT INTO room_user (user_id, room_id)
-		VALUES (?, ?)`
-	_, err = tx.Exec(query, userID, id)
+		INSERT INTO room_user (user_id, room_id, role)
+		VALUES (?, ?, 'owner')`
+	_, err = tx.ExecContext(ctx, query, userID, id)
 	if err != nil {
 		tx.Rollback()
 		return -1, "", err
@@ -97,14 +187,14 @@ func CreateRoom(room Room, userID int) (int, string, error) {
 	return int(id), potID, nil
 }
 
-func GetRooms(userID int) ([]RoomDetail, error) {
+func GetRooms(ctx context.Context, userID int) ([]RoomDetail, error) {
 	rooms := []RoomDetail{}
 	query := `
 		SELECT r.id, r.roomname, r.member_cnt, r.member_limit, r.category
 		FROM room r
 		INNER JOIN room_user ru ON r.id = ru.room_id
 		WHERE ru.user_id = ?`
-	rows, err := mariadb.DB.Query(query, userID)
+	rows, err := mariadb.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return rooms, nil
@@ -124,13 +214,13 @@ func GetRooms(userID int) ([]RoomDetail, error) {
 	return rooms, nil
 }
 
-func GetPublicRooms() ([]RoomDetail, error) {
+func GetPublicRooms(ctx context.Context) ([]RoomDetail, error) {
 	rooms := []RoomDetail{}
 	query := `
 		SELECT r.id, r.roomname, r.member_cnt, r.member_limit, r.category
 		FROM room r
 		WHERE r.privacy = 'public'`
-	rows, err := mariadb.DB.Query(query)
+	rows, err := mariadb.DB.QueryContext(ctx, query)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return rooms, nil
@@ -150,11 +240,11 @@ func GetPublicRooms() ([]RoomDetail, error) {
 	return rooms, nil
 }
 
-func GetRoomOverview(roomID int, userID int) (RoomOverview, error) {
+func GetRoomOverview(ctx context.Context, roomID int, userID int) (RoomOverview, error) {
 	// Check if room exists
 	query := "SELECT EXISTS(SELECT 1 FROM room WHERE id = ?)"
 	var exists bool
-	err := mariadb.DB.QueryRow(query, roomID).Scan(&exists)
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&exists)
 	if err != nil {
 		return RoomOverview{}, err
 	} else if !exists {
@@ -165,19 +255,19 @@ func GetRoomOverview(roomID int, userID int) (RoomOverview, error) {
 	query = `
 		SELECT r.id, r.roomname, r.current_pot, r.level, r.total_time
 		FROM room r WHERE r.id = ?`
-	err = mariadb.DB.QueryRow(query, roomID).Scan(&room.ID, &room.Name, &room.CurrentPot, &room.Level.Level, &room.Level.TotalTime)
+	err = mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&room.ID, &room.Name, &room.CurrentPot, &room.Level.Level, &room.Level.TotalTime)
 	if err != nil {
 		return room, err
 	}
 	// Get next level
-	room.Level.Next, err = getNextLevel(room.Level.Level)
+	room.Level.Next, err = getNextLevel(ctx, room.Level.Level)
 	// Get room members
 	query = `
 		SELECT u.id, u.avatar, u.username
 		FROM room_user ru
 		INNER JOIN user u ON ru.user_id = u.id
 		WHERE ru.room_id = ?`
-	rows, err := mariadb.DB.Query(query, roomID)
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID)
 	if err != nil {
 		return room, err
 	}
@@ -190,24 +280,29 @@ func GetRoomOverview(roomID int, userID int) (RoomOverview, error) {
 		room.Members = append(room.Members, member)
 	}
 	// Get week interval
-	room.Week, err = getRoomWeekInterval(roomID, userID)
+	room.Week, err = getRoomWeekInterval(ctx, roomID, userID)
 	if err != nil {
 		return room, err
 	}
 	// Get cooking records
-	room.Cooking, err = getCookingRecords(roomID)
+	room.Cooking, err = getCookingRecords(ctx, roomID)
 	if err != nil {
 		return room, err
 	}
 	// Get done records
-	room.Done, err = getDoneRecords(roomID)
+	room.Done, err = getDoneRecords(ctx, roomID)
+	if err != nil {
+		return room, err
+	}
+	// Get goal progress
+	room.Goals, err = getRoomGoalProgress(ctx, roomID)
 	if err != nil {
 		return room, err
 	}
 	return room, nil
 }
 
-func getRoomWeekInterval(roomID int, userID int) ([]roomDateRecord, error) {
+func getRoomWeekInterval(ctx context.Context, roomID int, userID int) ([]roomDateRecord, error) {
 	weekInterval := []roomDateRecord{}
 	// Get room records
 	query := `
@@ -215,9 +310,9 @@ func getRoomWeekInterval(roomID int, userID int) ([]roomDateRecord, error) {
 			DATE(created_at) AS date,
 			SUM(time_interval) AS total_time
 		FROM record
-		WHERE WEEK(created_at) = WEEK(NOW()) AND room_id = ?
+		WHERE YEARWEEK(created_at, 1) = YEARWEEK(NOW(), 1) AND room_id = ?
 		GROUP BY date`
-	rows, err := mariadb.DB.Query(query, roomID)
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return weekInterval, nil
@@ -238,9 +333,9 @@ func getRoomWeekInterval(roomID int, userID int) ([]roomDateRecord, error) {
 			DATE(created_at) AS date,
 			SUM(time_interval) AS total_time
 		FROM record
-		WHERE WEEK(created_at) = WEEK(NOW()) AND user_id = ? AND room_id = ?
+		WHERE YEARWEEK(created_at, 1) = YEARWEEK(NOW(), 1) AND user_id = ? AND room_id = ?
 		GROUP BY date`
-	rows, err = mariadb.DB.Query(query, userID, roomID)
+	rows, err = mariadb.DB.QueryContext(ctx, query, userID, roomID)
 	if err != nil && err != sql.ErrNoRows {
 		return weekInterval, err
 	}
@@ -260,16 +355,16 @@ func getRoomWeekInterval(roomID int, userID int) ([]roomDateRecord, error) {
 	return weekInterval, nil
 }
 
-func getCookingRecords(roomID int) ([]todayRecord, error) {
+func getCookingRecords(ctx context.Context, roomID int) ([]todayRecord, error) {
 	records := []todayRecord{}
 	query := `
 		SELECT r.id, i.image
 		FROM record r
 		INNER JOIN ingredient i
 		ON r.ingredient_id = i.id
-		WHERE r.room_id = ? AND r.status = 0
+		WHERE r.room_id = ? AND ` + activeRecord + `
 		ORDER BY r.created_at DESC`
-	rows, err := mariadb.DB.Query(query, roomID)
+	rows, err := mariadb.DB.QueryContext(ctx, query, append([]interface{}{roomID}, activeRecordArgs()...)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return records, nil
@@ -287,7 +382,7 @@ func getCookingRecords(roomID int) ([]todayRecord, error) {
 	return records, nil
 }
 
-func getDoneRecords(roomID int) ([]todayRecord, error) {
+func getDoneRecords(ctx context.Context, roomID int) ([]todayRecord, error) {
 	records := []todayRecord{}
 	query := `
 		SELECT r.id, i.image
@@ -296,7 +391,7 @@ func getDoneRecords(roomID int) ([]todayRecord, error) {
 		ON r.ingredient_id = i.id
 		WHERE r.room_id = ? AND r.status = 1
 		ORDER BY r.created_at DESC`
-	rows, err := mariadb.DB.Query(query, roomID)
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return records, nil
@@ -314,11 +409,11 @@ func getDoneRecords(roomID int) ([]todayRecord, error) {
 	return records, nil
 }
 
-func JoinRoom(roomID int, userID int) error {
+func JoinRoom(ctx context.Context, roomID int, userID int) error {
 	// Check if room exists
 	query := "SELECT EXISTS(SELECT 1 FROM room WHERE id = ?)"
 	var exists bool
-	err := mariadb.DB.QueryRow(query, roomID).Scan(&exists)
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&exists)
 	if err != nil {
 		return err
 	} else if !exists {
@@ -326,7 +421,7 @@ func JoinRoom(roomID int, userID int) error {
 	}
 	// Check if user is already in room
 	query = "SELECT EXISTS(SELECT 1 FROM room_user WHERE room_id = ? AND user_id = ?)"
-	err = mariadb.DB.QueryRow(query, roomID, userID).Scan(&exists)
+	err = mariadb.DB.QueryRowContext(ctx, query, roomID, userID).Scan(&exists)
 	if err != nil {
 		return err
 	} else if exists {
@@ -335,14 +430,14 @@ func JoinRoom(roomID int, userID int) error {
 	// Check if room is full
 	query = "SELECT member_cnt, member_limit FROM room WHERE id = ?"
 	var memberCnt, memberLimit int
-	err = mariadb.DB.QueryRow(query, roomID).Scan(&memberCnt, &memberLimit)
+	err = mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&memberCnt, &memberLimit)
 	if err != nil {
 		return err
 	} else if memberCnt >= memberLimit {
 		return fmt.Errorf("room is full")
 	}
 	// Begin transaction
-	tx, err := mariadb.DB.Begin()
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -350,7 +445,7 @@ func JoinRoom(roomID int, userID int) error {
 	query = `
 		INSERT INTO room_user (user_id, room_id)
 		VALUES (?, ?)`
-	_, err = mariadb.DB.Exec(query, userID, roomID)
+	_, err = mariadb.DB.ExecContext(ctx, query, userID, roomID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -360,7 +455,7 @@ func JoinRoom(roomID int, userID int) error {
 		UPDATE room
 		SET member_cnt = member_cnt + 1
 		WHERE id = ?`
-	_, err = mariadb.DB.Exec(query, roomID)
+	_, err = mariadb.DB.ExecContext(ctx, query, roomID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -374,44 +469,62 @@ func JoinRoom(roomID int, userID int) error {
 	return nil
 }
 
-func LeaveRoom(roomID int, userID int) error {
+// LeaveRoom removes the user from the room. An owner leaving hands the room
+// over to an admin, or to the longest registered member if there is none.
+func LeaveRoom(ctx context.Context, roomID int, userID int) error {
 	// Check if room exists
 	query := "SELECT EXISTS(SELECT 1 FROM room WHERE id = ?)"
 	var exists bool
-	err := mariadb.DB.QueryRow(query, roomID).Scan(&exists)
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&exists)
 	if err != nil {
 		return err
 	} else if !exists {
 		return fmt.Errorf("room does not exist")
 	}
-	// Check if user is in room
-	query = "SELECT EXISTS(SELECT 1 FROM room_user WHERE room_id = ? AND user_id = ?)"
-	err = mariadb.DB.QueryRow(query, roomID, userID).Scan(&exists)
+	// Begin transaction
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
-	} else if !exists {
-		return fmt.Errorf("user not in room")
 	}
-	// Begin transaction
-	tx, err := mariadb.DB.Begin()
+	// Check if user is in room
+	var role string
+	query = "SELECT role FROM room_user WHERE room_id = ? AND user_id = ? FOR UPDATE"
+	err = tx.QueryRowContext(ctx, query, roomID, userID).Scan(&role)
 	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user not in room")
+		}
 		return err
 	}
 	// Remove user from room
 	query = `
 		DELETE FROM room_user
 		WHERE user_id = ? AND room_id = ?`
-	_, err = mariadb.DB.Exec(query, userID, roomID)
+	_, err = tx.ExecContext(ctx, query, userID, roomID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	// Hand the room over
+	if role == "owner" {
+		query = `
+			UPDATE room_user SET role = 'owner'
+			WHERE room_id = ?
+			ORDER BY role = 'admin' DESC, user_id
+			LIMIT 1`
+		_, err = tx.ExecContext(ctx, query, roomID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	// Update member count
 	query = `
 		UPDATE room
 		SET member_cnt = member_cnt - 1
 		WHERE id = ?`
-	_, err = mariadb.DB.Exec(query, roomID)
+	_, err = tx.ExecContext(ctx, query, roomID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -0,0 +1,172 @@
+package query
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"pottogether/pkg/mariadb"
+)
+
+type ScheduledEvent struct {
+	ID          int     `json:"eventID"`
+	RoomID      int     `json:"roomID"`
+	RoomName    string  `json:"roomName"`
+	CreatedBy   int     `json:"createdBy"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	StartsAt    int     `json:"startsAt"`
+	Duration    int     `json:"duration"`
+	RRule       string  `json:"rrule"`
+	Timezone    string  `json:"timezone"`
+	Going       int     `json:"going"`
+	Response    *string `json:"response"`
+}
+
+// scheduleQuery selects events with the number of members going and the RSVP
+// of a user, taking that user id first
+const scheduleQuery = `
+	SELECT e.id, e.room_id, r.roomname, e.created_by, e.title, e.description,
+		UNIX_TIMESTAMP(e.starts_at), e.duration, e.rrule, e.timezone,
+		(SELECT COUNT(*) FROM room_event_rsvp WHERE event_id = e.id AND response = 'yes'),
+		(SELECT response FROM room_event_rsvp WHERE event_id = e.id AND user_id = ?)
+	FROM room_event e
+	INNER JOIN room r ON e.room_id = r.id`
+
+// CreateScheduledEvent schedules a session, repeating in the timezone of the
+// event or else in the timezone of the admin scheduling it
+func CreateScheduledEvent(ctx context.Context, event ScheduledEvent) (int, error) {
+	admin, err := CheckAdmin(ctx, event.RoomID, event.CreatedBy)
+	if err != nil {
+		return -1, err
+	} else if !admin {
+		return -1, fmt.Errorf("user is not a room admin")
+	}
+	query := `
+		INSERT INTO room_event (room_id, created_by, title, description, starts_at, duration, rrule, timezone, created_at)
+		VALUES (?, ?, ?, ?, FROM_UNIXTIME(?), ?, ?, COALESCE(NULLIF(?, ''), (SELECT timezone FROM user WHERE id = ?)), NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, event.RoomID, event.CreatedBy, event.Title, event.Description, event.StartsAt, event.Duration, event.RRule, event.Timezone, event.CreatedBy)
+	if err != nil {
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+func DeleteScheduledEvent(ctx context.Context, roomID int, eventID int, userID int) error {
+	admin, err := CheckAdmin(ctx, roomID, userID)
+	if err != nil {
+		return err
+	} else if !admin {
+		return fmt.Errorf("user is not a room admin")
+	}
+	query := "UPDATE room_event SET deleted_at = NOW() WHERE id = ? AND room_id = ? AND deleted_at IS NULL"
+	result, err := mariadb.DB.ExecContext(ctx, query, eventID, roomID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("event does not exist")
+	}
+	return nil
+}
+
+// SetRSVP stores a member's response to a scheduled session
+func SetRSVP(ctx context.Context, roomID int, eventID int, userID int, response string) error {
+	if response != "yes" && response != "no" && response != "maybe" {
+		return fmt.Errorf("invalid response")
+	}
+	member, err := CheckMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	} else if !member {
+		return fmt.Errorf("user not in room")
+	}
+	query := "SELECT EXISTS(SELECT 1 FROM room_event WHERE id = ? AND room_id = ? AND deleted_at IS NULL)"
+	var exists bool
+	err = mariadb.DB.QueryRowContext(ctx, query, eventID, roomID).Scan(&exists)
+	if err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("event does not exist")
+	}
+	query = `
+		INSERT INTO room_event_rsvp (event_id, user_id, response, updated_at)
+		VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE response = VALUES(response), updated_at = NOW()`
+	_, err = mariadb.DB.ExecContext(ctx, query, eventID, userID, response)
+	return err
+}
+
+// GetRoomSchedule returns the room's scheduled sessions with the user's RSVP
+func GetRoomSchedule(ctx context.Context, roomID int, userID int) ([]ScheduledEvent, error) {
+	query := scheduleQuery + `
+		WHERE e.room_id = ? AND e.deleted_at IS NULL
+		ORDER BY e.starts_at`
+	return getSchedule(ctx, query, userID, roomID)
+}
+
+// GetUserSchedule returns the scheduled sessions of every room the user is in,
+// except the ones they declined
+func GetUserSchedule(ctx context.Context, userID int) ([]ScheduledEvent, error) {
+	query := scheduleQuery + `
+		INNER JOIN room_user ru ON ru.room_id = e.room_id AND ru.user_id = ?
+		WHERE e.deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM room_event_rsvp WHERE event_id = e.id AND user_id = ? AND response = 'no')
+		ORDER BY e.starts_at`
+	return getSchedule(ctx, query, userID, userID, userID)
+}
+
+func getSchedule(ctx context.Context, query string, args ...interface{}) ([]ScheduledEvent, error) {
+	events := []ScheduledEvent{}
+	rows, err := mariadb.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ScheduledEvent
+		if err := rows.Scan(&e.ID, &e.RoomID, &e.RoomName, &e.CreatedBy, &e.Title, &e.Description, &e.StartsAt, &e.Duration, &e.RRule, &e.Timezone, &e.Going, &e.Response); err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// RotateCalendarToken creates a new secret for the user's calendar feed,
+// invalidating the previous feed URL
+func RotateCalendarToken(ctx context.Context, userID int) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	query := "UPDATE user SET calendar_token = ? WHERE id = ?"
+	_, err := mariadb.DB.ExecContext(ctx, query, token, userID)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetCalendarUser returns the user owning the calendar feed token
+func GetCalendarUser(ctx context.Context, token string) (int, error) {
+	var id int
+	query := "SELECT id FROM user WHERE calendar_token = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, token).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return -1, fmt.Errorf("invalid calendar token")
+		}
+		return -1, err
+	}
+	return id, nil
+}
@@ -0,0 +1,370 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/internal/metrics"
+	"pottogether/pkg/logger"
+	"pottogether/pkg/mariadb"
+)
+
+type GroupSession struct {
+	ID           int    `json:"sessionID"`
+	RoomID       int    `json:"roomID"`
+	CreatedBy    int    `json:"createdBy"`
+	FocusLength  int    `json:"focusLength"`
+	BreakLength  int    `json:"breakLength"`
+	Cycles       int    `json:"cycles"`
+	StartsAt     int    `json:"startsAt"`
+	Status       string `json:"status"`
+	Phase        string `json:"phase"`
+	Cycle        int    `json:"cycle"`
+	PhaseEndsAt  int    `json:"phaseEndsAt"`
+	Participants []int  `json:"participants"`
+}
+
+const sessionColumns = `
+	id, room_id, created_by, focus_length, break_length, cycles, UNIX_TIMESTAMP(starts_at),
+	status, COALESCE(phase, ''), cycle, COALESCE(UNIX_TIMESTAMP(phase_ends_at), 0)`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (GroupSession, error) {
+	var s GroupSession
+	err := row.Scan(&s.ID, &s.RoomID, &s.CreatedBy, &s.FocusLength, &s.BreakLength, &s.Cycles, &s.StartsAt, &s.Status, &s.Phase, &s.Cycle, &s.PhaseEndsAt)
+	return s, err
+}
+
+// CreateSession schedules a group session. startsAt is a unix timestamp.
+func CreateSession(ctx context.Context, session GroupSession) (int, error) {
+	admin, err := CheckAdmin(ctx, session.RoomID, session.CreatedBy)
+	if err != nil {
+		return -1, err
+	} else if !admin {
+		return -1, fmt.Errorf("user is not a room admin")
+	}
+	query := `
+		INSERT INTO group_session (room_id, created_by, focus_length, break_length, cycles, starts_at, status, created_at)
+		VALUES (?, ?, ?, ?, ?, FROM_UNIXTIME(?), 'scheduled', NOW())`
+	result, err := mariadb.DB.ExecContext(ctx, query, session.RoomID, session.CreatedBy, session.FocusLength, session.BreakLength, session.Cycles, session.StartsAt)
+	if err != nil {
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+func GetSession(ctx context.Context, sessionID int) (GroupSession, error) {
+	query := "SELECT " + sessionColumns + " FROM group_session WHERE id = ?"
+	session, err := scanSession(mariadb.DB.QueryRowContext(ctx, query, sessionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return session, fmt.Errorf("session does not exist")
+		}
+		return session, err
+	}
+	session.Participants, err = getSessionParticipants(ctx, sessionID)
+	return session, err
+}
+
+// GetRoomSessions returns the scheduled and running sessions of the room
+func GetRoomSessions(ctx context.Context, roomID int) ([]GroupSession, error) {
+	sessions := []GroupSession{}
+	query := `
+		SELECT ` + sessionColumns + ` FROM group_session
+		WHERE room_id = ? AND status IN ('scheduled', 'running')
+		ORDER BY starts_at`
+	rows, err := mariadb.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, session)
+	}
+	rows.Close()
+	for i := range sessions {
+		if sessions[i].Participants, err = getSessionParticipants(ctx, sessions[i].ID); err != nil {
+			return sessions, err
+		}
+	}
+	return sessions, nil
+}
+
+// GetActiveSessionIDs returns the sessions the server still has to drive
+func GetActiveSessionIDs(ctx context.Context) ([]int, error) {
+	ids := []int{}
+	query := "SELECT id FROM group_session WHERE status IN ('scheduled', 'running')"
+	rows, err := mariadb.DB.QueryContext(ctx, query)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func getSessionParticipants(ctx context.Context, sessionID int) ([]int, error) {
+	participants := []int{}
+	query := "SELECT user_id FROM group_session_participant WHERE session_id = ? ORDER BY joined_at"
+	rows, err := mariadb.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return participants, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return participants, err
+		}
+		participants = append(participants, id)
+	}
+	return participants, nil
+}
+
+// JoinSession opts a room member into a session that has not ended. The
+// member starts cooking with the next focus phase.
+func JoinSession(ctx context.Context, sessionID int, userID int, ingredientID int) error {
+	session, err := GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	} else if session.Status != "scheduled" && session.Status != "running" {
+		return fmt.Errorf("session has ended")
+	}
+	member, err := CheckMember(ctx, session.RoomID, userID)
+	if err != nil {
+		return err
+	} else if !member {
+		return fmt.Errorf("user not in room")
+	}
+	query := `
+		INSERT INTO group_session_participant (session_id, user_id, ingredient_id, joined_at)
+		VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE ingredient_id = VALUES(ingredient_id)`
+	_, err = mariadb.DB.ExecContext(ctx, query, sessionID, userID, ingredientID)
+	return err
+}
+
+// LeaveSession opts a member out, abandoning the record of the current phase
+func LeaveSession(ctx context.Context, sessionID int, userID int) error {
+	query := "DELETE FROM group_session_participant WHERE session_id = ? AND user_id = ?"
+	result, err := mariadb.DB.ExecContext(ctx, query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("user not in session")
+	}
+	query = "UPDATE record SET status = 2, finish_time = NOW() WHERE session_id = ? AND user_id = ? AND status = 0"
+	_, err = mariadb.DB.ExecContext(ctx, query, sessionID, userID)
+	return err
+}
+
+// CancelSession ends a session early, abandoning the records of the current phase
+func CancelSession(ctx context.Context, sessionID int, userID int) (GroupSession, error) {
+	session, err := GetSession(ctx, sessionID)
+	if err != nil {
+		return session, err
+	} else if session.Status != "scheduled" && session.Status != "running" {
+		return session, fmt.Errorf("session has ended")
+	}
+	admin, err := CheckAdmin(ctx, session.RoomID, userID)
+	if err != nil {
+		return session, err
+	} else if !admin {
+		return session, fmt.Errorf("user is not a room admin")
+	}
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return session, err
+	}
+	query := "UPDATE group_session SET status = 'cancelled', phase = NULL, phase_ends_at = NULL WHERE id = ?"
+	if _, err = tx.ExecContext(ctx, query, sessionID); err != nil {
+		tx.Rollback()
+		return session, err
+	}
+	query = "UPDATE record SET status = 2, finish_time = NOW() WHERE session_id = ? AND status = 0"
+	if _, err = tx.ExecContext(ctx, query, sessionID); err != nil {
+		tx.Rollback()
+		return session, err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return session, err
+	}
+	return GetSession(ctx, sessionID)
+}
+
+// StartFocusPhase moves the session into the focus phase of the next cycle,
+// finishing the records of the focus phase that ended, and starts a linked
+// record for every participant. Nothing is done when the session is no longer
+// in the state from was read in, so concurrent runners apply it once.
+func StartFocusPhase(ctx context.Context, from GroupSession, bonusPercent int) error {
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	applied, err := transitionSession(ctx, tx, from,
+		"status = 'running', phase = 'focus', cycle = ?, phase_ends_at = DATE_ADD(NOW(), INTERVAL focus_length SECOND)", from.Cycle+1)
+	if err != nil || !applied {
+		tx.Rollback()
+		return err
+	}
+	var finished []sessionRecord
+	if from.Phase == "focus" {
+		if finished, err = finishSessionRecords(ctx, tx, from.ID, bonusPercent); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	query := `
+		INSERT INTO record (user_id, room_id, pot_id, ingredient_id, time_interval, interrupt, status, created_at, finish_time, image, caption, session_id)
+		SELECT p.user_id, s.room_id, r.current_pot, p.ingredient_id, 0, 0, 0, NOW(), NOW(), "null", "null", s.id
+		FROM group_session_participant p
+		INNER JOIN group_session s ON p.session_id = s.id
+		INNER JOIN room r ON s.room_id = r.id
+		WHERE s.id = ?`
+	if _, err = tx.ExecContext(ctx, query, from.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	afterSessionRecords(ctx, finished)
+	return nil
+}
+
+// StartBreakPhase finishes the records of the focus phase and moves the
+// session into the break
+func StartBreakPhase(ctx context.Context, from GroupSession, bonusPercent int) error {
+	return finishFocusPhase(ctx, from, bonusPercent,
+		"phase = 'break', phase_ends_at = DATE_ADD(NOW(), INTERVAL break_length SECOND)")
+}
+
+// EndSession finishes the records of the last focus phase and the session
+func EndSession(ctx context.Context, from GroupSession, bonusPercent int) error {
+	return finishFocusPhase(ctx, from, bonusPercent, "status = 'finished', phase = NULL, phase_ends_at = NULL")
+}
+
+func finishFocusPhase(ctx context.Context, from GroupSession, bonusPercent int, set string) error {
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	applied, err := transitionSession(ctx, tx, from, set)
+	if err != nil || !applied {
+		tx.Rollback()
+		return err
+	}
+	finished, err := finishSessionRecords(ctx, tx, from.ID, bonusPercent)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	afterSessionRecords(ctx, finished)
+	return nil
+}
+
+// transitionSession moves the session out of the state it was read in,
+// returning false when it is no longer in that state because another runner
+// already moved it. The updated row stays locked until the transaction ends.
+func transitionSession(ctx context.Context, tx *sql.Tx, from GroupSession, set string, args ...interface{}) (bool, error) {
+	query := `
+		UPDATE group_session SET ` + set + `
+		WHERE id = ? AND status = ? AND COALESCE(phase, '') = ? AND cycle = ?`
+	args = append(args, from.ID, from.Status, from.Phase, from.Cycle)
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+type sessionRecord struct {
+	id, userID, roomID, interval int
+	timezone                     string
+}
+
+// finishSessionRecords finishes the records of the focus phase. Their time is
+// added to the room total with an extra bonusPercent for cooking together.
+func finishSessionRecords(ctx context.Context, tx *sql.Tx, sessionID int, bonusPercent int) ([]sessionRecord, error) {
+	records := []sessionRecord{}
+	query := `
+		SELECT r.id, r.user_id, r.room_id, s.focus_length, u.timezone
+		FROM record r
+		INNER JOIN group_session s ON r.session_id = s.id
+		INNER JOIN user u ON r.user_id = u.id
+		WHERE r.session_id = ? AND r.status = 0
+		FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return records, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r sessionRecord
+		if err := rows.Scan(&r.id, &r.userID, &r.roomID, &r.interval, &r.timezone); err != nil {
+			return records, err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return records, err
+	}
+	rows.Close()
+	if len(records) == 0 {
+		return records, nil
+	}
+	total := 0
+	for _, r := range records {
+		query = "UPDATE record SET status = 1, time_interval = ?, finish_time = NOW() WHERE id = ?"
+		if _, err = tx.ExecContext(ctx, query, r.interval, r.id); err != nil {
+			return records, err
+		}
+		if err = addToRollup(ctx, tx, r.userID, r.roomID, r.timezone, r.interval); err != nil {
+			return records, err
+		}
+		total += r.interval
+	}
+	query = "UPDATE room SET total_time = total_time + ? WHERE id = ?"
+	if _, err = tx.ExecContext(ctx, query, total+total*bonusPercent/100, records[0].roomID); err != nil {
+		return records, err
+	}
+	return records, nil
+}
+
+// afterSessionRecords counts the finished records once their transaction is
+// committed
+func afterSessionRecords(ctx context.Context, records []sessionRecord) {
+	for _, r := range records {
+		metrics.RecordFinished()
+		if err := recordGoalCompletions(ctx, r.userID, r.roomID); err != nil {
+			logger.WarnCtx(ctx, "Error recording goal completions: "+err.Error())
+		}
+	}
+}
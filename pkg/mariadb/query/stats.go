@@ -0,0 +1,237 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"pottogether/pkg/mariadb"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// StatsRange is an inclusive date range split into buckets of the given granularity
+type StatsRange struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Granularity string `json:"granularity"`
+}
+
+type Stats struct {
+	Range       StatsRange        `json:"range"`
+	Summary     statsSummary      `json:"summary"`
+	Series      []statsBucket     `json:"series"`
+	Ingredients []ingredientStats `json:"ingredients"`
+	Hourly      []hourlyStats     `json:"hourly"`
+}
+
+type statsSummary struct {
+	TotalTime      int     `json:"totalTime"`
+	SessionCount   int     `json:"sessionCount"`
+	AverageLength  int     `json:"averageLength"`
+	InterruptCount int     `json:"interruptCount"`
+	InterruptRate  float64 `json:"interruptRate"`
+	// Interrupts per hour of focus
+	InterruptsPerHour float64 `json:"interruptsPerHour"`
+	// Longest uninterrupted stretch of a single session
+	LongestStretch int `json:"longestStretch"`
+}
+
+type statsBucket struct {
+	Date         string `json:"date"`
+	TotalTime    int    `json:"totalTime"`
+	SessionCount int    `json:"sessionCount"`
+}
+
+type ingredientStats struct {
+	IngredientID int    `json:"ingredientID"`
+	Name         string `json:"name"`
+	Image        string `json:"image"`
+	TotalTime    int    `json:"totalTime"`
+	SessionCount int    `json:"sessionCount"`
+}
+
+// NewStatsRange validates the range, defaulting to the last 30 days by day.
+// Missing dates are filled in by getStats, relative to the viewer's today.
+func NewStatsRange(from string, to string, granularity string) (StatsRange, error) {
+	r := StatsRange{From: from, To: to, Granularity: granularity}
+	if r.Granularity == "" {
+		r.Granularity = "day"
+	}
+	if r.Granularity != "day" && r.Granularity != "week" && r.Granularity != "month" {
+		return r, fmt.Errorf("invalid granularity")
+	}
+	for _, date := range []string{r.From, r.To} {
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			return r, fmt.Errorf("invalid date range")
+		}
+	}
+	if r.From != "" && r.To != "" && r.From > r.To {
+		return r, fmt.Errorf("invalid date range")
+	}
+	return r, nil
+}
+
+// inLocation fills in the missing dates of the range relative to today in loc
+// and returns the unix times at which its first day starts and the day after
+// its last day starts there
+func (r StatsRange) inLocation(loc *time.Location) (StatsRange, int64, int64, error) {
+	toDate := time.Now().In(loc)
+	toDate = time.Date(toDate.Year(), toDate.Month(), toDate.Day(), 0, 0, 0, 0, loc)
+	if r.To != "" {
+		var err error
+		if toDate, err = time.ParseInLocation(dateLayout, r.To, loc); err != nil {
+			return r, 0, 0, fmt.Errorf("invalid date range")
+		}
+	}
+	fromDate := toDate.AddDate(0, 0, -29)
+	if r.From != "" {
+		var err error
+		if fromDate, err = time.ParseInLocation(dateLayout, r.From, loc); err != nil {
+			return r, 0, 0, fmt.Errorf("invalid date range")
+		}
+	}
+	r.From = fromDate.Format(dateLayout)
+	r.To = toDate.Format(dateLayout)
+	if r.From > r.To {
+		return r, 0, 0, fmt.Errorf("invalid date range")
+	}
+	return r, fromDate.Unix(), toDate.AddDate(0, 0, 1).Unix(), nil
+}
+
+// bucketExpr returns the SQL expression mapping local_finish to the first day
+// of its bucket
+func (r StatsRange) bucketExpr() string {
+	switch r.Granularity {
+	case "week":
+		return "DATE_SUB(DATE(local_finish), INTERVAL WEEKDAY(local_finish) DAY)"
+	case "month":
+		return "DATE_FORMAT(local_finish, '%Y-%m-01')"
+	}
+	return "DATE(local_finish)"
+}
+
+func GetUserStats(ctx context.Context, userID int, r StatsRange) (Stats, error) {
+	var timezone string
+	query := "SELECT timezone FROM user WHERE id = ?"
+	err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&timezone)
+	if err != nil {
+		return Stats{}, err
+	}
+	return getStats(ctx, "user_id = ?", userID, r, timezone)
+}
+
+func GetRoomStats(ctx context.Context, roomID int, userID int, r StatsRange) (Stats, error) {
+	// Check if room exists
+	query := "SELECT EXISTS(SELECT 1 FROM room WHERE id = ?)"
+	var exists bool
+	err := mariadb.DB.QueryRowContext(ctx, query, roomID).Scan(&exists)
+	if err != nil {
+		return Stats{}, err
+	} else if !exists {
+		return Stats{}, fmt.Errorf("room does not exist")
+	}
+	visible, err := CheckRoomVisible(ctx, roomID, userID)
+	if err != nil {
+		return Stats{}, err
+	} else if !visible {
+		return Stats{}, fmt.Errorf("user not in room")
+	}
+	// Days are the viewer's, like the room heatmap and leaderboard
+	var timezone string
+	query = "SELECT timezone FROM user WHERE id = ?"
+	if err := mariadb.DB.QueryRowContext(ctx, query, userID).Scan(&timezone); err != nil {
+		return Stats{}, err
+	}
+	return getStats(ctx, "room_id = ?", roomID, r, timezone)
+}
+
+// getStats aggregates the finished records matching filter within the range.
+// The dates of the range, the buckets and the time-of-day breakdown are all
+// local to timezone.
+func getStats(ctx context.Context, filter string, id int, r StatsRange, timezone string) (Stats, error) {
+	r, from, to, err := r.inLocation(loadLocation(timezone))
+	if err != nil {
+		return Stats{}, err
+	}
+	result := Stats{Range: r, Series: []statsBucket{}, Ingredients: []ingredientStats{}}
+	// the bounds are instants, so the index on finish_time can be used
+	where := filter + " AND status = 1 AND finish_time >= FROM_UNIXTIME(?) AND finish_time < FROM_UNIXTIME(?)"
+	// Get summary
+	query := `
+		SELECT
+			COALESCE(SUM(time_interval), 0),
+			COUNT(*),
+			COALESCE(SUM(interrupt), 0),
+			COALESCE(SUM(interrupt > 0), 0)
+		FROM record
+		WHERE ` + where
+	var interrupted int
+	err = mariadb.DB.QueryRowContext(ctx, query, id, from, to).Scan(&result.Summary.TotalTime, &result.Summary.SessionCount, &result.Summary.InterruptCount, &interrupted)
+	if err != nil {
+		return result, err
+	}
+	if result.Summary.SessionCount > 0 {
+		result.Summary.AverageLength = result.Summary.TotalTime / result.Summary.SessionCount
+		result.Summary.InterruptRate = float64(interrupted) / float64(result.Summary.SessionCount)
+	}
+	result.Summary.InterruptsPerHour = interruptsPerHour(result.Summary.InterruptCount, result.Summary.TotalTime)
+	result.Summary.LongestStretch, err = getLongestStretch(ctx, where, id, from, to)
+	if err != nil {
+		return result, err
+	}
+	// Get time-of-day breakdown
+	result.Hourly, err = getHourlyStats(ctx, timezone, where, id, from, to)
+	if err != nil {
+		return result, err
+	}
+	// Get series
+	localFinish, localArgs := localTime("finish_time", timezone)
+	query = `
+		SELECT ` + r.bucketExpr() + ` AS bucket, SUM(time_interval), COUNT(*)
+		FROM (
+			SELECT ` + localFinish + ` AS local_finish, time_interval
+			FROM record
+			WHERE ` + where + `
+		) local_record
+		GROUP BY bucket
+		ORDER BY bucket`
+	rows, err := mariadb.DB.QueryContext(ctx, query, append(localArgs, id, from, to)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket statsBucket
+		if err := rows.Scan(&bucket.Date, &bucket.TotalTime, &bucket.SessionCount); err != nil {
+			return result, err
+		}
+		result.Series = append(result.Series, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	// Get per-ingredient breakdown
+	query = `
+		SELECT i.id, i.name, i.image, SUM(record.time_interval) AS total, COUNT(*)
+		FROM record
+		INNER JOIN ingredient i ON record.ingredient_id = i.id
+		WHERE ` + where + `
+		GROUP BY i.id, i.name, i.image
+		ORDER BY total DESC`
+	rows, err = mariadb.DB.QueryContext(ctx, query, id, from, to)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ingredient ingredientStats
+		if err := rows.Scan(&ingredient.IngredientID, &ingredient.Name, &ingredient.Image, &ingredient.TotalTime, &ingredient.SessionCount); err != nil {
+			return result, err
+		}
+		result.Ingredients = append(result.Ingredients, ingredient)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
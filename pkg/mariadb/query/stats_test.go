@@ -0,0 +1,102 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewStatsRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    string
+		granularity string
+		want        StatsRange
+		wantErr     string
+	}{
+		{name: "defaults", want: StatsRange{Granularity: "day"}},
+		{name: "dates kept", from: "2026-10-01", to: "2026-10-18", granularity: "week", want: StatsRange{From: "2026-10-01", To: "2026-10-18", Granularity: "week"}},
+		{name: "invalid granularity", granularity: "year", wantErr: "invalid granularity"},
+		{name: "invalid date", from: "2026-13-01", wantErr: "invalid date range"},
+		{name: "reversed", from: "2026-10-18", to: "2026-10-01", wantErr: "invalid date range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewStatsRange(tt.from, tt.to, tt.granularity)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("NewStatsRange() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewStatsRange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("NewStatsRange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsRangeInLocation(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().In(sydney).Format(dateLayout)
+	tests := []struct {
+		name     string
+		r        StatsRange
+		loc      *time.Location
+		from, to string
+		// the bounds as UTC instants
+		start, end string
+		wantErr    bool
+	}{
+		{
+			name: "local days", r: StatsRange{From: "2026-10-12", To: "2026-10-18"}, loc: sydney,
+			from: "2026-10-12", to: "2026-10-18",
+			start: "2026-10-11T13:00:00Z", end: "2026-10-18T13:00:00Z",
+		},
+		{
+			name: "across daylight saving", r: StatsRange{From: "2026-10-01", To: "2026-10-05"}, loc: sydney,
+			from: "2026-10-01", to: "2026-10-05",
+			start: "2026-09-30T14:00:00Z", end: "2026-10-05T13:00:00Z",
+		},
+		{
+			name: "utc", r: StatsRange{From: "2026-10-12", To: "2026-10-12"}, loc: time.UTC,
+			from: "2026-10-12", to: "2026-10-12",
+			start: "2026-10-12T00:00:00Z", end: "2026-10-13T00:00:00Z",
+		},
+		{name: "default to today", r: StatsRange{}, loc: sydney, to: today},
+		{name: "from after default to", r: StatsRange{From: "2999-01-01"}, loc: sydney, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, start, end, err := tt.r.inLocation(tt.loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("inLocation() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err != nil {
+				return
+			}
+			if tt.from != "" && got.From != tt.from {
+				t.Errorf("From = %s, want %s", got.From, tt.from)
+			}
+			if got.To != tt.to {
+				t.Errorf("To = %s, want %s", got.To, tt.to)
+			}
+			if end-start < 24*3600 {
+				t.Errorf("bounds %d to %d span less than a day", start, end)
+			}
+			if tt.start == "" {
+				return
+			}
+			if s := time.Unix(start, 0).UTC().Format(time.RFC3339); s != tt.start {
+				t.Errorf("start = %s, want %s", s, tt.start)
+			}
+			if e := time.Unix(end, 0).UTC().Format(time.RFC3339); e != tt.end {
+				t.Errorf("end = %s, want %s", e, tt.end)
+			}
+		})
+	}
+}
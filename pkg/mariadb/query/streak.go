@@ -0,0 +1,95 @@
+package query
+
+import (
+	"context"
+	"pottogether/pkg/mariadb"
+	"strconv"
+	"time"
+)
+
+// getStreak counts the consecutive days with focus time ending on the given day
+func getStreak(ctx context.Context, userID int, day time.Time) (int, error) {
+	query := `
+		SELECT DISTINCT day
+		FROM focus_daily
+		WHERE user_id = ? AND day <= ?
+		ORDER BY day DESC
+		LIMIT 366`
+	rows, err := mariadb.DB.QueryContext(ctx, query, userID, day.Format(dateLayout))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	streak := 0
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return 0, err
+		}
+		if d != day.AddDate(0, 0, -streak).Format(dateLayout) {
+			break
+		}
+		streak++
+	}
+	return streak, nil
+}
+
+// SendStreakReminders notifies the users whose local time is at the reminder
+// hour, who focused yesterday but not yet today. Returns the number of
+// reminders sent.
+func SendStreakReminders(ctx context.Context, hour int) (int, error) {
+	type candidate struct {
+		id       int
+		timezone string
+	}
+	// anyone who focused in the last two days may be at risk in some timezone
+	query := `
+		SELECT u.id, u.timezone
+		FROM user u
+		WHERE EXISTS (SELECT 1 FROM focus_daily WHERE user_id = u.id AND day >= CURDATE() - INTERVAL 2 DAY)
+		AND NOT EXISTS (
+			SELECT 1 FROM notification
+			WHERE user_id = u.id AND type = ? AND created_at >= NOW() - INTERVAL 20 HOUR
+		)`
+	rows, err := mariadb.DB.QueryContext(ctx, query, NotifyStreakAtRisk)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	candidates := []candidate{}
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.timezone); err != nil {
+			return 0, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	sent := 0
+	for _, c := range candidates {
+		now := time.Now().In(loadLocation(c.timezone))
+		if now.Hour() != hour {
+			continue
+		}
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		var focusedToday bool
+		query := "SELECT EXISTS(SELECT 1 FROM focus_daily WHERE user_id = ? AND day = ?)"
+		if err := mariadb.DB.QueryRowContext(ctx, query, c.id, today.Format(dateLayout)).Scan(&focusedToday); err != nil {
+			return sent, err
+		} else if focusedToday {
+			continue
+		}
+		streak, err := getStreak(ctx, c.id, today.AddDate(0, 0, -1))
+		if err != nil {
+			return sent, err
+		} else if streak == 0 {
+			continue
+		}
+		body := "Cook something today to keep your " + strconv.Itoa(streak) + " day streak"
+		if err := Notify(ctx, c.id, NotifyStreakAtRisk, "Your streak is at risk", body, map[string]interface{}{"streak": streak}); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
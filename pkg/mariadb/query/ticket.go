@@ -0,0 +1,101 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/pkg/mariadb"
+)
+
+// CreateWSTicket stores the hash of a ticket letting the user connect to the
+// room within ttlSeconds
+func CreateWSTicket(ctx context.Context, ticketHash string, userID int, roomID int, ttlSeconds int) error {
+	query := "INSERT INTO ws_ticket (ticket, user_id, room_id, expires_at) VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))"
+	_, err := mariadb.DB.ExecContext(ctx, query, ticketHash, userID, roomID, ttlSeconds)
+	return err
+}
+
+// RedeemWSTicket uses up the ticket, returning the user and room it was
+// issued for
+func RedeemWSTicket(ctx context.Context, ticketHash string) (int, int, error) {
+	var userID, roomID int
+	var valid bool
+	tx, err := mariadb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, -1, err
+	}
+	query := "SELECT user_id, room_id, expires_at > NOW() FROM ws_ticket WHERE ticket = ? FOR UPDATE"
+	if err = tx.QueryRowContext(ctx, query, ticketHash).Scan(&userID, &roomID, &valid); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return -1, -1, fmt.Errorf("invalid ticket")
+		}
+		return -1, -1, err
+	}
+	query = "DELETE FROM ws_ticket WHERE ticket = ?"
+	if _, err = tx.ExecContext(ctx, query, ticketHash); err != nil {
+		tx.Rollback()
+		return -1, -1, err
+	}
+	if err = tx.Commit(); err != nil {
+		return -1, -1, err
+	}
+	if !valid {
+		return -1, -1, fmt.Errorf("invalid ticket")
+	}
+	return userID, roomID, nil
+}
+
+// DeleteExpiredWSTickets deletes the tickets that were never redeemed
+func DeleteExpiredWSTickets(ctx context.Context) (int, error) {
+	query := "DELETE FROM ws_ticket WHERE expires_at < NOW()"
+	result, err := mariadb.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// CreateOIDCNonce stores the hash of a nonce for a login with the provider
+// within ttlSeconds
+func CreateOIDCNonce(ctx context.Context, nonceHash string, provider string, ttlSeconds int) error {
+	query := "INSERT INTO oidc_nonce (nonce, provider, expires_at) VALUES (?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))"
+	_, err := mariadb.DB.ExecContext(ctx, query, nonceHash, provider, ttlSeconds)
+	return err
+}
+
+// RedeemOIDCNonce uses up the nonce, which must have been issued for the
+// provider and not have expired
+func RedeemOIDCNonce(ctx context.Context, nonceHash string, provider string) error {
+	query := "DELETE FROM oidc_nonce WHERE nonce = ? AND provider = ? AND expires_at > NOW()"
+	result, err := mariadb.DB.ExecContext(ctx, query, nonceHash, provider)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 1 {
+		return fmt.Errorf("invalid nonce")
+	}
+	return nil
+}
+
+// DeleteExpiredOIDCNonces deletes the nonces that were never used
+func DeleteExpiredOIDCNonces(ctx context.Context) (int, error) {
+	query := "DELETE FROM oidc_nonce WHERE expires_at < NOW()"
+	result, err := mariadb.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
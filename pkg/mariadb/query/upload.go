@@ -0,0 +1,180 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pottogether/pkg/mariadb"
+)
+
+type Upload struct {
+	ID          int    `json:"uploadID"`
+	UserID      int    `json:"userID"`
+	Kind        string `json:"kind"`
+	ObjectKey   string `json:"-"`
+	ContentType string `json:"contentType"`
+	MaxBytes    int    `json:"maxBytes"`
+	Status      string `json:"status"`
+	ExpiresAt   int    `json:"expiresAt"`
+}
+
+// CreateUpload stores a pending upload expiring after ttlSeconds
+func CreateUpload(ctx context.Context, upload Upload, ttlSeconds int) (int, error) {
+	query := `
+		INSERT INTO upload (user_id, kind, object_key, content_type, max_bytes, status, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, 'pending', NOW(), DATE_ADD(NOW(), INTERVAL ? SECOND))`
+	result, err := mariadb.DB.ExecContext(ctx, query, upload.UserID, upload.Kind, upload.ObjectKey, upload.ContentType, upload.MaxBytes, ttlSeconds)
+	if err != nil {
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// GetPendingUpload returns an upload of the user that can still be finalized
+func GetPendingUpload(ctx context.Context, uploadID int, userID int) (Upload, error) {
+	var upload Upload
+	var expired bool
+	query := `
+		SELECT id, user_id, kind, object_key, content_type, max_bytes, status, UNIX_TIMESTAMP(expires_at), expires_at < NOW()
+		FROM upload
+		WHERE id = ? AND user_id = ?`
+	err := mariadb.DB.QueryRowContext(ctx, query, uploadID, userID).Scan(&upload.ID, &upload.UserID, &upload.Kind, &upload.ObjectKey, &upload.ContentType, &upload.MaxBytes, &upload.Status, &upload.ExpiresAt, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return upload, fmt.Errorf("upload does not exist")
+		}
+		return upload, err
+	}
+	if upload.Status != "pending" || expired {
+		return upload, fmt.Errorf("upload is no longer pending")
+	}
+	return upload, nil
+}
+
+// StartFinalizingUpload claims a pending upload that has not expired for the
+// request finalizing it, so that it is not expired meanwhile
+func StartFinalizingUpload(ctx context.Context, uploadID int) error {
+	query := `
+		UPDATE upload SET status = 'finalizing', finalizing_at = NOW()
+		WHERE id = ? AND status = 'pending' AND expires_at >= NOW()`
+	return updateUploadStatus(ctx, query, uploadID)
+}
+
+// ReleaseUpload makes an upload that could not be finalized pending again, so
+// that it can be retried until it expires
+func ReleaseUpload(ctx context.Context, uploadID int) error {
+	query := "UPDATE upload SET status = 'pending', finalizing_at = NULL WHERE id = ? AND status = 'finalizing'"
+	_, err := mariadb.DB.ExecContext(ctx, query, uploadID)
+	return err
+}
+
+// FinalizeUpload marks the claimed upload as used, returning an error if it
+// was expired meanwhile
+func FinalizeUpload(ctx context.Context, uploadID int) error {
+	query := "UPDATE upload SET status = 'finalized', finalized_at = NOW() WHERE id = ? AND status = 'finalizing'"
+	return updateUploadStatus(ctx, query, uploadID)
+}
+
+func updateUploadStatus(ctx context.Context, query string, uploadID int) error {
+	result, err := mariadb.DB.ExecContext(ctx, query, uploadID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("upload is no longer pending")
+	}
+	return nil
+}
+
+// GetExpiredUploads returns up to limit pending uploads past their expiry.
+// Uploads being finalized are skipped, unless the request finalizing them
+// died and left them claimed for longer than staleSeconds.
+func GetExpiredUploads(ctx context.Context, limit int, staleSeconds int) ([]Upload, error) {
+	uploads := []Upload{}
+	query := `
+		SELECT id, user_id, kind, object_key, content_type, max_bytes, status, UNIX_TIMESTAMP(expires_at)
+		FROM upload
+		WHERE (status = 'pending' AND expires_at < NOW())
+		OR (status = 'finalizing' AND finalizing_at < NOW() - INTERVAL ? SECOND)
+		ORDER BY expires_at
+		LIMIT ?`
+	rows, err := mariadb.DB.QueryContext(ctx, query, staleSeconds, limit)
+	if err != nil {
+		return uploads, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u Upload
+		if err := rows.Scan(&u.ID, &u.UserID, &u.Kind, &u.ObjectKey, &u.ContentType, &u.MaxBytes, &u.Status, &u.ExpiresAt); err != nil {
+			return uploads, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+func ExpireUpload(ctx context.Context, uploadID int) error {
+	query := "UPDATE upload SET status = 'expired' WHERE id = ? AND status IN ('pending', 'finalizing')"
+	_, err := mariadb.DB.ExecContext(ctx, query, uploadID)
+	return err
+}
+
+// CheckUploadTarget verifies the upload can be attached to the record or
+// ingredient before the image is processed
+func CheckUploadTarget(ctx context.Context, kind string, targetID int, userID int) error {
+	switch kind {
+	case "record":
+		var ownerID int
+		query := "SELECT user_id FROM record WHERE id = ?"
+		err := mariadb.DB.QueryRowContext(ctx, query, targetID).Scan(&ownerID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("record does not exist")
+			}
+			return err
+		} else if ownerID != userID {
+			return fmt.Errorf("record does not belong to user")
+		}
+	case "ingredient":
+		// ingredients have no owner, only the image of a new ingredient can be set
+		var image string
+		query := "SELECT image FROM ingredient WHERE id = ?"
+		err := mariadb.DB.QueryRowContext(ctx, query, targetID).Scan(&image)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("ingredient does not exist")
+			}
+			return err
+		} else if image != "" {
+			return fmt.Errorf("ingredient already has an image")
+		}
+	default:
+		return fmt.Errorf("invalid upload kind")
+	}
+	return nil
+}
+
+// SetRecordImage attaches the image variants to a record
+func SetRecordImage(ctx context.Context, recordID int, image string, medium string, thumbnail string) error {
+	query := "UPDATE record SET image = ?, image_medium = ?, image_thumbnail = ? WHERE id = ?"
+	if _, err := mariadb.DB.ExecContext(ctx, query, image, medium, thumbnail, recordID); err != nil {
+		return err
+	}
+	return attachMedia(ctx, mariadb.DB, "record", recordID, image, medium, thumbnail)
+}
+
+// SetIngredientImage attaches the image variants to an ingredient
+func SetIngredientImage(ctx context.Context, ingredientID int, image string, medium string, thumbnail string) error {
+	query := "UPDATE ingredient SET image = ?, image_medium = ?, image_thumbnail = ? WHERE id = ?"
+	if _, err := mariadb.DB.ExecContext(ctx, query, image, medium, thumbnail, ingredientID); err != nil {
+		return err
+	}
+	return attachMedia(ctx, mariadb.DB, "ingredient", ingredientID, image, medium, thumbnail)
+}
@@ -1,10 +1,14 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"pottogether/internal/hash"
+	"pottogether/pkg/logger"
 	"pottogether/pkg/mariadb"
+	"strconv"
+	"time"
 )
 
 type User struct {
@@ -15,6 +19,11 @@ type User struct {
 	Password string `json:"password"`
 }
 
+type UserSettings struct {
+	Timezone          *string `json:"timezone"`
+	ProfileVisibility *string `json:"profileVisibility"`
+}
+
 type UserProfile struct {
 	ID          int        `json:"userID"`
 	Name        string     `json:"name"`
@@ -30,11 +39,12 @@ type userStatus struct {
 }
 
 type UserOverview struct {
-	ID    int           `json:"userID"`
-	Level userLevel     `json:"level"`
-	Today []todayRecord `json:"today"`
-	Week  []dateRecord  `json:"week"`
-	Month []dateRecord  `json:"month"`
+	ID    int            `json:"userID"`
+	Level userLevel      `json:"level"`
+	Today []todayRecord  `json:"today"`
+	Week  []dateRecord   `json:"week"`
+	Month []dateRecord   `json:"month"`
+	Goals []goalProgress `json:"goals"`
 }
 
 type userLevel struct {
@@ -53,27 +63,37 @@ type dateRecord struct {
 	Length int    `json:"length"`
 }
 
-func CheckEmail(email string) (bool, error) {
+func CheckEmail(ctx context.Context, email string) (bool, error) {
 	query := "SELECT EXISTS(SELECT 1 FROM user WHERE email = ?)"
 	var exists bool
-	err := mariadb.DB.QueryRow(query, email).Scan(&exists)
+	err := mariadb.DB.QueryRowContext(ctx, query, email).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func CheckUser(id int) (bool, error) {
+func CheckUser(ctx context.Context, id int) (bool, error) {
 	query := "SELECT EXISTS(SELECT 1 FROM user WHERE id = ?)"
 	var exists bool
-	err := mariadb.DB.QueryRow(query, id).Scan(&exists)
+	err := mariadb.DB.QueryRowContext(ctx, query, id).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func SignUp(user User) (int, error) {
+func GetUsername(ctx context.Context, id int) (string, error) {
+	query := "SELECT username FROM user WHERE id = ?"
+	var username string
+	err := mariadb.DB.QueryRowContext(ctx, query, id).Scan(&username)
+	if err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+func SignUp(ctx context.Context, user User) (int, error) {
 	var err error
 	// Hash password
 	if user.Password, err = hash.HashPassword(user.Password); err != nil {
@@ -83,7 +103,7 @@ func SignUp(user User) (int, error) {
 	query := `
 		INSERT INTO user (avatar, email, username, password, created_at, level, total_time) 
 		VALUES (?, ?, ?, ?, NOW(), 1, 0)`
-	result, err := mariadb.DB.Exec(query, user.Avatar, user.Email, user.Name, user.Password)
+	result, err := mariadb.DB.ExecContext(ctx, query, user.Avatar, user.Email, user.Name, user.Password)
 	if err != nil {
 		return -1, err
 	}
@@ -95,12 +115,12 @@ func SignUp(user User) (int, error) {
 	return int(id), nil
 }
 
-func Login(email string, input_pwd string) (int, error) {
+func Login(ctx context.Context, email string, input_pwd string) (int, error) {
 	// Get user password
 	var id int
 	var password string
 	query := "SELECT id, password FROM user WHERE email = ?"
-	err := mariadb.DB.QueryRow(query, email).Scan(&id, &password)
+	err := mariadb.DB.QueryRowContext(ctx, query, email).Scan(&id, &password)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return -1, nil
@@ -112,23 +132,79 @@ func Login(email string, input_pwd string) (int, error) {
 	if err != nil {
 		return -1, nil
 	}
+	// Upgrade the hash while the password is at hand, a failure only delays it
+	if hash.NeedsRehash(password) {
+		if err := rehashPassword(ctx, id, password, input_pwd); err != nil {
+			logger.WarnCtx(ctx, "Error rehashing password of user "+strconv.Itoa(id)+": "+err.Error())
+		}
+	}
 	return id, nil
 }
 
-func GetProfile(id int) (UserProfile, error) {
+// rehashPassword replaces the old hash unless the password changed meanwhile
+func rehashPassword(ctx context.Context, id int, oldHash string, password string) error {
+	newHash, err := hash.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	query := "UPDATE user SET password = ? WHERE id = ? AND password = ?"
+	_, err = mariadb.DB.ExecContext(ctx, query, newHash, id, oldHash)
+	return err
+}
+
+// ChangePassword sets a new password after checking the current one. Users
+// who signed up through a provider have none and may set one right away.
+func ChangePassword(ctx context.Context, id int, currentPassword string, newPassword string) error {
+	var password string
+	query := "SELECT password FROM user WHERE id = ?"
+	if err := mariadb.DB.QueryRowContext(ctx, query, id).Scan(&password); err != nil {
+		return err
+	}
+	if password != "" {
+		if err := hash.CheckPasswordHash(currentPassword, password); err != nil {
+			return fmt.Errorf("current password is incorrect")
+		}
+	}
+	newHash, err := hash.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	query = "UPDATE user SET password = ? WHERE id = ?"
+	_, err = mariadb.DB.ExecContext(ctx, query, newHash, id)
+	return err
+}
+
+// UpdateSettings updates only the settings that are set
+func UpdateSettings(ctx context.Context, id int, settings UserSettings) error {
+	if settings.Timezone != nil {
+		query := "UPDATE user SET timezone = ? WHERE id = ?"
+		if _, err := mariadb.DB.ExecContext(ctx, query, *settings.Timezone, id); err != nil {
+			return err
+		}
+	}
+	if settings.ProfileVisibility != nil {
+		query := "UPDATE user SET profile_visibility = ? WHERE id = ?"
+		if _, err := mariadb.DB.ExecContext(ctx, query, *settings.ProfileVisibility, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetProfile(ctx context.Context, id int) (UserProfile, error) {
 	var result UserProfile
 	// Get user info
 	query := "SELECT id, avatar, username FROM user WHERE id = ?"
-	err := mariadb.DB.QueryRow(query, id).Scan(&result.ID, &result.Avatar, &result.Name)
+	err := mariadb.DB.QueryRowContext(ctx, query, id).Scan(&result.ID, &result.Avatar, &result.Name)
 	if err != nil {
 		return result, err
 	}
 	// Get cooking time
 	query = `
-		SELECT TIMESTAMPDIFF(SECOND, created_at, NOW()) FROM record
-		WHERE user_id = ? AND status = 0
-		ORDER BY created_at DESC LIMIT 1`
-	err = mariadb.DB.QueryRow(query, id).Scan(&result.CookingTime)
+		SELECT TIMESTAMPDIFF(SECOND, r.created_at, NOW()) FROM record r
+		WHERE r.user_id = ? AND ` + activeRecord + `
+		ORDER BY r.created_at DESC LIMIT 1`
+	err = mariadb.DB.QueryRowContext(ctx, query, append([]interface{}{id}, activeRecordArgs()...)...).Scan(&result.CookingTime)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			result.CookingTime = 0
@@ -142,7 +218,7 @@ func GetProfile(id int) (UserProfile, error) {
 		INNER JOIN ingredient ON record.ingredient_id = ingredient.id
 		WHERE user_id = ?
 		ORDER BY created_at DESC LIMIT 1`
-	err = mariadb.DB.QueryRow(query, id).Scan(&result.Status.Code, &result.Status.Ingredient)
+	err = mariadb.DB.QueryRowContext(ctx, query, id).Scan(&result.Status.Code, &result.Status.Ingredient)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			result.Status.Code = 0
@@ -157,7 +233,7 @@ func GetProfile(id int) (UserProfile, error) {
 		INNER JOIN ingredient ON record.ingredient_id = ingredient.id
 		WHERE user_id = ? AND status = 1
 		ORDER BY created_at DESC LIMIT 5`
-	rows, err := mariadb.DB.Query(query, id)
+	rows, err := mariadb.DB.QueryContext(ctx, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return result, nil
@@ -176,11 +252,11 @@ func GetProfile(id int) (UserProfile, error) {
 	return result, nil
 }
 
-func GetOverview(id int) (UserOverview, error) {
+func GetOverview(ctx context.Context, id int) (UserOverview, error) {
 	var result UserOverview
 	// Get user info
 	query := "SELECT id, level, total_time FROM user WHERE id = ?"
-	err := mariadb.DB.QueryRow(query, id).Scan(&result.ID, &result.Level.Level, &result.Level.TotalTime)
+	err := mariadb.DB.QueryRowContext(ctx, query, id).Scan(&result.ID, &result.Level.Level, &result.Level.TotalTime)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return result, fmt.Errorf("user %d does not exist", id)
@@ -188,33 +264,38 @@ func GetOverview(id int) (UserOverview, error) {
 		return result, err
 	}
 	// Get next level
-	result.Level.Next, err = getNextLevel(result.Level.Level)
+	result.Level.Next, err = getNextLevel(ctx, result.Level.Level)
 	// Get today
-	result.Today, err = getToday(id)
+	result.Today, err = getToday(ctx, id)
 	if err != nil {
 		return result, err
 	}
 	// Get week
-	result.Week, err = getWeekInterval(id)
+	result.Week, err = getWeekInterval(ctx, id, time.Now().Format(dateLayout))
 	if err != nil {
 		return result, err
 	}
 	// Get month
-	result.Month, err = getMonthInterval(id)
+	result.Month, err = getMonthInterval(ctx, id)
+	if err != nil {
+		return result, err
+	}
+	// Get goal progress
+	result.Goals, err = getUserGoalProgress(ctx, id)
 	if err != nil {
 		return result, err
 	}
 	return result, nil
 }
 
-func getToday(id int) ([]todayRecord, error) {
+func getToday(ctx context.Context, id int) ([]todayRecord, error) {
 	var records []todayRecord
 	query := `
 		SELECT record.id, ingredient.image 
 		FROM record INNER JOIN ingredient 
 		ON record.ingredient_id = ingredient.id 
 		WHERE user_id = ? AND DATE(finish_time) = CURDATE()`
-	rows, err := mariadb.DB.Query(query, id)
+	rows, err := mariadb.DB.QueryContext(ctx, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return records, nil
@@ -233,16 +314,17 @@ func getToday(id int) ([]todayRecord, error) {
 	return records, nil
 }
 
-func getWeekInterval(id int) ([]dateRecord, error) {
+// getWeekInterval returns the daily focus time of the week containing day
+func getWeekInterval(ctx context.Context, id int, day string) ([]dateRecord, error) {
 	var records []dateRecord
 	query := `
 		SELECT 
 			DATE(created_at) AS date,
 			SUM(time_interval) AS total_time
 		FROM record
-		WHERE WEEK(created_at) = WEEK(NOW()) AND user_id = ?
+		WHERE YEARWEEK(created_at, 1) = YEARWEEK(?, 1) AND user_id = ?
 		GROUP BY DATE(created_at);`
-	rows, err := mariadb.DB.Query(query, id)
+	rows, err := mariadb.DB.QueryContext(ctx, query, day, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return records, nil
@@ -261,16 +343,16 @@ func getWeekInterval(id int) ([]dateRecord, error) {
 	return records, nil
 }
 
-func getMonthInterval(id int) ([]dateRecord, error) {
+func getMonthInterval(ctx context.Context, id int) ([]dateRecord, error) {
 	var records []dateRecord
 	query := `
 		SELECT 
 			DATE(created_at) AS date,
 			SUM(time_interval) AS total_time
 		FROM record
-		WHERE MONTH(created_at) = MONTH(NOW()) AND user_id = ?
+		WHERE EXTRACT(YEAR_MONTH FROM created_at) = EXTRACT(YEAR_MONTH FROM NOW()) AND user_id = ?
 		GROUP BY DATE(created_at);`
-	rows, err := mariadb.DB.Query(query, id)
+	rows, err := mariadb.DB.QueryContext(ctx, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return records, nil
@@ -289,13 +371,13 @@ func getMonthInterval(id int) ([]dateRecord, error) {
 	return records, nil
 }
 
-func getNextLevel(level int) (string, error) {
+func getNextLevel(ctx context.Context, level int) (string, error) {
 	query := `
 		SELECT image FROM ingredient
 		WHERE requirement = "level%d"`
 	query = fmt.Sprintf(query, level+1)
 	var image string
-	err := mariadb.DB.QueryRow(query).Scan(&image)
+	err := mariadb.DB.QueryRowContext(ctx, query).Scan(&image)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", nil